@@ -0,0 +1,47 @@
+/**
+ * Alerts - Feeds Moblin telemetry into the alert service and broadcasts
+ * alert changes on the alerts topic
+ */
+
+package main
+
+import (
+	"encoding/json"
+
+	"github.com/volleybratans/moblin-relay/models"
+	"github.com/volleybratans/moblin-relay/protocol"
+)
+
+// broadcastAlert sends a fired, resolved or acknowledged alert to browsers
+func (r *Relay) broadcastAlert(alert models.Alert) {
+	data, _ := json.Marshal(alert)
+	msg, _ := json.Marshal(Message{Type: protocol.TypeAlert, Data: data})
+	r.Broadcast(protocol.TopicAlerts, msg)
+}
+
+// handleAlertAck acknowledges an alert on behalf of a browser
+func (c *Client) handleAlertAck(env protocol.Envelope, raw []byte) {
+	ack, err := protocol.ParseAlertAck(raw)
+	if err != nil {
+		c.sendError(err, env)
+		return
+	}
+	if c.Relay.alerts == nil {
+		c.sendJSON(Message{Type: protocol.TypeError, Status: "error", Code: protocol.CodeInvalidParams, Command: env.Type, Ref: env.Ref, Message: "Alerts are disabled"})
+		return
+	}
+	if _, err := c.Relay.alerts.Acknowledge(ack.ID); err != nil {
+		c.sendJSON(Message{Type: protocol.TypeError, Status: "error", Code: protocol.CodeInvalidParams, Command: env.Type, Ref: env.Ref, Message: err.Error()})
+	}
+}
+
+// sendAlerts sends all firing alerts so late joiners see them immediately
+func (c *Client) sendAlerts() {
+	if c.Relay.alerts == nil || !c.IsSubscribed(protocol.TopicAlerts) {
+		return
+	}
+	for _, alert := range c.Relay.alerts.Active() {
+		data, _ := json.Marshal(alert)
+		c.sendJSON(Message{Type: protocol.TypeAlert, Data: data})
+	}
+}
@@ -0,0 +1,153 @@
+/**
+ * WebSocket Authentication - HMAC challenge/response handshake
+ * The relay sends a challenge and salt in its hello; clients answer with
+ * protocol.AuthResponse and are disconnected if they don't within the timeout.
+ * Browsers with a valid vb_session cookie are authorized on upgrade.
+ */
+
+package main
+
+import (
+	"log"
+	"net/http"
+	"time"
+
+	"github.com/gorilla/websocket"
+	"github.com/volleybratans/moblin-relay/protocol"
+	"github.com/volleybratans/moblin-relay/services"
+)
+
+const (
+	DefaultAuthTimeout = 10 * time.Second
+	maxAuthFailures    = 5
+	authFailureWindow  = time.Minute
+)
+
+// handleAuth verifies a challenge response and authorizes the client
+func (c *Client) handleAuth(msg Message) {
+	r := c.Relay
+	if c.isAuthorized() {
+		c.sendJSON(Message{Type: "auth_success", Status: "ok"})
+		return
+	}
+
+	if c.tooManyAuthFailures() {
+		log.Printf("[AUTH] Too many failed WebSocket logins from %s", c.RemoteIP)
+		c.sendJSON(Message{Type: "auth_failed", Status: "error", Message: "Too many failed attempts"})
+		c.closeWith(websocket.ClosePolicyViolation, "too many failed attempts")
+		return
+	}
+
+	if !protocol.VerifyAuthResponse(r.password, r.salt, c.challenge, msg.Response) {
+		c.recordAuthFailure()
+		log.Printf("[AUTH] WebSocket authentication failed: %s (%s)", c.ID, c.RemoteIP)
+		c.sendJSON(Message{Type: "auth_failed", Status: "error", Message: "Invalid password"})
+		return
+	}
+
+	c.mu.Lock()
+	c.Authorized = true
+	c.mu.Unlock()
+	c.sendJSON(Message{Type: "auth_success", Status: "ok"})
+	switch c.Type {
+	case ClientTypeBrowser:
+		c.sendSnapshot()
+		c.sendAlerts()
+		c.sendPresence()
+		c.sendControl()
+		c.sendSams()
+	case ClientTypeMoblin:
+		r.flushQueue(c)
+	}
+}
+
+// tooManyAuthFailures reports whether the client's address has failed to
+// log in too often; relay and native Moblin logins share the limit
+func (c *Client) tooManyAuthFailures() bool {
+	auth := c.Relay.auth
+	return auth != nil && auth.RateLimiter.Count(c.authFailureKey(), authFailureWindow) >= maxAuthFailures
+}
+
+// recordAuthFailure counts a failed login of the client's address
+func (c *Client) recordAuthFailure() {
+	if auth := c.Relay.auth; auth != nil {
+		auth.RateLimiter.Allow(c.authFailureKey(), maxAuthFailures, authFailureWindow)
+	}
+}
+
+func (c *Client) authFailureKey() string {
+	return c.RemoteIP + ":ws-auth"
+}
+
+// enforceAuthTimeout disconnects the client if it has not authenticated in time
+func (c *Client) enforceAuthTimeout(timeout time.Duration) {
+	time.AfterFunc(timeout, func() {
+		if !c.isAuthorized() {
+			log.Printf("[AUTH] %s did not authenticate within %s", c.ID, timeout)
+			c.closeWith(websocket.ClosePolicyViolation, "authentication timeout")
+		}
+	})
+}
+
+// closeWith sends a close frame and closes the connection; readPump
+// then unregisters the client
+func (c *Client) closeWith(code int, reason string) {
+	c.Conn.WriteControl(websocket.CloseMessage, websocket.FormatCloseMessage(code, reason), time.Now().Add(time.Second))
+	c.Conn.Close()
+}
+
+// sessionFromRequest returns the ID of a valid login session sent with the
+// upgrade request, or "" if there is none
+func (r *Relay) sessionFromRequest(req *http.Request) string {
+	if r.auth == nil {
+		return ""
+	}
+	sessionID := services.GetSessionID(req)
+	if sessionID == "" || r.auth.SessionStore.Get(sessionID) == nil {
+		return ""
+	}
+	r.auth.SessionStore.Touch(sessionID)
+	return sessionID
+}
+
+// closeSession disconnects all sockets authorized by a session that was
+// logged out or expired
+func (r *Relay) closeSession(sessionID string) {
+	r.mu.RLock()
+	var clients []*Client
+	for _, client := range r.clients {
+		if client.SessionID == sessionID {
+			clients = append(clients, client)
+		}
+	}
+	r.mu.RUnlock()
+	for _, client := range clients {
+		log.Printf("[AUTH] Session of %s ended, closing socket", client.ID)
+		client.closeWith(websocket.ClosePolicyViolation, "session ended")
+	}
+}
+
+// sessionLoop catches sessions that expired between store cleanups
+func (r *Relay) sessionLoop() {
+	for range time.NewTicker(time.Minute).C {
+		r.mu.RLock()
+		var ended []string
+		for _, client := range r.clients {
+			if client.SessionID != "" && r.auth.SessionStore.Get(client.SessionID) == nil {
+				ended = append(ended, client.SessionID)
+			}
+		}
+		r.mu.RUnlock()
+		for _, sessionID := range ended {
+			r.closeSession(sessionID)
+		}
+	}
+}
+
+// shortID shortens session IDs for log output
+func shortID(id string) string {
+	if len(id) > 8 {
+		return id[:8] + "…"
+	}
+	return id
+}
@@ -0,0 +1,61 @@
+/**
+ * Automation - Publishes relay-side events to the event bus that drives
+ * automation rules, and accepts match events logged by operators
+ */
+
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+
+	"github.com/volleybratans/moblin-relay/events"
+	"github.com/volleybratans/moblin-relay/protocol"
+)
+
+// publish hands an event to the event bus, if there is one
+func (r *Relay) publish(ev events.Event) {
+	if r.events != nil {
+		r.events.Publish(ev)
+	}
+}
+
+// publishBroadcast publishes a topic broadcast under its message type
+func (r *Relay) publishBroadcast(msg []byte) {
+	if r.events == nil {
+		return
+	}
+	var env struct {
+		Type string `json:"type"`
+	}
+	if json.Unmarshal(msg, &env) != nil || env.Type == "" {
+		return
+	}
+	r.publish(events.Event{Type: env.Type, Data: msg})
+}
+
+// LogMatchEvent records a match event (set end, timeout, ...) for
+// automation rules and tells subscribed browsers and overlays about it
+func (r *Relay) LogMatchEvent(event string) error {
+	ev := protocol.MatchEvent{Event: event}
+	if !protocol.IsMatchEvent(event) {
+		return fmt.Errorf("unknown match event %q", event)
+	}
+	log.Printf("[RELAY] Match event: %s", event)
+	data, _ := protocol.Encode(protocol.TypeMatchEvent, ev, nil)
+	r.Broadcast(protocol.TopicMatchday, data)
+	r.publish(events.Event{Type: event})
+	return nil
+}
+
+// handleMatchEvent logs a match event sent by a browser
+func (c *Client) handleMatchEvent(env protocol.Envelope, raw []byte) {
+	ev, err := protocol.ParseMatchEvent(raw)
+	if err != nil {
+		c.sendError(err, env)
+		return
+	}
+	c.Relay.LogMatchEvent(ev.Event)
+	c.sendJSON(Message{Type: protocol.TypeMatchEvent, Status: "ok", Ref: env.Ref})
+}
@@ -0,0 +1,107 @@
+/**
+ * Capture - Records all WebSocket frames for debugging and replays
+ * Enabled with -capture; see cmd/relay-replay for playing a capture back.
+ */
+
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/url"
+
+	"github.com/volleybratans/moblin-relay/capture"
+	"github.com/volleybratans/moblin-relay/protocol"
+)
+
+// redactedQuery lists query parameters that are not written to captures
+var redactedQuery = []string{"token", "password"}
+
+// captureOpen records a new connection
+func (c *Client) captureOpen(req *http.Request) {
+	if c.Relay.capture == nil {
+		return
+	}
+	path := redactQuery(req.URL)
+	c.Relay.capture.Record(capture.Frame{Dir: capture.Open, Client: c.ID, Kind: string(c.Type), Device: c.Device, Path: path})
+}
+
+// redactQuery returns the path and query of u without redactedQuery parameters
+func redactQuery(u *url.URL) string {
+	query := u.Query()
+	for _, key := range redactedQuery {
+		query.Del(key)
+	}
+	path := u.Path
+	if len(query) > 0 {
+		path += "?" + query.Encode()
+	}
+	return path
+}
+
+// captureFrame records a frame received from (capture.In) or written to
+// (capture.Out) the client
+func (c *Client) captureFrame(dir string, frame []byte) {
+	if c.Relay.capture == nil {
+		return
+	}
+	if dir == capture.In {
+		frame = redactCredentials(frame)
+	}
+	c.Relay.capture.Record(capture.Frame{Dir: dir, Client: c.ID, Data: capture.Payload(frame)})
+}
+
+// captureClose records a disconnect
+func (c *Client) captureClose() {
+	if c.Relay.capture == nil {
+		return
+	}
+	c.Relay.capture.Record(capture.Frame{Dir: capture.Close, Client: c.ID})
+}
+
+// redactedAuthKeys are the credential-bearing fields of relay auth
+// messages: the challenge response, and the password of clients that
+// still send it in plain text
+var redactedAuthKeys = []string{"response", "password"}
+
+// redactCredentials blanks the credentials of relay auth messages and the
+// response of native Moblin identify messages. Fields the frame does not
+// have are not added.
+func redactCredentials(frame []byte) []byte {
+	var fields map[string]json.RawMessage
+	if json.Unmarshal(frame, &fields) != nil {
+		return frame
+	}
+	switch {
+	case string(fields["type"]) == `"`+protocol.TypeAuth+`"`:
+		if !redactKeys(fields, redactedAuthKeys) {
+			return frame
+		}
+	case fields["identify"] != nil:
+		var identify map[string]json.RawMessage
+		if json.Unmarshal(fields["identify"], &identify) != nil || !redactKeys(identify, []string{"authentication"}) {
+			return frame
+		}
+		fields["identify"], _ = json.Marshal(identify)
+	default:
+		return frame
+	}
+	redacted, err := json.Marshal(fields)
+	if err != nil {
+		return frame
+	}
+	return redacted
+}
+
+// redactKeys blanks the given keys that exist in fields and reports
+// whether any did
+func redactKeys(fields map[string]json.RawMessage, keys []string) bool {
+	redacted := false
+	for _, key := range keys {
+		if _, ok := fields[key]; ok {
+			fields[key] = json.RawMessage(`"[redacted]"`)
+			redacted = true
+		}
+	}
+	return redacted
+}
@@ -0,0 +1,135 @@
+// Package capture records WebSocket traffic of the relay as JSON lines and
+// reads it back for replays. A capture holds one Frame per line: "open"
+// when a client connects, "in" and "out" for every frame received from or
+// written to it, and "close" when it disconnects.
+package capture
+
+import (
+	"bufio"
+	"encoding/json"
+	"os"
+	"sync"
+	"time"
+)
+
+// Frame directions
+const (
+	Open  = "open"
+	In    = "in"
+	Out   = "out"
+	Close = "close"
+)
+
+// Frame is one recorded event of a client connection
+type Frame struct {
+	Time   time.Time `json:"t"`
+	Dir    string    `json:"dir"`
+	Client string    `json:"client"`
+	// Kind, Device and Path describe the connection on "open"; Path is the
+	// endpoint including the query string (without secrets)
+	Kind   string `json:"kind,omitempty"`
+	Device string `json:"device,omitempty"`
+	Path   string `json:"path,omitempty"`
+	// Data is the frame itself; frames that are not JSON are stored as strings
+	Data json.RawMessage `json:"data,omitempty"`
+}
+
+// Type returns the "type" field of the frame's message. Native Moblin
+// messages have no type; their single top-level key (hello, event, ...)
+// is returned instead.
+func (f Frame) Type() string {
+	var fields map[string]json.RawMessage
+	if json.Unmarshal(f.Data, &fields) != nil {
+		return ""
+	}
+	if raw, ok := fields["type"]; ok {
+		var t string
+		json.Unmarshal(raw, &t)
+		return t
+	}
+	if len(fields) == 1 {
+		for key := range fields {
+			return key
+		}
+	}
+	return ""
+}
+
+// Recorder appends frames to a capture file. A nil Recorder records nothing.
+type Recorder struct {
+	file *os.File
+	mu   sync.Mutex
+}
+
+// NewRecorder creates or appends to the capture file at path
+func NewRecorder(path string) (*Recorder, error) {
+	file, err := os.OpenFile(path, os.O_CREATE|os.O_APPEND|os.O_WRONLY, 0600)
+	if err != nil {
+		return nil, err
+	}
+	return &Recorder{file: file}, nil
+}
+
+// Record writes a frame, stamping it with the current time if unset
+func (r *Recorder) Record(f Frame) {
+	if r == nil {
+		return
+	}
+	if f.Time.IsZero() {
+		f.Time = time.Now().UTC()
+	}
+	line, err := json.Marshal(f)
+	if err != nil {
+		return
+	}
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if r.file != nil {
+		r.file.Write(append(line, '\n'))
+	}
+}
+
+// Close closes the capture file; later frames are dropped
+func (r *Recorder) Close() error {
+	if r == nil {
+		return nil
+	}
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if r.file == nil {
+		return nil
+	}
+	err := r.file.Close()
+	r.file = nil
+	return err
+}
+
+// Payload wraps a raw WebSocket frame for Frame.Data
+func Payload(frame []byte) json.RawMessage {
+	if json.Valid(frame) {
+		return append(json.RawMessage(nil), frame...)
+	}
+	data, _ := json.Marshal(string(frame))
+	return data
+}
+
+// ReadFile reads all frames of a capture, skipping malformed lines
+func ReadFile(path string) ([]Frame, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+
+	var frames []Frame
+	scanner := bufio.NewScanner(file)
+	scanner.Buffer(make([]byte, 64*1024), 4*1024*1024)
+	for scanner.Scan() {
+		var f Frame
+		if err := json.Unmarshal(scanner.Bytes(), &f); err != nil {
+			continue
+		}
+		frames = append(frames, f)
+	}
+	return frames, scanner.Err()
+}
@@ -0,0 +1,180 @@
+package capture
+
+import (
+	"encoding/json"
+	"log"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gorilla/websocket"
+)
+
+// Replay reconnects the clients of a capture to a relay, sends their
+// captured frames and collects what the relay sends back
+type Replay struct {
+	// Target is the relay base URL, e.g. ws://localhost:8080
+	Target string
+	// OverlayToken is appended to overlay connections of the capture
+	OverlayToken string
+	// Speed scales the captured timing; 0 sends without delays and keeps
+	// connections open
+	Speed float64
+	// Recorder, if set, records the replayed traffic
+	Recorder *Recorder
+
+	clients map[string]*replayClient
+	order   []string
+}
+
+// Result compares what a captured client received with the replay
+type Result struct {
+	Client   string
+	Kind     string
+	Device   string
+	Expected []string // types of captured outbound frames
+	Received []string // types of frames the relay sent in the replay
+}
+
+// replayClient is a captured client reconnected to the target relay
+type replayClient struct {
+	open     Frame
+	conn     *websocket.Conn
+	expected []string
+	received []string
+	mu       sync.Mutex
+}
+
+// Run plays all frames in capture order with the captured timing
+func (r *Replay) Run(frames []Frame) {
+	if len(frames) == 0 {
+		return
+	}
+	r.Target = strings.TrimSuffix(r.Target, "/")
+	if r.clients == nil {
+		r.clients = make(map[string]*replayClient)
+	}
+	start := time.Now()
+	first := frames[0].Time
+	for _, f := range frames {
+		if r.Speed > 0 {
+			due := start.Add(time.Duration(float64(f.Time.Sub(first)) / r.Speed))
+			time.Sleep(time.Until(due))
+		}
+
+		client := r.clients[f.Client]
+		switch f.Dir {
+		case Open:
+			client = &replayClient{open: f}
+			r.clients[f.Client] = client
+			r.order = append(r.order, f.Client)
+			r.connect(f.Client, client)
+		case In:
+			if client != nil && client.conn != nil {
+				client.conn.WriteMessage(websocket.TextMessage, f.Raw())
+			}
+		case Out:
+			if client != nil {
+				client.expected = append(client.expected, f.Type())
+			}
+		case Close:
+			// Without delays, replies would be cut off by an early close
+			if client != nil && client.conn != nil && r.Speed > 0 {
+				client.conn.Close()
+			}
+		}
+	}
+}
+
+// Close closes the connections that are still open
+func (r *Replay) Close() {
+	for _, client := range r.clients {
+		if client.conn != nil {
+			client.conn.Close()
+		}
+	}
+}
+
+// Results returns the comparison per client in the order they connected
+func (r *Replay) Results() []Result {
+	results := make([]Result, 0, len(r.order))
+	for _, id := range r.order {
+		client := r.clients[id]
+		client.mu.Lock()
+		received := append([]string(nil), client.received...)
+		client.mu.Unlock()
+		results = append(results, Result{
+			Client:   id,
+			Kind:     client.open.Kind,
+			Device:   client.open.Device,
+			Expected: client.expected,
+			Received: received,
+		})
+	}
+	return results
+}
+
+// FirstDifference returns the index of the first frame whose type differs
+// between the capture and the replay, and whether there is one
+func (res Result) FirstDifference() (int, bool) {
+	for i := 0; i < len(res.Expected) || i < len(res.Received); i++ {
+		if typeAt(res.Expected, i) != typeAt(res.Received, i) {
+			return i, true
+		}
+	}
+	return 0, false
+}
+
+// connect dials the client's endpoint and collects what the relay sends
+func (r *Replay) connect(id string, client *replayClient) {
+	path := client.open.Path
+	if path == "" {
+		path = "/ws"
+	}
+	if client.open.Kind == "overlay" && r.OverlayToken != "" {
+		separator := "?"
+		if strings.Contains(path, "?") {
+			separator = "&"
+		}
+		path += separator + "token=" + r.OverlayToken
+	}
+	conn, _, err := websocket.DefaultDialer.Dial(r.Target+path, nil)
+	if err != nil {
+		log.Printf("[REPLAY] %s: %v", id, err)
+		return
+	}
+	client.conn = conn
+	r.Recorder.Record(Frame{Dir: Open, Client: id, Kind: client.open.Kind, Device: client.open.Device, Path: path})
+	go func() {
+		defer r.Recorder.Record(Frame{Dir: Close, Client: id})
+		for {
+			_, message, err := conn.ReadMessage()
+			if err != nil {
+				return
+			}
+			f := Frame{Dir: Out, Client: id, Data: Payload(message)}
+			r.Recorder.Record(f)
+			client.mu.Lock()
+			client.received = append(client.received, f.Type())
+			client.mu.Unlock()
+		}
+	}()
+}
+
+// Raw returns the frame as sent on the wire; non-JSON frames were
+// captured as JSON strings
+func (f Frame) Raw() []byte {
+	var s string
+	if len(f.Data) > 0 && f.Data[0] == '"' && json.Unmarshal(f.Data, &s) == nil {
+		return []byte(s)
+	}
+	return f.Data
+}
+
+// typeAt returns the i-th type, or <none> past the end
+func typeAt(types []string, i int) string {
+	if i < len(types) {
+		return types[i]
+	}
+	return "<none>"
+}
@@ -0,0 +1,116 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/volleybratans/moblin-relay/capture"
+)
+
+func TestRedactCredentials(t *testing.T) {
+	tests := []struct {
+		name  string
+		frame string
+		want  map[string]any // nil: frame is unchanged
+	}{
+		{
+			name:  "auth response",
+			frame: `{"type":"auth","response":"3f2a"}`,
+			want:  map[string]any{"type": "auth", "response": "[redacted]"},
+		},
+		{
+			name:  "plain text password",
+			frame: `{"type":"auth","password":"secret","response":"3f2a"}`,
+			want:  map[string]any{"type": "auth", "password": "[redacted]", "response": "[redacted]"},
+		},
+		{
+			name:  "moblin identify",
+			frame: `{"identify":{"authentication":"abcd"}}`,
+			want:  map[string]any{"identify": map[string]any{"authentication": "[redacted]"}},
+		},
+		{name: "auth without credentials", frame: `{"type":"auth"}`},
+		{name: "identify without authentication", frame: `{"identify":{}}`},
+		{name: "other message", frame: `{"type":"set_bitrate","kbps":5000,"response":"kept"}`},
+		{name: "not json", frame: `ping`},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := redactCredentials([]byte(tt.frame))
+			if tt.want == nil {
+				if string(got) != tt.frame {
+					t.Errorf("frame changed to %s", got)
+				}
+				return
+			}
+			var fields map[string]any
+			if err := json.Unmarshal(got, &fields); err != nil {
+				t.Fatal(err)
+			}
+			if !reflect.DeepEqual(fields, tt.want) {
+				t.Errorf("redacted %s, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestRedactQuery(t *testing.T) {
+	tests := []struct {
+		url  string
+		want string
+	}{
+		{"/ws", "/ws"},
+		{"/ws?type=moblin&device=main", "/ws?device=main&type=moblin"},
+		{"/ws?type=overlay&token=abc", "/ws?type=overlay"},
+		{"/ws?password=secret&token=abc", "/ws"},
+	}
+	for _, tt := range tests {
+		u, err := url.Parse(tt.url)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got := redactQuery(u); got != tt.want {
+			t.Errorf("redactQuery(%s) = %s, want %s", tt.url, got, tt.want)
+		}
+	}
+}
+
+// TestReplayCapture replays testdata/capture.jsonl against a relay without
+// a password and compares the message types every client receives
+func TestReplayCapture(t *testing.T) {
+	frames, err := capture.ReadFile("testdata/capture.jsonl")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	relay := NewRelay(RelayConfig{PingInterval: time.Hour})
+	go relay.Run()
+	mux := http.NewServeMux()
+	mux.HandleFunc("/ws", relay.ServeWS)
+	mux.HandleFunc("/moblin", relay.ServeMoblin)
+	server := httptest.NewServer(mux)
+	defer server.Close()
+
+	replay := &capture.Replay{Target: "ws" + strings.TrimPrefix(server.URL, "http"), Speed: 1}
+	replay.Run(frames)
+	time.Sleep(300 * time.Millisecond)
+	replay.Close()
+
+	results := replay.Results()
+	if len(results) != 2 {
+		t.Fatalf("%d clients replayed, want 2", len(results))
+	}
+	for _, res := range results {
+		if len(res.Expected) == 0 {
+			t.Errorf("%s: no captured frames", res.Client)
+		}
+		if i, differs := res.FirstDifference(); differs {
+			t.Errorf("%s (%s): first difference at frame %d\ncaptured %v\nreplayed %v", res.Client, res.Kind, i+1, res.Expected, res.Received)
+		}
+	}
+}
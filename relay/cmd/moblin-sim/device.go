@@ -0,0 +1,257 @@
+package main
+
+import (
+	"fmt"
+	"math"
+	"math/rand"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/volleybratans/moblin-relay/protocol"
+)
+
+// thermalSteps is the order the phone heats up in
+var thermalSteps = []string{"nominal", "fair", "serious", "critical"}
+
+// scenario models the capacity of the phone's uplinks over time
+type scenario struct {
+	description string
+	links       func(elapsed time.Duration) map[string]link
+}
+
+// link is the momentary capacity of one uplink
+type link struct {
+	kbps int
+	rtt  int
+}
+
+var scenarios = map[string]scenario{
+	"stable": {
+		description: "LTE and WiFi with small jitter",
+		links: func(time.Duration) map[string]link {
+			return map[string]link{"lte": {8000, 45}, "wifi": {4000, 15}}
+		},
+	},
+	"lte-fluctuation": {
+		description: "LTE swings between 1.5 and 8 Mbps every 2 minutes, WiFi steady",
+		links: func(elapsed time.Duration) map[string]link {
+			phase := math.Sin(2 * math.Pi * elapsed.Seconds() / 120)
+			kbps := 4750 + int(3250*phase)
+			return map[string]link{"lte": {kbps, 140 - kbps/80}, "wifi": {2500, 20}}
+		},
+	},
+	"wifi-dropout": {
+		description: "WiFi drops out for 20s every minute, LTE steady",
+		links: func(elapsed time.Duration) map[string]link {
+			links := map[string]link{"lte": {5000, 55}}
+			if int(elapsed.Seconds())%60 < 40 {
+				links["wifi"] = link{4000, 15}
+			}
+			return links
+		},
+	},
+	"tunnel": {
+		description: "Both links nearly lost for 15s every 90s, e.g. the bus through a tunnel",
+		links: func(elapsed time.Duration) map[string]link {
+			if int(elapsed.Seconds())%90 >= 75 {
+				return map[string]link{"lte": {300, 900}, "wifi": {0, 0}}
+			}
+			return map[string]link{"lte": {7000, 50}, "wifi": {3000, 18}}
+		},
+	},
+}
+
+// scenarioNames lists the scenarios for -help
+func scenarioNames() string {
+	names := make([]string, 0, len(scenarios))
+	for name, s := range scenarios {
+		names = append(names, fmt.Sprintf("%s (%s)", name, s.description))
+	}
+	sort.Strings(names)
+	return strings.Join(names, ", ")
+}
+
+// device is the simulated phone
+type device struct {
+	scenario  scenario
+	drain     float64       // battery percent per minute while live
+	heatStep  time.Duration // time per thermal step while live; 0 keeps it constant
+	started   time.Time
+	lastTick  time.Time
+	heat      time.Duration // accumulated live time towards the next thermal step
+	battery   float64
+	thermal   int
+	live      bool
+	recording bool
+	muted     bool
+	torch     bool
+	scene     string
+	zoom      float64
+	bitrate   int // configured video bitrate
+	viewers   int
+	rnd       *rand.Rand
+	mu        sync.Mutex
+}
+
+func newDevice(sc scenario, battery, drain float64, thermal string, heatStep time.Duration) *device {
+	now := time.Now()
+	d := &device{
+		scenario: sc,
+		drain:    drain,
+		heatStep: heatStep,
+		started:  now,
+		lastTick: now,
+		battery:  battery,
+		scene:    "main",
+		zoom:     1,
+		bitrate:  6000,
+		rnd:      rand.New(rand.NewSource(now.UnixNano())),
+	}
+	for i, state := range thermalSteps {
+		if state == thermal {
+			d.thermal = i
+		}
+	}
+	return d
+}
+
+// event is a message the phone sends to the relay
+type event map[string]interface{}
+
+// apply executes a command and returns the events it causes, or an error
+// message for command_failed
+func (d *device) apply(cmd protocol.Command) ([]event, string) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	switch c := cmd.(type) {
+	case *protocol.SetScene:
+		d.scene = c.Name
+		return []event{{"type": protocol.TypeSceneChanged, "scene": c.Name}}, ""
+	case *protocol.SetBitrate:
+		d.bitrate = c.Kbps
+	case *protocol.SetZoom:
+		d.zoom = c.Level
+	case *protocol.GoLive:
+		if d.live {
+			return nil, "Already live"
+		}
+		d.live = true
+		d.viewers = 0
+		return []event{{"type": protocol.TypeStreamStarted}}, ""
+	case *protocol.End:
+		if !d.live {
+			return nil, "Not live"
+		}
+		d.live = false
+		return []event{{"type": protocol.TypeStreamEnded}}, ""
+	case *protocol.ToggleMic:
+		d.muted = !d.muted
+		return []event{{"type": protocol.TypeMicState, "muted": d.muted}}, ""
+	case *protocol.ToggleTorch:
+		d.torch = !d.torch
+		return []event{{"type": protocol.TypeTorchState, "enabled": d.torch}}, ""
+	case *protocol.ToggleRecording:
+		d.recording = !d.recording
+		return []event{{"type": protocol.TypeRecordingState, "recording": d.recording}}, ""
+	case *protocol.Snapshot:
+	}
+	return nil, ""
+}
+
+// tick advances battery, heat and audience and returns the events to send:
+// stream_info, plus thermal_update when the thermal state changed
+func (d *device) tick() []event {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	now := time.Now()
+	dt := now.Sub(d.lastTick)
+	d.lastTick = now
+
+	// Streaming drains the battery fastest, the idle camera a quarter of that
+	drain := d.drain / 4
+	if d.live {
+		drain = d.drain
+	}
+	d.battery = math.Max(0, d.battery-drain*dt.Minutes())
+
+	var events []event
+	if d.heatStep > 0 {
+		previous := d.thermal
+		if d.live {
+			d.heat += dt
+		} else {
+			d.heat -= dt
+		}
+		switch {
+		case d.heat >= d.heatStep && d.thermal < len(thermalSteps)-1:
+			d.thermal++
+			d.heat = 0
+		case d.heat <= -d.heatStep && d.thermal > 0:
+			d.thermal--
+			d.heat = 0
+		case d.heat >= d.heatStep || d.heat <= -d.heatStep:
+			d.heat = 0
+		}
+		if d.thermal != previous {
+			events = append(events, event{"type": protocol.TypeThermalUpdate, "thermal_state": thermalSteps[d.thermal]})
+		}
+	}
+
+	uploads := make(map[string]protocol.LinkStats)
+	capacity := 0
+	for name, l := range d.scenario.links(now.Sub(d.started)) {
+		if l.kbps == 0 {
+			continue
+		}
+		stats := protocol.LinkStats{Kbps: d.jitter(l.kbps, 0.08), RTT: d.jitter(l.rtt, 0.15)}
+		capacity += stats.Kbps
+		if d.live {
+			uploads[name] = stats
+		}
+	}
+
+	bitrate, fps := 0, 0
+	if d.live {
+		// The encoder adapts to ~90% of the available uplink
+		bitrate = int(math.Min(float64(d.bitrate), float64(capacity)*0.9))
+		fps = 30
+		if thermalSteps[d.thermal] == "critical" {
+			// iOS throttles the camera when the phone is too hot
+			fps = 24
+			bitrate = bitrate * 2 / 3
+		}
+		if d.rnd.Float64() < 0.3 {
+			d.viewers = max(0, d.viewers+d.rnd.Intn(5)-1)
+		}
+	}
+
+	info := event{
+		"type":          protocol.TypeStreamInfo,
+		"bitrate":       bitrate,
+		"fps":           fps,
+		"battery":       int(math.Round(d.battery)),
+		"viewers":       d.viewers,
+		"thermal_state": thermalSteps[d.thermal],
+	}
+	if len(uploads) > 0 {
+		info["upload_stats"] = uploads
+	}
+	return append(events, info)
+}
+
+// jitter varies v by up to ±fraction
+func (d *device) jitter(v int, fraction float64) int {
+	return int(float64(v) * (1 + fraction*(2*d.rnd.Float64()-1)))
+}
+
+// summary describes the state for the log
+func (d *device) summary() string {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	return fmt.Sprintf("live=%v scene=%s bitrate=%d zoom=%.1f battery=%.0f%% thermal=%s",
+		d.live, d.scene, d.bitrate, d.zoom, d.battery, thermalSteps[d.thermal])
+}
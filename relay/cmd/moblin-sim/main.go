@@ -0,0 +1,283 @@
+// Command moblin-sim is a simulated Moblin phone for developing the control
+// panel without an iPhone. It connects to the relay as a Moblin device
+// (/ws?type=moblin), answers commands with realistic state changes and
+// sends stream_info with draining battery, rising heat while live and
+// fluctuating LTE/WiFi uplinks.
+//
+//	moblin-sim -url ws://localhost:8080 -device main -scenario lte-fluctuation -live
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"log"
+	"math/rand"
+	"net/url"
+	"os"
+	"os/signal"
+	"strings"
+	"sync"
+	"syscall"
+	"time"
+
+	"github.com/gorilla/websocket"
+	"github.com/volleybratans/moblin-relay/protocol"
+)
+
+// options are the command line settings of the simulator
+type options struct {
+	url      string
+	device   string
+	password string
+	interval time.Duration
+	latency  time.Duration
+	failRate float64
+	live     bool
+	// Delay of pongs, to simulate a slow control link
+	linkDelay time.Duration
+}
+
+func main() {
+	var opts options
+	flag.StringVar(&opts.url, "url", "ws://localhost:8080", "Relay base URL")
+	flag.StringVar(&opts.device, "device", "main", "Device name")
+	flag.StringVar(&opts.password, "password", "", "Relay password")
+	flag.DurationVar(&opts.interval, "interval", 2*time.Second, "Interval of stream_info updates")
+	flag.DurationVar(&opts.latency, "latency", 150*time.Millisecond, "Delay before answering commands")
+	flag.DurationVar(&opts.linkDelay, "link-delay", 0, "Delay before answering relay pings, plus up to 50% jitter")
+	flag.Float64Var(&opts.failRate, "fail-rate", 0, "Fraction of commands answered with command_failed (0-1)")
+	flag.BoolVar(&opts.live, "live", false, "Start live")
+	scenarioName := flag.String("scenario", "stable", "Uplink scenario: "+scenarioNames())
+	battery := flag.Float64("battery", 100, "Initial battery level in percent")
+	drain := flag.Float64("drain", 0.5, "Battery drain in percent per minute while live (a quarter of it while idle)")
+	thermal := flag.String("thermal", "nominal", "Initial thermal state: "+strings.Join(thermalSteps, ", "))
+	heatStep := flag.Duration("heat", 10*time.Minute, "Live time per thermal step; the phone cools at the same rate when not live (0 keeps the state)")
+	flag.Parse()
+
+	sc, ok := scenarios[*scenarioName]
+	if !ok {
+		log.Fatalf("Unknown scenario %q", *scenarioName)
+	}
+	if !validThermal(*thermal) {
+		log.Fatalf("Unknown thermal state %q", *thermal)
+	}
+	d := newDevice(sc, *battery, *drain, *thermal, *heatStep)
+	if opts.live {
+		d.live = true
+	}
+
+	stop := make(chan os.Signal, 1)
+	signal.Notify(stop, syscall.SIGINT, syscall.SIGTERM)
+
+	// Reconnect like the app does, backing off up to 30s
+	backoff := time.Second
+	for {
+		started := time.Now()
+		err := run(opts, d, stop)
+		if err == nil {
+			return
+		}
+		if time.Since(started) > time.Minute {
+			backoff = time.Second
+		}
+		log.Printf("[SIM] %v, reconnecting in %s", err, backoff)
+		select {
+		case <-time.After(backoff):
+		case <-stop:
+			return
+		}
+		backoff = min(backoff*2, 30*time.Second)
+	}
+}
+
+// session is one connection to the relay
+type session struct {
+	opts    options
+	device  *device
+	conn    *websocket.Conn
+	writeMu sync.Mutex
+}
+
+// run connects, authenticates and simulates until the connection drops
+// (returning the error) or a signal arrives (returning nil)
+func run(opts options, d *device, stop <-chan os.Signal) error {
+	endpoint, err := url.Parse(strings.TrimSuffix(opts.url, "/") + "/ws")
+	if err != nil {
+		return err
+	}
+	endpoint.RawQuery = url.Values{"type": {"moblin"}, "device": {opts.device}}.Encode()
+	conn, _, err := websocket.DefaultDialer.Dial(endpoint.String(), nil)
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+
+	s := &session{opts: opts, device: d, conn: conn}
+	if opts.linkDelay > 0 {
+		conn.SetPingHandler(s.delayedPong)
+	}
+	if err := s.authenticate(); err != nil {
+		return err
+	}
+	log.Printf("[SIM] Connected to %s as %s: %s", opts.url, opts.device, d.summary())
+
+	errs := make(chan error, 1)
+	go func() { errs <- s.readLoop() }()
+
+	ticker := time.NewTicker(opts.interval)
+	defer ticker.Stop()
+	s.sendAll(d.tick())
+	for {
+		select {
+		case <-ticker.C:
+			if err := s.sendAll(d.tick()); err != nil {
+				return err
+			}
+		case err := <-errs:
+			return err
+		case <-stop:
+			s.writeMu.Lock()
+			conn.WriteMessage(websocket.CloseMessage, websocket.FormatCloseMessage(websocket.CloseNormalClosure, ""))
+			s.writeMu.Unlock()
+			return nil
+		}
+	}
+}
+
+// authenticate answers the hello challenge if the relay has a password
+func (s *session) authenticate() error {
+	var hello struct {
+		Type      string `json:"type"`
+		Challenge string `json:"challenge"`
+		Salt      string `json:"salt"`
+	}
+	s.conn.SetReadDeadline(time.Now().Add(10 * time.Second))
+	defer s.conn.SetReadDeadline(time.Time{})
+	if err := s.conn.ReadJSON(&hello); err != nil {
+		return err
+	}
+	if hello.Type != protocol.TypeHello {
+		return fmt.Errorf("expected hello, got %q", hello.Type)
+	}
+	if hello.Challenge == "" {
+		return nil
+	}
+	if s.opts.password == "" {
+		return errors.New("relay requires a password, set -password")
+	}
+	response := protocol.AuthResponse(s.opts.password, hello.Salt, hello.Challenge)
+	if err := s.send(event{"type": protocol.TypeAuth, "response": response}); err != nil {
+		return err
+	}
+	for {
+		var reply struct {
+			Type    string `json:"type"`
+			Message string `json:"message"`
+		}
+		if err := s.conn.ReadJSON(&reply); err != nil {
+			return err
+		}
+		switch reply.Type {
+		case "auth_success":
+			return nil
+		case "auth_failed":
+			return fmt.Errorf("authentication failed: %s", reply.Message)
+		}
+	}
+}
+
+// readLoop answers commands from the relay one at a time, in order
+func (s *session) readLoop() error {
+	commands := make(chan []byte, 64)
+	defer close(commands)
+	go func() {
+		for raw := range commands {
+			s.handleCommand(raw)
+		}
+	}()
+	for {
+		_, raw, err := s.conn.ReadMessage()
+		if err != nil {
+			return err
+		}
+		commands <- raw
+	}
+}
+
+// handleCommand applies a command after the simulated latency and replies
+func (s *session) handleCommand(raw []byte) {
+	env, err := protocol.ParseEnvelope(raw)
+	if err != nil || !protocol.IsCommand(env.Type) {
+		return
+	}
+	time.Sleep(s.opts.latency)
+	cmd, err := protocol.ParseCommand(raw)
+	if err != nil {
+		s.reply(env, err.Error())
+		return
+	}
+	if s.opts.failRate > 0 && rand.Float64() < s.opts.failRate {
+		log.Printf("[SIM] %s %s: failing on purpose", env.RequestID, env.Type)
+		s.reply(env, "Simulated failure")
+		return
+	}
+	events, failure := s.device.apply(cmd)
+	s.reply(env, failure)
+	if failure == "" {
+		s.sendAll(events)
+	}
+	log.Printf("[SIM] %s %s: %s", env.RequestID, env.Type, s.device.summary())
+}
+
+// reply acknowledges a command, or fails it if failure is set
+func (s *session) reply(env protocol.Envelope, failure string) {
+	if env.RequestID == "" {
+		return
+	}
+	if failure != "" {
+		s.send(event{"type": protocol.TypeCommandFailed, "request_id": env.RequestID, "message": failure})
+		return
+	}
+	s.send(event{"type": protocol.TypeCommandAck, "request_id": env.RequestID})
+}
+
+func (s *session) sendAll(events []event) error {
+	for _, ev := range events {
+		if err := s.send(ev); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (s *session) send(ev event) error {
+	data, err := json.Marshal(ev)
+	if err != nil {
+		return err
+	}
+	s.writeMu.Lock()
+	defer s.writeMu.Unlock()
+	return s.conn.WriteMessage(websocket.TextMessage, data)
+}
+
+// delayedPong answers a relay ping after the configured link delay
+func (s *session) delayedPong(appData string) error {
+	delay := s.opts.linkDelay + time.Duration(rand.Int63n(int64(s.opts.linkDelay)/2+1))
+	time.AfterFunc(delay, func() {
+		s.writeMu.Lock()
+		defer s.writeMu.Unlock()
+		s.conn.WriteControl(websocket.PongMessage, []byte(appData), time.Now().Add(time.Second))
+	})
+	return nil
+}
+
+func validThermal(state string) bool {
+	for _, step := range thermalSteps {
+		if step == state {
+			return true
+		}
+	}
+	return false
+}
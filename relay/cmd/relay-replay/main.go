@@ -0,0 +1,164 @@
+// Command relay-replay plays back a WebSocket capture recorded with the
+// relay's -capture flag.
+//
+// Against a relay (-target), every captured client is reconnected to the
+// same endpoint and sends its captured frames with the original timing.
+// Frames the relay sends back are compared with the capture by message
+// type, so a capture doubles as a regression fixture for message routing;
+// the exit status is 1 if any client received something different. Replay
+// against a relay without -password so browsers are authorized without a
+// login; captured auth responses and Moblin identify responses are redacted.
+//
+// With -serve, the tool is a WebSocket server instead and sends the frames
+// one captured client received to every browser that connects to /ws.
+//
+//	relay-replay -target ws://localhost:8080 capture.jsonl
+//	relay-replay -serve :9090 -client browser-1737291234 capture.jsonl
+package main
+
+import (
+	"flag"
+	"fmt"
+	"log"
+	"net/http"
+	"os"
+	"time"
+
+	"github.com/gorilla/websocket"
+	"github.com/volleybratans/moblin-relay/capture"
+)
+
+func main() {
+	target := flag.String("target", "", "Relay base URL to replay against, e.g. ws://localhost:8080")
+	serve := flag.String("serve", "", "Serve a captured client's frames to browsers on this address, e.g. :9090")
+	clientID := flag.String("client", "", "Captured client for -serve (default: the first browser)")
+	speed := flag.Float64("speed", 1, "Playback speed factor; 0 sends without delays and keeps connections open until -settle")
+	settle := flag.Duration("settle", 2*time.Second, "Time to wait for replies after the last frame")
+	overlayToken := flag.String("overlay-token", "", "Overlay token of the target relay")
+	outPath := flag.String("out", "", "Record the replayed traffic to this capture file")
+	flag.Usage = func() {
+		fmt.Fprintf(os.Stderr, "usage: relay-replay (-target URL | -serve ADDR) [flags] capture.jsonl\n")
+		flag.PrintDefaults()
+	}
+	flag.Parse()
+	if flag.NArg() != 1 || (*target == "") == (*serve == "") {
+		flag.Usage()
+		os.Exit(2)
+	}
+
+	frames, err := capture.ReadFile(flag.Arg(0))
+	if err != nil {
+		log.Fatal(err)
+	}
+	if len(frames) == 0 {
+		log.Fatal("capture is empty")
+	}
+
+	if *serve != "" {
+		serveBrowsers(*serve, *clientID, frames, *speed)
+		return
+	}
+
+	var recorder *capture.Recorder
+	if *outPath != "" {
+		if recorder, err = capture.NewRecorder(*outPath); err != nil {
+			log.Fatal(err)
+		}
+		defer recorder.Close()
+	}
+	r := &capture.Replay{
+		Target:       *target,
+		OverlayToken: *overlayToken,
+		Speed:        *speed,
+		Recorder:     recorder,
+	}
+	r.Run(frames)
+	time.Sleep(*settle)
+	if !report(r.Results()) {
+		os.Exit(1)
+	}
+}
+
+// report prints a comparison per client and returns whether all matched
+func report(results []capture.Result) bool {
+	ok := true
+	for _, res := range results {
+		label := fmt.Sprintf("%s (%s", res.Client, res.Kind)
+		if res.Device != "" {
+			label += " " + res.Device
+		}
+		label += ")"
+		if i, differs := res.FirstDifference(); differs {
+			ok = false
+			fmt.Printf("DIFF %s: %d captured, %d replayed, first difference at frame %d: captured %q, replayed %q\n",
+				label, len(res.Expected), len(res.Received), i+1, at(res.Expected, i), at(res.Received, i))
+		} else {
+			fmt.Printf("OK   %s: %d frames\n", label, len(res.Received))
+		}
+	}
+	return ok
+}
+
+// serveBrowsers sends one captured client's outbound frames to every
+// browser that connects to /ws
+func serveBrowsers(addr, clientID string, frames []capture.Frame, speed float64) {
+	if clientID == "" {
+		for _, f := range frames {
+			if f.Dir == capture.Open && f.Kind == "browser" {
+				clientID = f.Client
+				break
+			}
+		}
+	}
+	var outbound []capture.Frame
+	for _, f := range frames {
+		if f.Client == clientID && f.Dir == capture.Out {
+			outbound = append(outbound, f)
+		}
+	}
+	if len(outbound) == 0 {
+		log.Fatalf("no frames sent to client %q in capture", clientID)
+	}
+
+	upgrader := websocket.Upgrader{CheckOrigin: func(*http.Request) bool { return true }}
+	http.HandleFunc("/ws", func(w http.ResponseWriter, req *http.Request) {
+		conn, err := upgrader.Upgrade(w, req, nil)
+		if err != nil {
+			return
+		}
+		defer conn.Close()
+		// Discard whatever the browser sends
+		done := make(chan struct{})
+		go func() {
+			defer close(done)
+			for {
+				if _, _, err := conn.ReadMessage(); err != nil {
+					return
+				}
+			}
+		}()
+		log.Printf("[REPLAY] %s connected, sending %d frames of %s", req.RemoteAddr, len(outbound), clientID)
+		start := time.Now()
+		for _, f := range outbound {
+			if speed > 0 {
+				due := start.Add(time.Duration(float64(f.Time.Sub(outbound[0].Time)) / speed))
+				time.Sleep(time.Until(due))
+			}
+			if err := conn.WriteMessage(websocket.TextMessage, f.Raw()); err != nil {
+				return
+			}
+		}
+		// Keep the connection so the browser does not reconnect and start over
+		log.Printf("[REPLAY] %s: replay finished", req.RemoteAddr)
+		<-done
+	})
+	log.Printf("[REPLAY] Serving %s on %s/ws", clientID, addr)
+	log.Fatal(http.ListenAndServe(addr, nil))
+}
+
+func at(types []string, i int) string {
+	if i < len(types) {
+		return types[i]
+	}
+	return "<none>"
+}
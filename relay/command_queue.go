@@ -0,0 +1,269 @@
+/**
+ * Offline Command Queue - Holds Moblin commands while a device is disconnected
+ * Commands expire after a per-type TTL and are flushed on reconnect.
+ */
+
+package main
+
+import (
+	"log"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/volleybratans/moblin-relay/protocol"
+)
+
+// maxQueuedCommands bounds the queue of a single device
+const maxQueuedCommands = 50
+
+// queuePolicy controls how long a command stays queued and whether a newer
+// command of the same group replaces it
+type queuePolicy struct {
+	TTL           time.Duration
+	CoalesceGroup string
+}
+
+var defaultQueuePolicy = queuePolicy{TTL: 60 * time.Second}
+
+var queuePolicies = map[string]queuePolicy{
+	protocol.TypeSetScene:   {TTL: 60 * time.Second, CoalesceGroup: "scene"},
+	protocol.TypeSetBitrate: {TTL: 120 * time.Second, CoalesceGroup: "bitrate"},
+	protocol.TypeSetZoom:    {TTL: 60 * time.Second, CoalesceGroup: "zoom"},
+	protocol.TypeGoLive:     {TTL: 30 * time.Second, CoalesceGroup: "stream"},
+	protocol.TypeEnd:        {TTL: 30 * time.Second, CoalesceGroup: "stream"},
+	protocol.TypeSnapshot:   {TTL: 10 * time.Second},
+}
+
+func policyFor(command string) queuePolicy {
+	if p, ok := queuePolicies[command]; ok {
+		return p
+	}
+	return defaultQueuePolicy
+}
+
+// queuedCommand is a command waiting for its device to (re)connect
+type queuedCommand struct {
+	cmd       *pendingCommand
+	command   protocol.Command // re-encoded per device for commands queued for all
+	payload   []byte
+	group     string
+	ttl       time.Duration
+	seq       uint64    // enqueue order, set by Enqueue
+	expiresAt time.Time // set by Enqueue
+
+	// Commands queued for all devices stay queued until every known device
+	// has received them
+	done      map[string]bool // devices it was flushed to or superseded on
+	delivered bool            // flushed to at least one device
+}
+
+// CommandQueue keeps pending commands per device. Commands targeted at
+// "all" while no device is connected are queued under DeviceAll and
+// delivered to every known device that connects before their TTL passes.
+type CommandQueue struct {
+	queues map[string][]*queuedCommand
+	seq    uint64
+	now    func() time.Time // clock for TTLs, replaced in tests
+	mu     sync.Mutex
+}
+
+func NewCommandQueue() *CommandQueue {
+	return &CommandQueue{queues: make(map[string][]*queuedCommand), now: time.Now}
+}
+
+// Enqueue adds a command and returns the commands it displaced, either
+// through coalescing or because the queue was full. A command for all
+// devices supersedes older commands of its group on every device, a
+// device command supersedes an older command for all on that device only.
+// Commands for all that already reached a device are dropped silently.
+func (q *CommandQueue) Enqueue(device string, qc *queuedCommand) []*queuedCommand {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	q.seq++
+	qc.seq = q.seq
+	qc.expiresAt = q.now().Add(qc.ttl)
+	qc.done = make(map[string]bool)
+
+	var displaced []*queuedCommand
+	if qc.group != "" {
+		for key, queue := range q.queues {
+			if key != device && device != DeviceAll {
+				continue
+			}
+			kept := queue[:0]
+			for _, existing := range queue {
+				if existing.group != qc.group {
+					kept = append(kept, existing)
+				} else if !existing.delivered {
+					displaced = append(displaced, existing)
+				}
+			}
+			q.setQueue(key, kept)
+		}
+		if device != DeviceAll {
+			for _, existing := range q.queues[DeviceAll] {
+				if existing.group == qc.group {
+					existing.done[device] = true
+				}
+			}
+		}
+	}
+
+	queue := q.queues[device]
+	if len(queue) >= maxQueuedCommands {
+		if !queue[0].delivered {
+			displaced = append(displaced, queue[0])
+		}
+		queue = queue[1:]
+	}
+	q.queues[device] = append(queue, qc)
+	return displaced
+}
+
+// Flush returns the commands for a device, including those queued for all
+// devices, in the order they were queued, split into those still valid and
+// those whose TTL has passed. The device's own queue is removed; commands
+// for all are kept until every device in known has received them.
+func (q *CommandQueue) Flush(device string, known []string) (ready, expired []*queuedCommand) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	now := q.now()
+	for _, qc := range q.queues[device] {
+		if now.After(qc.expiresAt) {
+			expired = append(expired, qc)
+		} else {
+			ready = append(ready, qc)
+		}
+	}
+	delete(q.queues, device)
+
+	kept := q.queues[DeviceAll][:0]
+	for _, qc := range q.queues[DeviceAll] {
+		if now.After(qc.expiresAt) {
+			if !qc.delivered {
+				expired = append(expired, qc)
+			}
+			continue
+		}
+		if !qc.done[device] {
+			qc.done[device] = true
+			qc.delivered = true
+			ready = append(ready, qc)
+		}
+		if !receivedBy(qc, known) {
+			kept = append(kept, qc)
+		}
+	}
+	q.setQueue(DeviceAll, kept)
+
+	sort.Slice(ready, func(i, j int) bool { return ready[i].seq < ready[j].seq })
+	sort.Slice(expired, func(i, j int) bool { return expired[i].seq < expired[j].seq })
+	return ready, expired
+}
+
+// Expire removes and returns all commands whose TTL has passed and that
+// never reached a device
+func (q *CommandQueue) Expire() []*queuedCommand {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	now := q.now()
+	var expired []*queuedCommand
+	for device, queue := range q.queues {
+		kept := queue[:0]
+		for _, qc := range queue {
+			if !now.After(qc.expiresAt) {
+				kept = append(kept, qc)
+			} else if !qc.delivered {
+				expired = append(expired, qc)
+			}
+		}
+		q.setQueue(device, kept)
+	}
+	return expired
+}
+
+// setQueue stores a device's queue, dropping it when empty.
+// Caller must hold q.mu.
+func (q *CommandQueue) setQueue(device string, queue []*queuedCommand) {
+	if len(queue) == 0 {
+		delete(q.queues, device)
+	} else {
+		q.queues[device] = queue
+	}
+}
+
+// receivedBy reports whether every known device received or superseded qc
+func receivedBy(qc *queuedCommand, known []string) bool {
+	for _, device := range known {
+		if !qc.done[device] {
+			return false
+		}
+	}
+	return true
+}
+
+// queueCommand stores a command for a device that is not connected
+func (r *Relay) queueCommand(origin commandOrigin, device string, command protocol.Command) {
+	if device == "" {
+		device = DeviceAll
+	}
+	policy := policyFor(command.CommandType())
+	cmd := r.newPendingCommand(origin, command.CommandType(), device)
+	payload, err := protocol.EncodeCommand(command, cmd.RequestID)
+	if err != nil {
+		r.replyCommand(cmd, "command_failed", "Failed to encode command")
+		return
+	}
+	qc := &queuedCommand{
+		cmd:     cmd,
+		command: command,
+		payload: payload,
+		group:   policy.CoalesceGroup,
+		ttl:     policy.TTL,
+	}
+	for _, old := range r.queue.Enqueue(device, qc) {
+		r.replyCommand(old.cmd, "command_expired", "Superseded by newer command")
+	}
+	log.Printf("[RELAY] Command %s (%s) queued for %s (ttl %s)", cmd.RequestID, cmd.Command, device, policy.TTL)
+	r.replyCommand(cmd, "command_queued", "Moblin not connected, command queued")
+}
+
+// flushQueue delivers queued commands to a freshly connected device
+func (r *Relay) flushQueue(moblin *Client) {
+	ready, expired := r.queue.Flush(moblin.Device, r.streams.DeviceNames())
+	for _, qc := range expired {
+		r.replyCommand(qc.cmd, "command_expired", "Command expired before Moblin reconnected")
+	}
+	for _, qc := range ready {
+		cmd, payload := qc.cmd, qc.payload
+		if cmd.Device == DeviceAll {
+			// Every device gets a request ID of its own, as with live commands
+			cmd = r.newPendingCommand(commandOrigin{BrowserID: qc.cmd.BrowserID, Ref: qc.cmd.Ref, OnReply: qc.cmd.onReply}, qc.cmd.Command, moblin.Device)
+			var err error
+			if payload, err = protocol.EncodeCommand(qc.command, cmd.RequestID); err != nil {
+				r.replyCommand(cmd, "command_failed", "Failed to encode command")
+				continue
+			}
+		}
+		if moblin.enqueueControl(payload) {
+			r.replyCommand(cmd, "command_delivered", "")
+			r.commands.Track(cmd)
+		} else {
+			r.replyCommand(cmd, "command_failed", "Moblin control queue full")
+		}
+	}
+	if len(ready) > 0 {
+		log.Printf("[RELAY] Flushed %d queued commands to %s", len(ready), moblin.Device)
+	}
+}
+
+// expireLoop reports queued commands whose TTL ran out
+func (r *Relay) expireLoop() {
+	for range time.NewTicker(time.Second).C {
+		for _, qc := range r.queue.Expire() {
+			log.Printf("[RELAY] Queued command %s (%s) for %s expired", qc.cmd.RequestID, qc.cmd.Command, qc.cmd.Device)
+			r.replyCommand(qc.cmd, "command_expired", "Moblin did not reconnect in time")
+		}
+	}
+}
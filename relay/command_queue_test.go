@@ -0,0 +1,273 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/volleybratans/moblin-relay/protocol"
+)
+
+// testClock is a controllable clock for CommandQueue.now
+type testClock struct {
+	t time.Time
+}
+
+func (c *testClock) now() time.Time          { return c.t }
+func (c *testClock) advance(d time.Duration) { c.t = c.t.Add(d) }
+
+func newTestQueue() (*CommandQueue, *testClock) {
+	clock := &testClock{t: time.Date(2026, 10, 16, 18, 0, 0, 0, time.UTC)}
+	q := NewCommandQueue()
+	q.now = clock.now
+	return q, clock
+}
+
+// queued builds a queued command with the policy of its type; id tells
+// commands of the same type apart
+func queued(command, id string) *queuedCommand {
+	policy := policyFor(command)
+	return &queuedCommand{
+		cmd:   &pendingCommand{RequestID: id, Command: command},
+		group: policy.CoalesceGroup,
+		ttl:   policy.TTL,
+	}
+}
+
+func requestIDs(queue []*queuedCommand) []string {
+	ids := []string{}
+	for _, qc := range queue {
+		ids = append(ids, qc.cmd.RequestID)
+	}
+	return ids
+}
+
+func TestCommandQueueTTL(t *testing.T) {
+	tests := []struct {
+		command string
+		age     time.Duration
+		expired bool
+	}{
+		{protocol.TypeSnapshot, 10 * time.Second, false},
+		{protocol.TypeSnapshot, 11 * time.Second, true},
+		{protocol.TypeGoLive, 30 * time.Second, false},
+		{protocol.TypeGoLive, 31 * time.Second, true},
+		{protocol.TypeSetScene, 59 * time.Second, false},
+		{protocol.TypeSetScene, 61 * time.Second, true},
+		{protocol.TypeSetBitrate, 90 * time.Second, false},
+		{protocol.TypeSetBitrate, 121 * time.Second, true},
+		{protocol.TypeToggleMic, 60 * time.Second, false}, // default policy
+		{protocol.TypeToggleMic, 61 * time.Second, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.command+" after "+tt.age.String(), func(t *testing.T) {
+			// Flush on reconnect
+			q, clock := newTestQueue()
+			q.Enqueue("main", queued(tt.command, "req-1"))
+			clock.advance(tt.age)
+			ready, expired := q.Flush("main", []string{"main"})
+			if got := len(expired) == 1; got != tt.expired || len(ready)+len(expired) != 1 {
+				t.Errorf("flush: %d ready, %d expired, want expired %v", len(ready), len(expired), tt.expired)
+			}
+
+			// Expiry while the device stays away
+			q, clock = newTestQueue()
+			q.Enqueue("main", queued(tt.command, "req-1"))
+			clock.advance(tt.age)
+			if got := len(q.Expire()) == 1; got != tt.expired {
+				t.Errorf("expire: expired %v, want %v", got, tt.expired)
+			}
+			if ready, _ := q.Flush("main", []string{"main"}); (len(ready) == 1) == tt.expired {
+				t.Errorf("flush after expire: %d ready", len(ready))
+			}
+		})
+	}
+}
+
+func TestCommandQueueCoalesce(t *testing.T) {
+	tests := []struct {
+		name      string
+		commands  []string // command types, queued as req-1, req-2, ...
+		queued    []string
+		displaced []string
+	}{
+		{
+			name:      "latest bitrate wins",
+			commands:  []string{protocol.TypeSetBitrate, protocol.TypeSetScene, protocol.TypeSetBitrate},
+			queued:    []string{"req-2", "req-3"},
+			displaced: []string{"req-1"},
+		},
+		{
+			name:      "latest scene wins",
+			commands:  []string{protocol.TypeSetScene, protocol.TypeSetScene, protocol.TypeSetScene},
+			queued:    []string{"req-3"},
+			displaced: []string{"req-1", "req-2"},
+		},
+		{
+			name:      "end supersedes go live",
+			commands:  []string{protocol.TypeGoLive, protocol.TypeSetZoom, protocol.TypeEnd},
+			queued:    []string{"req-2", "req-3"},
+			displaced: []string{"req-1"},
+		},
+		{
+			name:      "snapshots are kept",
+			commands:  []string{protocol.TypeSnapshot, protocol.TypeSnapshot},
+			queued:    []string{"req-1", "req-2"},
+			displaced: []string{},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			q, _ := newTestQueue()
+			displaced := []string{}
+			for i, command := range tt.commands {
+				displaced = append(displaced, requestIDs(q.Enqueue("main", queued(command, fmt.Sprintf("req-%d", i+1))))...)
+			}
+			if !reflect.DeepEqual(displaced, tt.displaced) {
+				t.Errorf("displaced %v, want %v", displaced, tt.displaced)
+			}
+			ready, _ := q.Flush("main", []string{"main"})
+			if got := requestIDs(ready); !reflect.DeepEqual(got, tt.queued) {
+				t.Errorf("queued %v, want %v", got, tt.queued)
+			}
+		})
+	}
+}
+
+func TestCommandQueueAll(t *testing.T) {
+	q, clock := newTestQueue()
+	known := []string{"main", "other"}
+	enqueue := func(device, command, id string) []string {
+		return requestIDs(q.Enqueue(device, queued(command, id)))
+	}
+	flush := func(device string) []string {
+		ready, _ := q.Flush(device, known)
+		return requestIDs(ready)
+	}
+
+	enqueue("main", protocol.TypeSetScene, "req-1")
+	// A command for all supersedes older device commands of its group
+	if displaced := enqueue(DeviceAll, protocol.TypeSetScene, "req-2"); !reflect.DeepEqual(displaced, []string{"req-1"}) {
+		t.Errorf("displaced %v, want req-1", displaced)
+	}
+	enqueue(DeviceAll, protocol.TypeSetBitrate, "req-3")
+	// A device command supersedes an older command for all on that device only
+	if displaced := enqueue("other", protocol.TypeSetBitrate, "req-4"); len(displaced) != 0 {
+		t.Errorf("displaced %v, want none", displaced)
+	}
+
+	if got, want := flush("main"), []string{"req-2", "req-3"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("main received %v, want %v", got, want)
+	}
+	if got, want := flush("main"), []string{}; !reflect.DeepEqual(got, want) {
+		t.Errorf("main received %v again", got)
+	}
+	if got, want := flush("other"), []string{"req-2", "req-4"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("other received %v, want %v", got, want)
+	}
+	if len(q.queues) != 0 {
+		t.Errorf("commands left after every device received them: %v", q.queues)
+	}
+
+	// Once delivered to a device, commands for all expire silently
+	enqueue(DeviceAll, protocol.TypeGoLive, "req-5")
+	flush("main")
+	clock.advance(31 * time.Second)
+	if expired := q.Expire(); len(expired) != 0 {
+		t.Errorf("delivered command reported as expired: %v", requestIDs(expired))
+	}
+	if got := flush("other"); len(got) != 0 {
+		t.Errorf("other received %v after the TTL", got)
+	}
+}
+
+func TestCommandQueueFull(t *testing.T) {
+	q, _ := newTestQueue()
+	for i := 0; i < maxQueuedCommands; i++ {
+		if displaced := q.Enqueue("main", queued(protocol.TypeSnapshot, "old")); len(displaced) != 0 {
+			t.Fatalf("command %d displaced %v", i+1, requestIDs(displaced))
+		}
+	}
+	q.Enqueue("main", queued(protocol.TypeSnapshot, "first")) // displaces one "old"
+	displaced := q.Enqueue("main", queued(protocol.TypeSnapshot, "last"))
+	if got := requestIDs(displaced); !reflect.DeepEqual(got, []string{"old"}) {
+		t.Errorf("displaced %v, want the oldest command", got)
+	}
+	ready, _ := q.Flush("main", []string{"main"})
+	if len(ready) != maxQueuedCommands || ready[len(ready)-1].cmd.RequestID != "last" {
+		t.Errorf("%d commands queued, last %s", len(ready), ready[len(ready)-1].cmd.RequestID)
+	}
+}
+
+// TestFlushQueue queues commands through the relay while no device is
+// connected and checks what reconnecting devices receive
+func TestFlushQueue(t *testing.T) {
+	r := NewRelay(RelayConfig{})
+	clock := &testClock{t: time.Date(2026, 10, 16, 18, 0, 0, 0, time.UTC)}
+	r.queue.now = clock.now
+	r.streams.SetConnected("main", false)
+	r.streams.SetConnected("other", false)
+
+	replies := map[string][]string{}
+	send := func(device, ref string, command protocol.Command) {
+		r.queueCommand(commandOrigin{Ref: ref, OnReply: func(replyType, message string) {
+			replies[ref] = append(replies[ref], replyType)
+		}}, device, command)
+	}
+	send("main", "snapshot", protocol.Snapshot{})
+	send("main", "mic", protocol.ToggleMic{})
+	send("main", "scene-wide", protocol.SetScene{Name: "wide"})
+	send("main", "bitrate-3000", protocol.SetBitrate{Kbps: 3000})
+	send("", "scene", protocol.SetScene{Name: "court_overview"}) // any device, supersedes "wide"
+	send("", "live", protocol.GoLive{})
+	send("other", "zoom", protocol.SetZoom{Level: 2})
+	clock.advance(20 * time.Second) // the snapshot expires
+	send("main", "bitrate-6000", protocol.SetBitrate{Kbps: 6000})
+
+	flush := func(device string) []string {
+		moblin := &Client{Device: device, Relay: r, Control: make(chan []byte, controlQueueSize)}
+		r.flushQueue(moblin)
+		close(moblin.Control)
+		var delivered []string
+		for payload := range moblin.Control {
+			var fields map[string]any
+			json.Unmarshal(payload, &fields)
+			delivered = append(delivered, fields["type"].(string))
+			if fields["type"] == protocol.TypeSetBitrate && fields["kbps"] != 6000.0 {
+				t.Errorf("bitrate %v delivered, want the latest (6000)", fields["kbps"])
+			}
+			if fields["type"] == protocol.TypeSetScene && fields["name"] != "court_overview" {
+				t.Errorf("scene %v delivered to %s, want the latest (court_overview)", fields["name"], device)
+			}
+		}
+		return delivered
+	}
+
+	// Commands are delivered in the order they were queued, whether they
+	// were queued for the device or for all devices
+	want := []string{protocol.TypeToggleMic, protocol.TypeSetScene, protocol.TypeGoLive, protocol.TypeSetBitrate}
+	if delivered := flush("main"); !reflect.DeepEqual(delivered, want) {
+		t.Errorf("main received %v, want %v", delivered, want)
+	}
+	// Commands for all devices also reach the next device that connects
+	want = []string{protocol.TypeSetScene, protocol.TypeGoLive, protocol.TypeSetZoom}
+	if delivered := flush("other"); !reflect.DeepEqual(delivered, want) {
+		t.Errorf("other received %v, want %v", delivered, want)
+	}
+
+	wantReplies := map[string][]string{
+		"snapshot":     {"command_queued", "command_expired"},
+		"mic":          {"command_queued", "command_delivered"},
+		"scene-wide":   {"command_queued", "command_expired"},
+		"bitrate-3000": {"command_queued", "command_expired"},
+		"scene":        {"command_queued", "command_delivered", "command_delivered"},
+		"live":         {"command_queued", "command_delivered", "command_delivered"},
+		"zoom":         {"command_queued", "command_delivered"},
+		"bitrate-6000": {"command_queued", "command_delivered"},
+	}
+	if !reflect.DeepEqual(replies, wantReplies) {
+		t.Errorf("replies %v, want %v", replies, wantReplies)
+	}
+}
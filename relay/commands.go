@@ -0,0 +1,215 @@
+/**
+ * Command Tracking - Request/Response correlation for Moblin commands
+ * Every browser command gets a relay-assigned request ID and exactly one
+ * command_ack / command_failed / command_timeout reply.
+ */
+
+package main
+
+import (
+	"fmt"
+	"log"
+	"sync"
+	"time"
+
+	"github.com/volleybratans/moblin-relay/protocol"
+)
+
+// DefaultCommandTimeout is used when no timeout is configured
+const DefaultCommandTimeout = 10 * time.Second
+
+// pendingCommand is a command forwarded to Moblin awaiting its acknowledgement
+type pendingCommand struct {
+	RequestID string
+	Command   string
+	Device    string
+	BrowserID string
+	Ref       string
+	onReply   func(replyType, message string)
+	timer     *time.Timer
+}
+
+// commandOrigin is who receives the replies of a command: a browser, or a
+// relay-side sender such as automation rules via OnReply
+type commandOrigin struct {
+	BrowserID string
+	Ref       string
+	OnReply   func(replyType, message string)
+}
+
+func (r *Relay) newPendingCommand(origin commandOrigin, command, device string) *pendingCommand {
+	return &pendingCommand{
+		RequestID: r.commands.NextID(),
+		Command:   command,
+		Device:    device,
+		BrowserID: origin.BrowserID,
+		Ref:       origin.Ref,
+		onReply:   origin.OnReply,
+	}
+}
+
+// CommandTracker correlates Moblin acknowledgements with the originating browser
+type CommandTracker struct {
+	pending   map[string]*pendingCommand
+	timeout   time.Duration
+	seq       uint64
+	onTimeout func(*pendingCommand)
+	mu        sync.Mutex
+}
+
+// NewCommandTracker creates a tracker that calls onTimeout for unanswered commands
+func NewCommandTracker(timeout time.Duration, onTimeout func(*pendingCommand)) *CommandTracker {
+	if timeout <= 0 {
+		timeout = DefaultCommandTimeout
+	}
+	return &CommandTracker{
+		pending:   make(map[string]*pendingCommand),
+		timeout:   timeout,
+		onTimeout: onTimeout,
+	}
+}
+
+// NextID returns a new relay-assigned request ID
+func (t *CommandTracker) NextID() string {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.seq++
+	return fmt.Sprintf("req-%d", t.seq)
+}
+
+// Track starts the timeout for a command that was handed to Moblin
+func (t *CommandTracker) Track(cmd *pendingCommand) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.pending[cmd.RequestID] = cmd
+	cmd.timer = time.AfterFunc(t.timeout, func() {
+		if expired := t.Resolve(cmd.RequestID); expired != nil {
+			t.onTimeout(expired)
+		}
+	})
+}
+
+// Lookup returns a pending command without resolving it, or nil
+func (t *CommandTracker) Lookup(requestID string) *pendingCommand {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	return t.pending[requestID]
+}
+
+// Resolve removes and returns a pending command, or nil if it is unknown
+// or was already answered
+func (t *CommandTracker) Resolve(requestID string) *pendingCommand {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	cmd, ok := t.pending[requestID]
+	if !ok {
+		return nil
+	}
+	delete(t.pending, requestID)
+	cmd.timer.Stop()
+	return cmd
+}
+
+// ResolveDevice removes and returns all commands pending on a device
+func (t *CommandTracker) ResolveDevice(device string) []*pendingCommand {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	var resolved []*pendingCommand
+	for id, cmd := range t.pending {
+		if cmd.Device == device {
+			delete(t.pending, id)
+			cmd.timer.Stop()
+			resolved = append(resolved, cmd)
+		}
+	}
+	return resolved
+}
+
+// SendCommand sends a relay-originated command to a device ("" or "all"
+// for every device). onReply receives the same replies a browser would.
+func (r *Relay) SendCommand(device string, command protocol.Command, onReply func(replyType, message string)) {
+	r.dispatchCommand(commandOrigin{OnReply: onReply}, device, command)
+}
+
+// dispatchCommand forwards a validated command to the targeted Moblin
+// devices, assigning a request ID per device
+func (r *Relay) dispatchCommand(origin commandOrigin, device string, command protocol.Command) {
+	r.mu.RLock()
+	var targets []*Client
+	for name, moblin := range r.moblins {
+		if !moblin.isAuthorized() {
+			continue
+		}
+		if device == "" || device == DeviceAll || device == name {
+			targets = append(targets, moblin)
+		}
+	}
+	r.mu.RUnlock()
+
+	if len(targets) == 0 {
+		r.queueCommand(origin, device, command)
+		return
+	}
+
+	for _, moblin := range targets {
+		cmd := r.newPendingCommand(origin, command.CommandType(), moblin.Device)
+		payload, err := protocol.EncodeCommand(command, cmd.RequestID)
+		if err != nil {
+			r.replyCommand(cmd, "command_failed", "Failed to encode command")
+			continue
+		}
+		if moblin.enqueueControl(payload) {
+			r.commands.Track(cmd)
+		} else {
+			log.Printf("[RELAY] Command %s to %s dropped: control queue full", cmd.RequestID, moblin.Device)
+			r.replyCommand(cmd, "command_failed", "Moblin control queue full")
+		}
+	}
+}
+
+// handleCommandReply routes a Moblin acknowledgement to the originating browser only
+func (r *Relay) handleCommandReply(moblin *Client, reply *protocol.CommandReply) {
+	cmd := r.commands.Lookup(reply.RequestID)
+	if cmd == nil {
+		return
+	}
+	// A reply from another device must not resolve the command, so the
+	// right device can still answer it
+	if cmd.Device != moblin.Device {
+		log.Printf("[RELAY] Ignoring reply for %s from unexpected device %s", reply.RequestID, moblin.Device)
+		return
+	}
+	// Resolve fails if the command timed out in the meantime
+	if cmd = r.commands.Resolve(reply.RequestID); cmd == nil {
+		return
+	}
+	r.replyCommand(cmd, reply.EventType(), reply.Message)
+}
+
+// failDeviceCommands fails every command still waiting on a disconnected device
+func (r *Relay) failDeviceCommands(device string) {
+	for _, cmd := range r.commands.ResolveDevice(device) {
+		r.replyCommand(cmd, "command_failed", "Moblin disconnected")
+	}
+}
+
+func (r *Relay) replyCommand(cmd *pendingCommand, replyType, message string) {
+	if cmd.onReply != nil {
+		cmd.onReply(replyType, message)
+		return
+	}
+	status := "error"
+	switch replyType {
+	case "command_ack", "command_queued", "command_delivered":
+		status = "ok"
+	}
+	r.sendTo(cmd.BrowserID, Message{
+		Type:      replyType,
+		Status:    status,
+		RequestID: cmd.RequestID,
+		Command:   cmd.Command,
+		Device:    cmd.Device,
+		Ref:       cmd.Ref,
+		Message:   message,
+	})
+}
@@ -0,0 +1,116 @@
+package main
+
+import (
+	"fmt"
+	"reflect"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/volleybratans/moblin-relay/protocol"
+)
+
+// replyLog records the replies of commands by ref
+type replyLog struct {
+	replies map[string][]string
+	mu      sync.Mutex
+}
+
+func (l *replyLog) origin(ref string) commandOrigin {
+	return commandOrigin{Ref: ref, OnReply: func(replyType, message string) {
+		l.mu.Lock()
+		defer l.mu.Unlock()
+		l.replies[ref] = append(l.replies[ref], replyType)
+	}}
+}
+
+func (l *replyLog) get(ref string) []string {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	return l.replies[ref]
+}
+
+// trackCommand tracks a command as if it had been sent to device
+func trackCommand(r *Relay, replies *replyLog, ref, device string) *pendingCommand {
+	cmd := r.newPendingCommand(replies.origin(ref), protocol.TypeGoLive, device)
+	r.commands.Track(cmd)
+	return cmd
+}
+
+// commandReply parses a Moblin reply to requestID
+func commandReply(t *testing.T, replyType, requestID string) *protocol.CommandReply {
+	t.Helper()
+	ev, err := protocol.ParseEvent([]byte(fmt.Sprintf(`{"type":%q,"request_id":%q}`, replyType, requestID)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return ev.(*protocol.CommandReply)
+}
+
+func TestCommandReplyDevice(t *testing.T) {
+	r := NewRelay(RelayConfig{})
+	replies := &replyLog{replies: map[string][]string{}}
+	cmd := trackCommand(r, replies, "r1", "main")
+
+	// A reply from another device leaves the command pending
+	r.handleCommandReply(&Client{Device: "cam2"}, commandReply(t, protocol.TypeCommandAck, cmd.RequestID))
+	if got := replies.get("r1"); len(got) != 0 {
+		t.Errorf("reply from cam2 routed: %v", got)
+	}
+	if r.commands.Lookup(cmd.RequestID) == nil {
+		t.Fatal("command resolved by a reply from cam2")
+	}
+
+	r.handleCommandReply(&Client{Device: "main"}, commandReply(t, protocol.TypeCommandFailed, cmd.RequestID))
+	r.handleCommandReply(&Client{Device: "main"}, commandReply(t, protocol.TypeCommandAck, cmd.RequestID))
+	if got, want := replies.get("r1"), []string{protocol.TypeCommandFailed}; !reflect.DeepEqual(got, want) {
+		t.Errorf("replies %v, want %v", got, want)
+	}
+	if r.commands.Lookup(cmd.RequestID) != nil {
+		t.Error("command still pending after its reply")
+	}
+}
+
+func TestCommandTimeout(t *testing.T) {
+	r := NewRelay(RelayConfig{CommandTimeout: 20 * time.Millisecond})
+	replies := &replyLog{replies: map[string][]string{}}
+	cmd := trackCommand(r, replies, "r1", "main")
+
+	deadline := time.Now().Add(5 * time.Second)
+	for len(replies.get("r1")) == 0 && time.Now().Before(deadline) {
+		time.Sleep(5 * time.Millisecond)
+	}
+	if got, want := replies.get("r1"), []string{"command_timeout"}; !reflect.DeepEqual(got, want) {
+		t.Fatalf("replies %v, want %v", got, want)
+	}
+
+	// A reply after the timeout is not routed again
+	r.handleCommandReply(&Client{Device: "main"}, commandReply(t, protocol.TypeCommandAck, cmd.RequestID))
+	if got := replies.get("r1"); len(got) != 1 {
+		t.Errorf("late reply routed: %v", got)
+	}
+}
+
+func TestResolveDevice(t *testing.T) {
+	r := NewRelay(RelayConfig{})
+	replies := &replyLog{replies: map[string][]string{}}
+	trackCommand(r, replies, "main-1", "main")
+	trackCommand(r, replies, "main-2", "main")
+	other := trackCommand(r, replies, "cam2", "cam2")
+
+	r.failDeviceCommands("main")
+	for _, ref := range []string{"main-1", "main-2"} {
+		if got, want := replies.get(ref), []string{"command_failed"}; !reflect.DeepEqual(got, want) {
+			t.Errorf("%s: replies %v, want %v", ref, got, want)
+		}
+	}
+	if got := replies.get("cam2"); len(got) != 0 {
+		t.Errorf("cam2: replies %v after main disconnected", got)
+	}
+	if r.commands.Lookup(other.RequestID) == nil {
+		t.Error("command on cam2 resolved when main disconnected")
+	}
+	if resolved := r.commands.ResolveDevice("main"); len(resolved) != 0 {
+		t.Errorf("%d commands still pending on main", len(resolved))
+	}
+}
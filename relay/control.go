@@ -0,0 +1,318 @@
+/**
+ * Director Control - An opt-in lock on Moblin commands
+ * A browser can claim director control; while it holds the lock, other
+ * browsers are view-only for Moblin commands and macros. The lock is
+ * released explicitly, handed over, stolen with confirmation, or dropped
+ * when the director disconnects or stays idle. Automation is unaffected.
+ */
+
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/volleybratans/moblin-relay/models"
+	"github.com/volleybratans/moblin-relay/protocol"
+	"github.com/volleybratans/moblin-relay/services"
+)
+
+const (
+	// DefaultControlIdle releases the lock after this long without a message from the director
+	DefaultControlIdle = 15 * time.Minute
+
+	// controlOfferTimeout is how long a handover offer can be accepted
+	controlOfferTimeout = 30 * time.Second
+)
+
+// Control lock change reasons
+const (
+	controlClaimed      = "claimed"
+	controlReleased     = "released"
+	controlStolen       = "stolen"
+	controlHandover     = "handover"
+	controlDisconnected = "disconnected"
+	controlIdle         = "idle"
+)
+
+// controlLock is the director lock. Lock order is control.mu before r.mu.
+type controlLock struct {
+	holder *Client
+	name   string
+	since  time.Time
+	offer  *controlOffer
+	mu     sync.Mutex
+}
+
+// controlOffer is a pending handover from the director
+type controlOffer struct {
+	from    *Client
+	to      string // client ID, empty for any other browser
+	expires time.Time
+}
+
+// controlStatus describes the lock as seen by browser c. Caller must hold r.control.mu.
+func (r *Relay) controlStatus(c *Client, reason string) models.ControlStatus {
+	lock := &r.control
+	status := models.ControlStatus{Reason: reason}
+	if lock.holder != nil {
+		status.Locked = true
+		status.Holder = lock.holder.ID
+		status.Name = lock.name
+		status.Since = lock.since.UTC().Format(time.RFC3339)
+		status.Mine = lock.holder == c
+	}
+	return status
+}
+
+// notifyControl sends the lock status to all authorized browsers.
+// Caller must hold r.control.mu.
+func (r *Relay) notifyControl(reason string) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	for _, browser := range r.browsers {
+		if !browser.isAuthorized() {
+			continue
+		}
+		data, _ := json.Marshal(r.controlStatus(browser, reason))
+		msg, _ := json.Marshal(Message{Type: protocol.TypeControlStatus, Data: data})
+		browser.enqueue(msg)
+	}
+}
+
+// sendControl sends the lock status to a newly authorized browser
+func (c *Client) sendControl() {
+	c.Relay.control.mu.Lock()
+	data, _ := json.Marshal(c.Relay.controlStatus(c, ""))
+	c.Relay.control.mu.Unlock()
+	c.sendJSON(Message{Type: protocol.TypeControlStatus, Data: data})
+}
+
+// holderName names the director in error messages. Caller must hold r.control.mu.
+func (r *Relay) holderName() string {
+	if r.control.name != "" {
+		return r.control.name
+	}
+	return r.control.holder.ID
+}
+
+// checkControl reports whether browser c may send Moblin commands and
+// rejects the message if not
+func (c *Client) checkControl(env protocol.Envelope) bool {
+	lock := &c.Relay.control
+	lock.mu.Lock()
+	defer lock.mu.Unlock()
+	if lock.holder == nil || lock.holder == c {
+		return true
+	}
+	c.sendJSON(Message{Type: protocol.TypeError, Status: "error", Code: protocol.CodeControlLocked, Command: env.Type, Ref: env.Ref,
+		Message: fmt.Sprintf("Control is held by %s", c.Relay.holderName())})
+	return false
+}
+
+// CanControl reports whether a REST caller may send Moblin commands: while
+// the lock is held, only the director's socket (clientID) from its own login
+// session may. Another tab sharing the director's session cookie does not
+// hold the lock. Satisfies handlers.ControlLock for the REST API.
+func (r *Relay) CanControl(sessionID, clientID string) error {
+	r.control.mu.Lock()
+	defer r.control.mu.Unlock()
+	holder := r.control.holder
+	if holder == nil || (sessionID != "" && holder.SessionID == sessionID && holder.ID == clientID) {
+		return nil
+	}
+	return fmt.Errorf("Control is held by %s", r.holderName())
+}
+
+// handleControl handles director lock messages from a browser
+func (c *Client) handleControl(env protocol.Envelope, raw []byte) {
+	switch env.Type {
+	case protocol.TypeClaimControl:
+		req, err := protocol.ParseClaimControl(raw)
+		if err != nil {
+			c.sendError(err, env)
+			return
+		}
+		c.claimControl(env, req)
+	case protocol.TypeReleaseControl:
+		c.releaseControl(env)
+	case protocol.TypeHandoverControl:
+		req, err := protocol.ParseHandoverControl(raw)
+		if err != nil {
+			c.sendError(err, env)
+			return
+		}
+		c.handoverControl(env, req)
+	case protocol.TypeAcceptControl:
+		req, err := protocol.ParseClaimControl(raw)
+		if err != nil {
+			c.sendError(err, env)
+			return
+		}
+		c.acceptControl(env, req.Name)
+	case protocol.TypeDeclineControl:
+		c.declineControl(env)
+	}
+}
+
+func (c *Client) claimControl(env protocol.Envelope, req protocol.ClaimControl) {
+	lock := &c.Relay.control
+	lock.mu.Lock()
+	defer lock.mu.Unlock()
+
+	reason := controlClaimed
+	if lock.holder != nil && lock.holder != c {
+		if !req.Steal {
+			c.sendControlError(env, fmt.Sprintf("Control is held by %s; claim with steal to take over", c.Relay.holderName()))
+			return
+		}
+		reason = controlStolen
+		log.Printf("[RELAY] Director control taken from %s by %s", lock.holder.ID, c.ID)
+	} else {
+		log.Printf("[RELAY] Director control claimed by %s", c.ID)
+	}
+	if lock.holder != c {
+		lock.since = time.Now()
+	}
+	lock.holder = c
+	lock.name = req.Name
+	lock.offer = nil
+	c.Relay.notifyControl(reason)
+	c.Relay.audit.Record(c.SessionID, c.RemoteIP, c.UserAgent, services.AuditControl, "", map[string]string{"action": reason, "name": req.Name})
+}
+
+func (c *Client) releaseControl(env protocol.Envelope) {
+	lock := &c.Relay.control
+	lock.mu.Lock()
+	defer lock.mu.Unlock()
+	if lock.holder != c {
+		c.sendControlError(env, "Not the director")
+		return
+	}
+	log.Printf("[RELAY] Director control released by %s", c.ID)
+	c.Relay.clearControl(controlReleased)
+	c.Relay.audit.Record(c.SessionID, c.RemoteIP, c.UserAgent, services.AuditControl, "", map[string]string{"action": controlReleased})
+}
+
+// clearControl drops the lock and pending offer. Caller must hold r.control.mu.
+func (r *Relay) clearControl(reason string) {
+	r.control.holder = nil
+	r.control.name = ""
+	r.control.offer = nil
+	r.notifyControl(reason)
+}
+
+func (c *Client) handoverControl(env protocol.Envelope, req protocol.HandoverControl) {
+	lock := &c.Relay.control
+	lock.mu.Lock()
+	defer lock.mu.Unlock()
+	if lock.holder != c {
+		c.sendControlError(env, "Not the director")
+		return
+	}
+
+	c.Relay.mu.RLock()
+	var targets []*Client
+	for _, browser := range c.Relay.browsers {
+		if browser != c && browser.isAuthorized() && (req.To == "" || browser.ID == req.To) {
+			targets = append(targets, browser)
+		}
+	}
+	c.Relay.mu.RUnlock()
+	if len(targets) == 0 {
+		c.sendControlError(env, "No browser to hand control to")
+		return
+	}
+
+	lock.offer = &controlOffer{from: c, to: req.To, expires: time.Now().Add(controlOfferTimeout)}
+	data, _ := json.Marshal(map[string]interface{}{
+		"from":       c.ID,
+		"name":       lock.name,
+		"expires_in": int(controlOfferTimeout / time.Second),
+	})
+	for _, browser := range targets {
+		browser.sendJSON(Message{Type: protocol.TypeControlOffer, Data: data})
+	}
+	log.Printf("[RELAY] Director control offered by %s to %d browser(s)", c.ID, len(targets))
+}
+
+// offeredTo reports whether the pending offer can be answered by c. Caller must hold r.control.mu.
+func (r *Relay) offeredTo(c *Client) bool {
+	offer := r.control.offer
+	return offer != nil && offer.from == r.control.holder && offer.from != c &&
+		(offer.to == "" || offer.to == c.ID) && time.Now().Before(offer.expires)
+}
+
+func (c *Client) acceptControl(env protocol.Envelope, name string) {
+	lock := &c.Relay.control
+	lock.mu.Lock()
+	defer lock.mu.Unlock()
+	if !c.Relay.offeredTo(c) {
+		c.sendControlError(env, "No control offer to accept")
+		return
+	}
+	log.Printf("[RELAY] Director control handed from %s to %s", lock.holder.ID, c.ID)
+	lock.holder = c
+	lock.name = name
+	lock.since = time.Now()
+	lock.offer = nil
+	c.Relay.notifyControl(controlHandover)
+	c.Relay.audit.Record(c.SessionID, c.RemoteIP, c.UserAgent, services.AuditControl, "", map[string]string{"action": controlHandover, "name": name})
+}
+
+func (c *Client) declineControl(env protocol.Envelope) {
+	lock := &c.Relay.control
+	lock.mu.Lock()
+	defer lock.mu.Unlock()
+	if !c.Relay.offeredTo(c) {
+		c.sendControlError(env, "No control offer to decline")
+		return
+	}
+	// An offer to everyone stays open for the other browsers
+	if lock.offer.to == "" {
+		c.sendJSON(Message{Type: protocol.TypeControlOffer, Status: "declined", Ref: env.Ref, Message: "Offer declined, it stays open for the other browsers"})
+		return
+	}
+	from := lock.offer.from
+	lock.offer = nil
+	from.sendJSON(Message{Type: protocol.TypeControlOffer, Status: "declined", Message: fmt.Sprintf("%s declined control", c.ID)})
+	c.sendJSON(Message{Type: protocol.TypeControlOffer, Status: "declined", Ref: env.Ref, Message: "Offer declined"})
+}
+
+func (c *Client) sendControlError(env protocol.Envelope, message string) {
+	c.sendJSON(Message{Type: protocol.TypeError, Status: "error", Code: protocol.CodeControlLocked, Command: env.Type, Ref: env.Ref, Message: message})
+}
+
+// dropControl releases the lock when the director disconnects
+func (r *Relay) dropControl(c *Client) {
+	r.control.mu.Lock()
+	defer r.control.mu.Unlock()
+	if r.control.holder == c {
+		log.Printf("[RELAY] Director %s disconnected, control released", c.ID)
+		r.clearControl(controlDisconnected)
+	} else if r.control.offer != nil && r.control.offer.to == c.ID {
+		r.control.offer = nil
+	}
+}
+
+// controlLoop releases the lock of idle directors and expires offers
+func (r *Relay) controlLoop() {
+	for range time.NewTicker(5 * time.Second).C {
+		r.control.mu.Lock()
+		if offer := r.control.offer; offer != nil && time.Now().After(offer.expires) {
+			r.control.offer = nil
+		}
+		if holder := r.control.holder; holder != nil {
+			last := time.Unix(0, atomic.LoadInt64(&holder.lastMessage))
+			if time.Since(last) > r.controlIdle {
+				log.Printf("[RELAY] Director %s idle for %s, control released", holder.ID, r.controlIdle)
+				r.clearControl(controlIdle)
+			}
+		}
+		r.control.mu.Unlock()
+	}
+}
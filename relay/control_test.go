@@ -0,0 +1,73 @@
+package main
+
+import (
+	"encoding/json"
+	"testing"
+
+	"github.com/volleybratans/moblin-relay/protocol"
+)
+
+func TestDeclineControl(t *testing.T) {
+	base := startTestRelay(t)
+	director := connectBrowser(t, base)
+	operator := connectBrowser(t, base)
+
+	// Declining without an offer is an error
+	operator.WriteJSON(map[string]string{"type": protocol.TypeDeclineControl, "ref": "d1"})
+	if reply, _ := readUntil(t, operator, protocol.TypeError); reply.Code != protocol.CodeControlLocked || reply.Ref != "d1" {
+		t.Errorf("decline without offer: %+v", reply)
+	}
+
+	director.WriteJSON(map[string]string{"type": protocol.TypeClaimControl, "name": "Regie"})
+	readUntil(t, director, protocol.TypeControlStatus)
+	director.WriteJSON(map[string]string{"type": protocol.TypeHandoverControl})
+	readUntil(t, operator, protocol.TypeControlOffer)
+
+	// Declining an offer to everyone is confirmed and leaves it open
+	operator.WriteJSON(map[string]string{"type": protocol.TypeDeclineControl, "ref": "d2"})
+	if reply, _ := readUntil(t, operator, protocol.TypeControlOffer); reply.Status != "declined" || reply.Ref != "d2" {
+		t.Errorf("decline of an open offer: %+v", reply)
+	}
+	operator.WriteJSON(map[string]string{"type": protocol.TypeAcceptControl, "name": "Kamera"})
+	// The status before the handover arrives first; wait until it is ours
+	for {
+		msg, _ := readUntil(t, operator, protocol.TypeControlStatus)
+		var status struct {
+			Mine bool `json:"mine"`
+		}
+		json.Unmarshal(msg.Data, &status)
+		if status.Mine {
+			break
+		}
+	}
+}
+
+func TestCanControl(t *testing.T) {
+	r := NewRelay(RelayConfig{})
+	if err := r.CanControl("", ""); err != nil {
+		t.Errorf("unlocked: %v", err)
+	}
+
+	r.control.holder = &Client{ID: "browser-1", SessionID: "session-a"}
+	r.control.name = "Regie"
+	tests := []struct {
+		name      string
+		sessionID string
+		clientID  string
+		allowed   bool
+	}{
+		{"director", "session-a", "browser-1", true},
+		{"second tab of the director's session", "session-a", "browser-2", false},
+		{"director's session without a socket", "session-a", "", false},
+		{"director's socket from another session", "session-b", "browser-1", false},
+		{"no session", "", "browser-1", false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := r.CanControl(tt.sessionID, tt.clientID)
+			if (err == nil) != tt.allowed {
+				t.Errorf("CanControl(%q, %q) = %v, want allowed %v", tt.sessionID, tt.clientID, err, tt.allowed)
+			}
+		})
+	}
+}
@@ -0,0 +1,121 @@
+/**
+ * Delivery - Per-client send queues, metrics and slow-consumer policy
+ * Bulk updates go through Client.Send; commands to Moblin use the Control
+ * lane, which writePump always drains first so they are never stuck
+ * behind scout/matchday updates.
+ */
+
+package main
+
+import (
+	"log"
+	"sync/atomic"
+	"time"
+
+	"github.com/gorilla/websocket"
+	"github.com/volleybratans/moblin-relay/models"
+)
+
+const (
+	sendQueueSize    = 256
+	controlQueueSize = 64
+
+	// DefaultSlowClientTimeout is how long a client may stay saturated
+	DefaultSlowClientTimeout = 15 * time.Second
+)
+
+// deliveryStats are updated lock-free from the send and write paths
+type deliveryStats struct {
+	delivered      uint64
+	dropped        uint64
+	saturatedSince int64 // unix nanos, 0 while the client keeps up
+}
+
+// enqueue queues a bulk message, dropping it if the client is saturated
+func (c *Client) enqueue(data []byte) bool {
+	return c.push(c.Send, data)
+}
+
+// enqueueControl queues a command on the priority lane
+func (c *Client) enqueueControl(data []byte) bool {
+	return c.push(c.Control, data)
+}
+
+func (c *Client) push(queue chan []byte, data []byte) bool {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.closed {
+		return false
+	}
+	select {
+	case queue <- data:
+		return true
+	default:
+		atomic.AddUint64(&c.stats.dropped, 1)
+		atomic.CompareAndSwapInt64(&c.stats.saturatedSince, 0, time.Now().UnixNano())
+		return false
+	}
+}
+
+// closeQueues stops all further sends and lets writePump finish
+func (c *Client) closeQueues() {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.closed {
+		return
+	}
+	c.closed = true
+	close(c.Send)
+	close(c.Control)
+}
+
+// Metrics returns the delivery counters of the client
+func (c *Client) Metrics() models.ClientMetrics {
+	metrics := models.ClientMetrics{
+		ID:              c.ID,
+		Type:            string(c.Type),
+		Device:          c.Device,
+		Delivered:       atomic.LoadUint64(&c.stats.delivered),
+		Dropped:         atomic.LoadUint64(&c.stats.dropped),
+		QueueDepth:      len(c.Send),
+		QueueCapacity:   cap(c.Send),
+		ControlDepth:    len(c.Control),
+		ControlCapacity: cap(c.Control),
+	}
+	if since := atomic.LoadInt64(&c.stats.saturatedSince); since != 0 {
+		metrics.SaturatedSince = time.Unix(0, since).UTC().Format(time.RFC3339)
+	}
+	return metrics
+}
+
+// ClientMetrics returns delivery metrics for all connected clients
+func (r *Relay) ClientMetrics() []models.ClientMetrics {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	metrics := make([]models.ClientMetrics, 0, len(r.clients))
+	for _, client := range r.clients {
+		metrics = append(metrics, client.Metrics())
+	}
+	return metrics
+}
+
+// slowClientLoop disconnects clients that stay saturated for too long
+func (r *Relay) slowClientLoop() {
+	for range time.NewTicker(time.Second).C {
+		now := time.Now()
+		r.mu.RLock()
+		var slow []*Client
+		for _, client := range r.clients {
+			since := atomic.LoadInt64(&client.stats.saturatedSince)
+			if since != 0 && now.Sub(time.Unix(0, since)) > r.slowTimeout {
+				slow = append(slow, client)
+			}
+		}
+		r.mu.RUnlock()
+		for _, client := range slow {
+			m := client.Metrics()
+			log.Printf("[RELAY] Disconnecting slow client %s (queue %d/%d, dropped %d)", client.ID, m.QueueDepth, m.QueueCapacity, m.Dropped)
+			client.closeWith(websocket.CloseTryAgainLater, "slow consumer")
+		}
+	}
+}
@@ -0,0 +1,106 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync/atomic"
+	"testing"
+
+	"github.com/gorilla/websocket"
+)
+
+// newQueueClient returns a client with small send queues and a WebSocket
+// to a server that discards everything
+func newQueueClient(t *testing.T, sendSize, controlSize int) *Client {
+	t.Helper()
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		conn, err := upgrader.Upgrade(w, req, nil)
+		if err != nil {
+			return
+		}
+		defer conn.Close()
+		for {
+			if _, _, err := conn.ReadMessage(); err != nil {
+				return
+			}
+		}
+	}))
+	t.Cleanup(server.Close)
+	conn, _, err := websocket.DefaultDialer.Dial("ws"+strings.TrimPrefix(server.URL, "http"), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { conn.Close() })
+	return &Client{
+		ID:      "browser-1",
+		Type:    ClientTypeBrowser,
+		Conn:    conn,
+		Send:    make(chan []byte, sendSize),
+		Control: make(chan []byte, controlSize),
+		Relay:   NewRelay(RelayConfig{}),
+	}
+}
+
+func TestQueueFull(t *testing.T) {
+	c := newQueueClient(t, 2, 1)
+	for i := 0; i < 2; i++ {
+		if !c.enqueue([]byte("update")) {
+			t.Fatalf("update %d dropped", i+1)
+		}
+	}
+	if m := c.Metrics(); m.Dropped != 0 || m.SaturatedSince != "" {
+		t.Errorf("metrics before overflow %+v", m)
+	}
+
+	if c.enqueue([]byte("update")) {
+		t.Error("update queued beyond capacity")
+	}
+	since := atomic.LoadInt64(&c.stats.saturatedSince)
+	if since == 0 {
+		t.Fatal("not saturated after a drop")
+	}
+	c.enqueue([]byte("update"))
+	if m := c.Metrics(); m.Dropped != 2 || m.QueueDepth != 2 || m.SaturatedSince == "" {
+		t.Errorf("metrics after overflow %+v", m)
+	}
+	if atomic.LoadInt64(&c.stats.saturatedSince) != since {
+		t.Error("saturation start moved by a later drop")
+	}
+
+	// Commands have their own lane
+	if !c.enqueueControl([]byte("command")) {
+		t.Error("command dropped while only the bulk queue is full")
+	}
+
+	c.closeQueues()
+	if c.enqueueControl([]byte("command")) {
+		t.Error("command queued after close")
+	}
+	if m := c.Metrics(); m.Dropped != 2 {
+		t.Errorf("%d dropped, sends after close must not count", m.Dropped)
+	}
+}
+
+func TestWriteResetsSaturation(t *testing.T) {
+	c := newQueueClient(t, 4, 1)
+	for i := 0; i < 5; i++ {
+		c.enqueue([]byte("update"))
+	}
+
+	// Still saturated while the queue stays at least half full
+	if !c.write(<-c.Send) {
+		t.Fatal("write failed")
+	}
+	if atomic.LoadInt64(&c.stats.saturatedSince) == 0 {
+		t.Error("saturation reset with 3 of 4 slots in use")
+	}
+
+	<-c.Send
+	if !c.write(<-c.Send) {
+		t.Fatal("write failed")
+	}
+	if m := c.Metrics(); m.SaturatedSince != "" || m.Delivered != 2 || m.QueueDepth != 1 {
+		t.Errorf("metrics after draining %+v", m)
+	}
+}
@@ -0,0 +1,51 @@
+// Package events is an in-process bus for relay-side events such as
+// Moblin stream events, matchday updates and logged match events.
+// Subscribers run synchronously on the publisher's goroutine and must not block.
+package events
+
+import (
+	"encoding/json"
+	"sync"
+	"time"
+)
+
+// Event is something that happened on the relay. Type is the wire type
+// of the message that caused it (stream_started, matchday_update, ...)
+// or a match event (protocol.MatchEvents).
+type Event struct {
+	Type   string          `json:"type"`
+	Device string          `json:"device,omitempty"`
+	Data   json.RawMessage `json:"data,omitempty"`
+	Time   time.Time       `json:"time"`
+}
+
+// Bus delivers events to all subscribers
+type Bus struct {
+	subscribers []func(Event)
+	mu          sync.RWMutex
+}
+
+// NewBus creates an event bus without subscribers
+func NewBus() *Bus {
+	return &Bus{}
+}
+
+// Subscribe registers fn for all future events
+func (b *Bus) Subscribe(fn func(Event)) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.subscribers = append(b.subscribers, fn)
+}
+
+// Publish delivers an event to every subscriber
+func (b *Bus) Publish(ev Event) {
+	if ev.Time.IsZero() {
+		ev.Time = time.Now()
+	}
+	b.mu.RLock()
+	subscribers := b.subscribers
+	b.mu.RUnlock()
+	for _, fn := range subscribers {
+		fn(ev)
+	}
+}
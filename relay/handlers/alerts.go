@@ -0,0 +1,94 @@
+package handlers
+
+import (
+	"encoding/json"
+	"log"
+	"net/http"
+
+	"github.com/volleybratans/moblin-relay/models"
+)
+
+// AlertService interface for dependency injection
+type AlertService interface {
+	Alerts() []models.Alert
+	Acknowledge(id string) (models.Alert, error)
+	Rules() []models.AlertRule
+	SetRules(rules []models.AlertRule) error
+}
+
+// AlertHandler handles alert-related HTTP endpoints
+type AlertHandler struct {
+	service AlertService
+}
+
+// NewAlertHandler creates a new alert handler
+func NewAlertHandler(service AlertService) *AlertHandler {
+	return &AlertHandler{service: service}
+}
+
+// HandleAlerts returns firing and recently resolved alerts
+func (h *AlertHandler) HandleAlerts(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+
+	if r.Method != "GET" {
+		http.Error(w, `{"error": "Method not allowed"}`, http.StatusMethodNotAllowed)
+		return
+	}
+
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"alerts": h.service.Alerts(),
+	})
+}
+
+// HandleAck acknowledges an alert
+func (h *AlertHandler) HandleAck(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+
+	if r.Method != "POST" {
+		http.Error(w, `{"error": "Method not allowed"}`, http.StatusMethodNotAllowed)
+		return
+	}
+
+	var req struct {
+		ID string `json:"id"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil || req.ID == "" {
+		http.Error(w, `{"error": "Invalid JSON"}`, http.StatusBadRequest)
+		return
+	}
+
+	alert, err := h.service.Acknowledge(req.ID)
+	if err != nil {
+		http.Error(w, `{"error": "Unknown alert"}`, http.StatusNotFound)
+		return
+	}
+	log.Printf("[ALERTS] Alert %s acknowledged", alert.ID)
+	json.NewEncoder(w).Encode(alert)
+}
+
+// HandleRules handles GET/PUT for alert rules
+func (h *AlertHandler) HandleRules(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+
+	switch r.Method {
+	case "GET":
+		json.NewEncoder(w).Encode(h.service.Rules())
+
+	case "PUT":
+		var rules []models.AlertRule
+		if err := json.NewDecoder(r.Body).Decode(&rules); err != nil {
+			http.Error(w, `{"error": "Invalid JSON"}`, http.StatusBadRequest)
+			return
+		}
+		if err := h.service.SetRules(rules); err != nil {
+			w.WriteHeader(http.StatusBadRequest)
+			json.NewEncoder(w).Encode(map[string]string{"error": err.Error()})
+			return
+		}
+		log.Printf("[ALERTS] Rules updated (%d rules)", len(rules))
+		json.NewEncoder(w).Encode(h.service.Rules())
+
+	default:
+		http.Error(w, `{"error": "Method not allowed"}`, http.StatusMethodNotAllowed)
+	}
+}
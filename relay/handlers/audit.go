@@ -0,0 +1,95 @@
+package handlers
+
+import (
+	"encoding/json"
+	"net/http"
+	"strconv"
+
+	"github.com/volleybratans/moblin-relay/models"
+)
+
+// Auditor records control actions, see services.Auditor
+type Auditor interface {
+	Record(sessionID, ip, userAgent, action, device string, data interface{})
+	RecordRequest(r *http.Request, action, device string, data interface{})
+}
+
+// nopAuditor discards records, for handlers created without an Auditor
+type nopAuditor struct{}
+
+func (nopAuditor) Record(sessionID, ip, userAgent, action, device string, data interface{}) {}
+func (nopAuditor) RecordRequest(r *http.Request, action, device string, data interface{})   {}
+
+// orNopAuditor lets handlers call audit unconditionally when it is nil
+func orNopAuditor(audit Auditor) Auditor {
+	if audit == nil {
+		return nopAuditor{}
+	}
+	return audit
+}
+
+// AuditStore interface for dependency injection
+type AuditStore interface {
+	Query(q models.AuditQuery) ([]models.AuditEntry, error)
+}
+
+// AuditHandler serves the audit log
+type AuditHandler struct {
+	store AuditStore
+}
+
+// NewAuditHandler creates a new audit handler
+func NewAuditHandler(store AuditStore) *AuditHandler {
+	return &AuditHandler{store: store}
+}
+
+// HandleAudit returns audit entries, newest first, filtered by action,
+// session, device and time range (RFC 3339 or unix seconds)
+func (h *AuditHandler) HandleAudit(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+
+	if r.Method != "GET" {
+		http.Error(w, `{"error": "Method not allowed"}`, http.StatusMethodNotAllowed)
+		return
+	}
+
+	query := r.URL.Query()
+	q := models.AuditQuery{
+		Action:    query.Get("action"),
+		SessionID: query.Get("session"),
+		Device:    query.Get("device"),
+	}
+	if v := query.Get("from"); v != "" {
+		t, ok := parseTime(v)
+		if !ok {
+			http.Error(w, `{"error": "Invalid from"}`, http.StatusBadRequest)
+			return
+		}
+		q.From = t
+	}
+	if v := query.Get("to"); v != "" {
+		t, ok := parseTime(v)
+		if !ok {
+			http.Error(w, `{"error": "Invalid to"}`, http.StatusBadRequest)
+			return
+		}
+		q.To = t
+	}
+	if v := query.Get("limit"); v != "" {
+		limit, err := strconv.Atoi(v)
+		if err != nil || limit <= 0 {
+			http.Error(w, `{"error": "Invalid limit"}`, http.StatusBadRequest)
+			return
+		}
+		q.Limit = limit
+	}
+
+	entries, err := h.store.Query(q)
+	if err != nil {
+		http.Error(w, `{"error": "Failed to read audit log"}`, http.StatusInternalServerError)
+		return
+	}
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"entries": entries,
+	})
+}
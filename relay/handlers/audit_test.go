@@ -0,0 +1,98 @@
+package handlers
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/volleybratans/moblin-relay/models"
+)
+
+// fakeAuditStore records the last query and answers with entries or err
+type fakeAuditStore struct {
+	query   models.AuditQuery
+	entries []models.AuditEntry
+	err     error
+}
+
+func (f *fakeAuditStore) Query(q models.AuditQuery) ([]models.AuditEntry, error) {
+	f.query = q
+	return f.entries, f.err
+}
+
+func TestHandleAudit(t *testing.T) {
+	from := time.Date(2026, 10, 16, 18, 0, 0, 0, time.UTC)
+	tests := []struct {
+		name   string
+		method string
+		query  string
+		status int
+		want   models.AuditQuery
+	}{
+		{"no filter", "GET", "", http.StatusOK, models.AuditQuery{}},
+		{"filters", "GET", "?action=macro_run&session=ab12cd34&device=cam2&limit=20", http.StatusOK,
+			models.AuditQuery{Action: "macro_run", SessionID: "ab12cd34", Device: "cam2", Limit: 20}},
+		{"RFC 3339 range", "GET", "?from=2026-10-16T18:00:00Z&to=2026-10-16T19:00:00Z", http.StatusOK,
+			models.AuditQuery{From: from, To: from.Add(time.Hour)}},
+		{"unix range", "GET", "?from=1792173600", http.StatusOK, models.AuditQuery{From: from}},
+		{"invalid from", "GET", "?from=yesterday", http.StatusBadRequest, models.AuditQuery{}},
+		{"invalid to", "GET", "?to=later", http.StatusBadRequest, models.AuditQuery{}},
+		{"invalid limit", "GET", "?limit=ten", http.StatusBadRequest, models.AuditQuery{}},
+		{"zero limit", "GET", "?limit=0", http.StatusBadRequest, models.AuditQuery{}},
+		{"wrong method", "POST", "", http.StatusMethodNotAllowed, models.AuditQuery{}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			store := &fakeAuditStore{entries: []models.AuditEntry{{Time: "2026-10-16T18:00:00Z", Action: "macro_run"}}}
+			w := httptest.NewRecorder()
+			NewAuditHandler(store).HandleAudit(w, httptest.NewRequest(tt.method, "/api/audit"+tt.query, nil))
+			if w.Code != tt.status {
+				t.Fatalf("status %d, want %d: %s", w.Code, tt.status, w.Body)
+			}
+			if tt.status != http.StatusOK {
+				return
+			}
+			if !store.query.From.Equal(tt.want.From) || !store.query.To.Equal(tt.want.To) {
+				t.Errorf("range %s - %s, want %s - %s", store.query.From, store.query.To, tt.want.From, tt.want.To)
+			}
+			store.query.From, store.query.To, tt.want.From, tt.want.To = time.Time{}, time.Time{}, time.Time{}, time.Time{}
+			if store.query != tt.want {
+				t.Errorf("query %+v, want %+v", store.query, tt.want)
+			}
+			var body struct {
+				Entries []models.AuditEntry `json:"entries"`
+			}
+			json.NewDecoder(w.Body).Decode(&body)
+			if !reflect.DeepEqual(body.Entries, store.entries) {
+				t.Errorf("entries %+v, want %+v", body.Entries, store.entries)
+			}
+		})
+	}
+}
+
+func TestHandleAuditStoreError(t *testing.T) {
+	w := httptest.NewRecorder()
+	NewAuditHandler(&fakeAuditStore{err: errors.New("disk full")}).HandleAudit(w, httptest.NewRequest("GET", "/api/audit", nil))
+	if w.Code != http.StatusInternalServerError {
+		t.Errorf("status %d, want 500", w.Code)
+	}
+}
+
+// fakeRegistry knows a single client
+type fakeRegistry struct{}
+
+func (fakeRegistry) Clients() []models.ClientInfo { return nil }
+func (fakeRegistry) Kick(id string) bool          { return id == "browser-1" }
+
+func TestNilAuditor(t *testing.T) {
+	// Handlers created without an Auditor still handle audited actions
+	w := httptest.NewRecorder()
+	NewClientsHandler(fakeRegistry{}, nil).HandleClient(w, httptest.NewRequest("DELETE", "/api/clients/browser-1", nil))
+	if w.Code != http.StatusOK {
+		t.Errorf("status %d, want 200", w.Code)
+	}
+}
@@ -9,6 +9,7 @@ import (
 
 type AuthHandler struct {
 	AuthService *services.AuthService
+	Audit       Auditor
 }
 
 type LoginRequest struct {
@@ -22,8 +23,8 @@ type AuthResponse struct {
 	ExpiresAt     string `json:"expires_at,omitempty"`
 }
 
-func NewAuthHandler(as *services.AuthService) *AuthHandler {
-	return &AuthHandler{AuthService: as}
+func NewAuthHandler(as *services.AuthService, audit Auditor) *AuthHandler {
+	return &AuthHandler{AuthService: as, Audit: orNopAuditor(audit)}
 }
 
 func (h *AuthHandler) HandleLogin(w http.ResponseWriter, r *http.Request) {
@@ -47,6 +48,7 @@ func (h *AuthHandler) HandleLogin(w http.ResponseWriter, r *http.Request) {
 	}
 
 	if req.PIN != h.AuthService.PIN {
+		h.Audit.RecordRequest(r, services.AuditLoginFailed, "", nil)
 		w.WriteHeader(http.StatusUnauthorized)
 		json.NewEncoder(w).Encode(AuthResponse{Success: false, Message: "Invalid PIN"})
 		return
@@ -54,6 +56,7 @@ func (h *AuthHandler) HandleLogin(w http.ResponseWriter, r *http.Request) {
 
 	session := h.AuthService.SessionStore.Create(r.Header.Get("User-Agent"), ip)
 	services.SetSessionCookie(w, session.ID)
+	h.Audit.Record(session.ID, ip, session.UserAgent, services.AuditLogin, "", nil)
 
 	json.NewEncoder(w).Encode(AuthResponse{
 		Success:       true,
@@ -64,6 +67,7 @@ func (h *AuthHandler) HandleLogin(w http.ResponseWriter, r *http.Request) {
 
 func (h *AuthHandler) HandleLogout(w http.ResponseWriter, r *http.Request) {
 	if sessionID := services.GetSessionID(r); sessionID != "" {
+		h.Audit.RecordRequest(r, services.AuditLogout, "", nil)
 		h.AuthService.SessionStore.Delete(sessionID)
 	}
 	services.ClearSessionCookie(w)
@@ -85,3 +89,12 @@ func (h *AuthHandler) HandleSession(w http.ResponseWriter, r *http.Request) {
 		ExpiresAt:     session.ExpiresAt.Format(time.RFC3339),
 	})
 }
+
+// HandleOverlayToken returns the read-only token used by overlays on /ws?type=overlay
+func (h *AuthHandler) HandleOverlayToken(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]string{
+		"token": h.AuthService.OverlayToken,
+		"scope": "read",
+	})
+}
@@ -0,0 +1,124 @@
+package handlers
+
+import (
+	"encoding/json"
+	"log"
+	"net/http"
+
+	"github.com/volleybratans/moblin-relay/models"
+)
+
+// AutomationService interface for dependency injection
+type AutomationService interface {
+	Config() models.AutomationConfig
+	SetConfig(config models.AutomationConfig) error
+	SetEnabled(enabled bool) error
+	Log() []models.AutomationLogEntry
+}
+
+// MatchEventLogger records match events such as set end or timeouts
+type MatchEventLogger interface {
+	LogMatchEvent(event string) error
+}
+
+// AutomationHandler handles automation-related HTTP endpoints
+type AutomationHandler struct {
+	service AutomationService
+	events  MatchEventLogger
+}
+
+// NewAutomationHandler creates a new automation handler
+func NewAutomationHandler(service AutomationService, events MatchEventLogger) *AutomationHandler {
+	return &AutomationHandler{
+		service: service,
+		events:  events,
+	}
+}
+
+// HandleConfig handles GET/PUT for automation rules
+func (h *AutomationHandler) HandleConfig(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+
+	switch r.Method {
+	case "GET":
+		json.NewEncoder(w).Encode(h.service.Config())
+
+	case "PUT":
+		var config models.AutomationConfig
+		if err := json.NewDecoder(r.Body).Decode(&config); err != nil {
+			http.Error(w, `{"error": "Invalid JSON"}`, http.StatusBadRequest)
+			return
+		}
+		if err := h.service.SetConfig(config); err != nil {
+			w.WriteHeader(http.StatusBadRequest)
+			json.NewEncoder(w).Encode(map[string]string{"error": err.Error()})
+			return
+		}
+		log.Printf("[AUTOMATION] Rules updated (%d rules, enabled: %v)", len(config.Rules), config.Enabled)
+		json.NewEncoder(w).Encode(h.service.Config())
+
+	default:
+		http.Error(w, `{"error": "Method not allowed"}`, http.StatusMethodNotAllowed)
+	}
+}
+
+// HandleEnabled switches all automation rules on or off
+func (h *AutomationHandler) HandleEnabled(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+
+	if r.Method != "POST" {
+		http.Error(w, `{"error": "Method not allowed"}`, http.StatusMethodNotAllowed)
+		return
+	}
+
+	var req struct {
+		Enabled *bool `json:"enabled"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil || req.Enabled == nil {
+		http.Error(w, `{"error": "Invalid JSON"}`, http.StatusBadRequest)
+		return
+	}
+	if err := h.service.SetEnabled(*req.Enabled); err != nil {
+		http.Error(w, `{"error": "Failed to save automation"}`, http.StatusInternalServerError)
+		return
+	}
+	json.NewEncoder(w).Encode(h.service.Config())
+}
+
+// HandleLog returns the rules that fired, newest first
+func (h *AutomationHandler) HandleLog(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+
+	if r.Method != "GET" {
+		http.Error(w, `{"error": "Method not allowed"}`, http.StatusMethodNotAllowed)
+		return
+	}
+
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"entries": h.service.Log(),
+	})
+}
+
+// HandleMatchEvent logs a match event (set_end, timeout, ...)
+func (h *AutomationHandler) HandleMatchEvent(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+
+	if r.Method != "POST" {
+		http.Error(w, `{"error": "Method not allowed"}`, http.StatusMethodNotAllowed)
+		return
+	}
+
+	var req struct {
+		Event string `json:"event"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, `{"error": "Invalid JSON"}`, http.StatusBadRequest)
+		return
+	}
+	if err := h.events.LogMatchEvent(req.Event); err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		json.NewEncoder(w).Encode(map[string]string{"error": err.Error()})
+		return
+	}
+	json.NewEncoder(w).Encode(map[string]interface{}{"success": true, "event": req.Event})
+}
@@ -0,0 +1,63 @@
+package handlers
+
+import (
+	"encoding/json"
+	"net/http"
+	"strings"
+
+	"github.com/volleybratans/moblin-relay/models"
+	"github.com/volleybratans/moblin-relay/services"
+)
+
+// ClientRegistry interface for dependency injection
+type ClientRegistry interface {
+	Clients() []models.ClientInfo
+	Kick(id string) bool
+}
+
+// ClientsHandler lists and disconnects WebSocket clients
+type ClientsHandler struct {
+	registry ClientRegistry
+	audit    Auditor
+}
+
+// NewClientsHandler creates a new clients handler
+func NewClientsHandler(registry ClientRegistry, audit Auditor) *ClientsHandler {
+	return &ClientsHandler{registry: registry, audit: orNopAuditor(audit)}
+}
+
+// HandleClients returns all connected clients
+func (h *ClientsHandler) HandleClients(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+
+	if r.Method != "GET" {
+		http.Error(w, `{"error": "Method not allowed"}`, http.StatusMethodNotAllowed)
+		return
+	}
+
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"clients": h.registry.Clients(),
+	})
+}
+
+// HandleClient disconnects the client in /api/clients/{id} on DELETE
+func (h *ClientsHandler) HandleClient(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+
+	if r.Method != "DELETE" {
+		http.Error(w, `{"error": "Method not allowed"}`, http.StatusMethodNotAllowed)
+		return
+	}
+
+	id := strings.TrimPrefix(r.URL.Path, "/api/clients/")
+	if id == "" || strings.Contains(id, "/") {
+		http.Error(w, `{"error": "Invalid client id"}`, http.StatusBadRequest)
+		return
+	}
+	if !h.registry.Kick(id) {
+		http.Error(w, `{"error": "Unknown client"}`, http.StatusNotFound)
+		return
+	}
+	h.audit.RecordRequest(r, services.AuditClientKick, "", map[string]string{"client": id})
+	json.NewEncoder(w).Encode(map[string]interface{}{"success": true})
+}
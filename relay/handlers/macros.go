@@ -0,0 +1,142 @@
+package handlers
+
+import (
+	"encoding/json"
+	"log"
+	"net/http"
+
+	"github.com/volleybratans/moblin-relay/models"
+	"github.com/volleybratans/moblin-relay/services"
+)
+
+// MacroService interface for dependency injection
+type MacroService interface {
+	Macros() []models.Macro
+	SetMacros(macros []models.Macro) error
+	Run(name, device string) (string, error)
+	Cancel(runID string) error
+	Runs() []models.MacroProgress
+}
+
+// ControlClientHeader names the relay socket a REST request acts for
+const ControlClientHeader = "X-Client-ID"
+
+// ControlLock reports whether a login session, acting for the relay socket
+// clientID, may send Moblin commands
+type ControlLock interface {
+	CanControl(sessionID, clientID string) error
+}
+
+// MacroHandler handles macro-related HTTP endpoints
+type MacroHandler struct {
+	service MacroService
+	control ControlLock
+	audit   Auditor
+}
+
+// NewMacroHandler creates a new macro handler
+func NewMacroHandler(service MacroService, control ControlLock, audit Auditor) *MacroHandler {
+	return &MacroHandler{service: service, control: control, audit: orNopAuditor(audit)}
+}
+
+// HandleMacros handles GET/PUT for stored macros
+func (h *MacroHandler) HandleMacros(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+
+	switch r.Method {
+	case "GET":
+		json.NewEncoder(w).Encode(h.service.Macros())
+
+	case "PUT":
+		var macros []models.Macro
+		if err := json.NewDecoder(r.Body).Decode(&macros); err != nil {
+			http.Error(w, `{"error": "Invalid JSON"}`, http.StatusBadRequest)
+			return
+		}
+		if err := h.service.SetMacros(macros); err != nil {
+			w.WriteHeader(http.StatusBadRequest)
+			json.NewEncoder(w).Encode(map[string]string{"error": err.Error()})
+			return
+		}
+		log.Printf("[MACROS] Macros updated (%d macros)", len(macros))
+		json.NewEncoder(w).Encode(h.service.Macros())
+
+	default:
+		http.Error(w, `{"error": "Method not allowed"}`, http.StatusMethodNotAllowed)
+	}
+}
+
+// HandleRun starts a macro
+func (h *MacroHandler) HandleRun(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+
+	if r.Method != "POST" {
+		http.Error(w, `{"error": "Method not allowed"}`, http.StatusMethodNotAllowed)
+		return
+	}
+
+	var req struct {
+		Name   string `json:"name"`
+		Device string `json:"device"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil || req.Name == "" {
+		http.Error(w, `{"error": "Invalid JSON"}`, http.StatusBadRequest)
+		return
+	}
+	if err := h.control.CanControl(services.GetSessionID(r), r.Header.Get(ControlClientHeader)); err != nil {
+		w.WriteHeader(http.StatusLocked)
+		json.NewEncoder(w).Encode(map[string]string{"error": err.Error()})
+		return
+	}
+	runID, err := h.service.Run(req.Name, req.Device)
+	if err != nil {
+		w.WriteHeader(http.StatusNotFound)
+		json.NewEncoder(w).Encode(map[string]string{"error": err.Error()})
+		return
+	}
+	h.audit.RecordRequest(r, services.AuditMacroRun, req.Device, map[string]string{"name": req.Name, "run_id": runID})
+	json.NewEncoder(w).Encode(map[string]string{"run_id": runID})
+}
+
+// HandleCancel stops a running macro
+func (h *MacroHandler) HandleCancel(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+
+	if r.Method != "POST" {
+		http.Error(w, `{"error": "Method not allowed"}`, http.StatusMethodNotAllowed)
+		return
+	}
+	if err := h.control.CanControl(services.GetSessionID(r), r.Header.Get(ControlClientHeader)); err != nil {
+		w.WriteHeader(http.StatusLocked)
+		json.NewEncoder(w).Encode(map[string]string{"error": err.Error()})
+		return
+	}
+
+	var req struct {
+		RunID string `json:"run_id"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil || req.RunID == "" {
+		http.Error(w, `{"error": "Invalid JSON"}`, http.StatusBadRequest)
+		return
+	}
+	if err := h.service.Cancel(req.RunID); err != nil {
+		http.Error(w, `{"error": "Unknown macro run"}`, http.StatusNotFound)
+		return
+	}
+	h.audit.RecordRequest(r, services.AuditMacroCancel, "", map[string]string{"run_id": req.RunID})
+	json.NewEncoder(w).Encode(map[string]interface{}{"success": true})
+}
+
+// HandleRuns returns the macros currently running
+func (h *MacroHandler) HandleRuns(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+
+	if r.Method != "GET" {
+		http.Error(w, `{"error": "Method not allowed"}`, http.StatusMethodNotAllowed)
+		return
+	}
+
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"runs": h.service.Runs(),
+	})
+}
@@ -6,6 +6,9 @@ import (
 	"log"
 	"net/http"
 	"github.com/volleybratans/moblin-relay/models"
+	"github.com/volleybratans/moblin-relay/protocol"
+	"github.com/volleybratans/moblin-relay/rules"
+	"github.com/volleybratans/moblin-relay/services"
 )
 
 // MatchdayStore interface for dependency injection
@@ -13,24 +16,34 @@ type MatchdayStore interface {
 	GetState() models.MatchdayState
 	UpdateState(newState models.MatchdayState) error
 	ParseDVV(url string) (models.MatchdayState, error)
+	AddPoint(team string, delta int) (models.MatchdayState, []string, error)
+	EndSet() (models.MatchdayState, []string, error)
+	Timeout(team string) (models.MatchdayState, []string, error)
+	Serve(team string) (models.MatchdayState, error)
+	ResetScore() (models.MatchdayState, error)
+	UndoScore() (models.MatchdayState, error)
 }
 
-// Broadcaster interface for sending updates to connected clients
+// Broadcaster interface for sending updates to clients subscribed to a topic
 type Broadcaster interface {
-	Broadcast(msg []byte)
+	Broadcast(topic string, msg []byte)
 }
 
 // MatchdayHandler handles matchday-related HTTP endpoints
 type MatchdayHandler struct {
 	store       MatchdayStore
 	broadcaster Broadcaster
+	events      MatchEventLogger
+	audit       Auditor
 }
 
 // NewMatchdayHandler creates a new matchday handler
-func NewMatchdayHandler(store MatchdayStore, broadcaster Broadcaster) *MatchdayHandler {
+func NewMatchdayHandler(store MatchdayStore, broadcaster Broadcaster, events MatchEventLogger, audit Auditor) *MatchdayHandler {
 	return &MatchdayHandler{
 		store:       store,
 		broadcaster: broadcaster,
+		events:      events,
+		audit:       orNopAuditor(audit),
 	}
 }
 
@@ -49,6 +62,13 @@ func (h *MatchdayHandler) HandleAPI(w http.ResponseWriter, r *http.Request) {
 			http.Error(w, `{"error": "Invalid JSON"}`, http.StatusBadRequest)
 			return
 		}
+		if newState.Format != "" {
+			if _, err := rules.Lookup(newState.Format); err != nil {
+				w.WriteHeader(http.StatusBadRequest)
+				json.NewEncoder(w).Encode(map[string]string{"error": err.Error()})
+				return
+			}
+		}
 
 		if err := h.store.UpdateState(newState); err != nil {
 			http.Error(w, `{"error": "Failed to save state"}`, http.StatusInternalServerError)
@@ -57,8 +77,9 @@ func (h *MatchdayHandler) HandleAPI(w http.ResponseWriter, r *http.Request) {
 
 		updatedState := h.store.GetState()
 		log.Printf("[MATCHDAY] State updated (version %d)", updatedState.Version)
+		h.audit.RecordRequest(r, services.AuditMatchdayUpdate, "", map[string]int64{"version": updatedState.Version})
 
-		// Broadcast update to all connected browsers
+		// Broadcast update to all subscribed clients
 		if h.broadcaster != nil {
 			broadcastMsg := map[string]interface{}{
 				"type":    "matchday_update",
@@ -66,7 +87,7 @@ func (h *MatchdayHandler) HandleAPI(w http.ResponseWriter, r *http.Request) {
 				"data":    updatedState,
 			}
 			broadcastData, _ := json.Marshal(broadcastMsg)
-			h.broadcaster.Broadcast(broadcastData)
+			h.broadcaster.Broadcast(protocol.TopicMatchday, broadcastData)
 		}
 
 		json.NewEncoder(w).Encode(updatedState)
@@ -0,0 +1,45 @@
+package handlers
+
+import (
+	"encoding/json"
+	"net/http"
+
+	"github.com/volleybratans/moblin-relay/models"
+)
+
+// ClientMetricsSource interface for dependency injection
+type ClientMetricsSource interface {
+	ClientMetrics() []models.ClientMetrics
+}
+
+// RelayHandler handles relay diagnostics endpoints
+type RelayHandler struct {
+	source ClientMetricsSource
+}
+
+// NewRelayHandler creates a new relay handler
+func NewRelayHandler(source ClientMetricsSource) *RelayHandler {
+	return &RelayHandler{source: source}
+}
+
+// HandleMetrics returns delivery metrics for all connected clients
+func (h *RelayHandler) HandleMetrics(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+
+	if r.Method != "GET" {
+		http.Error(w, `{"error": "Method not allowed"}`, http.StatusMethodNotAllowed)
+		return
+	}
+
+	clients := h.source.ClientMetrics()
+	var delivered, dropped uint64
+	for _, c := range clients {
+		delivered += c.Delivered
+		dropped += c.Dropped
+	}
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"clients":   clients,
+		"delivered": delivered,
+		"dropped":   dropped,
+	})
+}
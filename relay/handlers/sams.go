@@ -0,0 +1,35 @@
+package handlers
+
+import (
+	"encoding/json"
+	"net/http"
+
+	"github.com/volleybratans/moblin-relay/models"
+)
+
+// SamsService interface for dependency injection
+type SamsService interface {
+	Status() models.SamsStatus
+}
+
+// SamsHandler handles the SAMS ticker endpoint
+type SamsHandler struct {
+	service SamsService
+}
+
+// NewSamsHandler creates a new SAMS handler
+func NewSamsHandler(service SamsService) *SamsHandler {
+	return &SamsHandler{service: service}
+}
+
+// HandleStatus returns the cached ticker data and the poller state
+func (h *SamsHandler) HandleStatus(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+
+	if r.Method != "GET" {
+		http.Error(w, `{"error": "Method not allowed"}`, http.StatusMethodNotAllowed)
+		return
+	}
+
+	json.NewEncoder(w).Encode(h.service.Status())
+}
@@ -0,0 +1,145 @@
+package handlers
+
+import (
+	"encoding/json"
+	"errors"
+	"io"
+	"log"
+	"net/http"
+
+	"github.com/volleybratans/moblin-relay/models"
+	"github.com/volleybratans/moblin-relay/protocol"
+	"github.com/volleybratans/moblin-relay/services"
+	"github.com/volleybratans/moblin-relay/stores"
+)
+
+// HandlePoint adds or removes a point: {"team": "home", "delta": 1}
+func (h *MatchdayHandler) HandlePoint(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+
+	if r.Method != "POST" {
+		http.Error(w, `{"error": "Method not allowed"}`, http.StatusMethodNotAllowed)
+		return
+	}
+
+	var req struct {
+		Team  string `json:"team"`
+		Delta *int   `json:"delta"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, `{"error": "Invalid JSON"}`, http.StatusBadRequest)
+		return
+	}
+	delta := 1
+	if req.Delta != nil {
+		delta = *req.Delta
+	}
+	state, events, err := h.store.AddPoint(req.Team, delta)
+	h.respondScore(w, r, "point", state, events, err)
+}
+
+// HandleTimeout records a timeout: {"team": "home"}
+func (h *MatchdayHandler) HandleTimeout(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+
+	if r.Method != "POST" {
+		http.Error(w, `{"error": "Method not allowed"}`, http.StatusMethodNotAllowed)
+		return
+	}
+
+	var req struct {
+		Team string `json:"team"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, `{"error": "Invalid JSON"}`, http.StatusBadRequest)
+		return
+	}
+	state, events, err := h.store.Timeout(req.Team)
+	h.respondScore(w, r, "timeout", state, events, err)
+}
+
+// HandleUndo reverts the last score change
+func (h *MatchdayHandler) HandleUndo(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+
+	if r.Method != "POST" {
+		http.Error(w, `{"error": "Method not allowed"}`, http.StatusMethodNotAllowed)
+		return
+	}
+
+	state, err := h.store.UndoScore()
+	h.respondScore(w, r, "undo", state, nil, err)
+}
+
+// HandleSet ends the current set. {"action": "reset"} resets the score and
+// {"action": "serve", "team": "home"} sets who serves first in the set.
+func (h *MatchdayHandler) HandleSet(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+
+	if r.Method != "POST" {
+		http.Error(w, `{"error": "Method not allowed"}`, http.StatusMethodNotAllowed)
+		return
+	}
+
+	var req struct {
+		Action string `json:"action"`
+		Team   string `json:"team"`
+	}
+	// The body is optional
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil && err != io.EOF {
+		http.Error(w, `{"error": "Invalid JSON"}`, http.StatusBadRequest)
+		return
+	}
+
+	switch req.Action {
+	case "", "end":
+		state, events, err := h.store.EndSet()
+		h.respondScore(w, r, "set_end", state, events, err)
+	case "reset":
+		state, err := h.store.ResetScore()
+		h.respondScore(w, r, "reset", state, nil, err)
+	case "serve":
+		state, err := h.store.Serve(req.Team)
+		h.respondScore(w, r, "serve", state, nil, err)
+	default:
+		http.Error(w, `{"error": "Unknown action"}`, http.StatusBadRequest)
+	}
+}
+
+// respondScore broadcasts a changed score, logs the match events the
+// rules raised for it and writes the new state. A change the store could
+// not save is a server error, anything else the rules rejected.
+func (h *MatchdayHandler) respondScore(w http.ResponseWriter, r *http.Request, action string, state models.MatchdayState, events []string, err error) {
+	if errors.Is(err, stores.ErrScoreNotSaved) {
+		w.WriteHeader(http.StatusInternalServerError)
+		json.NewEncoder(w).Encode(map[string]string{"error": err.Error()})
+		return
+	}
+	if err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		json.NewEncoder(w).Encode(map[string]string{"error": err.Error()})
+		return
+	}
+
+	score := state.Score
+	log.Printf("[MATCHDAY] Score %s: %d:%d in set %d, sets %d:%d (version %d)",
+		action, score.HomePoints, score.AwayPoints, score.CurrentSet, score.HomeSets, score.AwaySets, state.Version)
+	h.audit.RecordRequest(r, services.AuditScoreUpdate, "", map[string]interface{}{"action": action, "version": state.Version, "score": score})
+
+	if h.broadcaster != nil {
+		broadcastData, _ := json.Marshal(map[string]interface{}{
+			"type":    "score_update",
+			"version": state.Version,
+			"data":    score,
+		})
+		h.broadcaster.Broadcast(protocol.TopicMatchday, broadcastData)
+	}
+	if h.events != nil {
+		// Automation rules react to these like to logged match events
+		for _, event := range events {
+			h.events.LogMatchEvent(event)
+		}
+	}
+
+	json.NewEncoder(w).Encode(state)
+}
@@ -0,0 +1,140 @@
+package handlers
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/volleybratans/moblin-relay/models"
+	"github.com/volleybratans/moblin-relay/stores"
+)
+
+// countingBroadcaster counts score broadcasts
+type countingBroadcaster struct {
+	count int
+}
+
+func (b *countingBroadcaster) Broadcast(topic string, msg []byte) {
+	b.count++
+}
+
+func newScoreHandler(t *testing.T) (*MatchdayHandler, *countingBroadcaster, string) {
+	t.Helper()
+	dir := t.TempDir()
+	store, err := stores.NewMatchdayStore(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	broadcaster := &countingBroadcaster{}
+	return NewMatchdayHandler(store, broadcaster, nil, nil), broadcaster, dir
+}
+
+// post calls a score endpoint and returns the status and decoded state
+func post(t *testing.T, handle http.HandlerFunc, body string) (int, models.MatchdayState) {
+	t.Helper()
+	w := httptest.NewRecorder()
+	handle(w, httptest.NewRequest("POST", "/api/matchday", strings.NewReader(body)))
+	var state models.MatchdayState
+	if w.Code == http.StatusOK {
+		json.NewDecoder(w.Body).Decode(&state)
+	}
+	return w.Code, state
+}
+
+func TestHandleUndoLimit(t *testing.T) {
+	h, _, _ := newScoreHandler(t)
+	// Alternating points never win the set
+	for i := 0; i < 205; i++ {
+		team := []string{"home", "away"}[i%2]
+		if status, _ := post(t, h.HandlePoint, `{"team": "`+team+`"}`); status != http.StatusOK {
+			t.Fatalf("point %d: status %d", i+1, status)
+		}
+	}
+
+	for i := 0; i < 200; i++ {
+		if status, _ := post(t, h.HandleUndo, ""); status != http.StatusOK {
+			t.Fatalf("undo %d: status %d", i+1, status)
+		}
+	}
+	if status, _ := post(t, h.HandleUndo, ""); status != http.StatusBadRequest {
+		t.Errorf("undo beyond the limit: status %d, want 400", status)
+	}
+	w := httptest.NewRecorder()
+	h.HandleUndo(w, httptest.NewRequest("GET", "/api/matchday/undo", nil))
+	if w.Code != http.StatusMethodNotAllowed {
+		t.Errorf("GET undo: status %d, want 405", w.Code)
+	}
+}
+
+func TestHandlePointAfterSetWon(t *testing.T) {
+	h, broadcaster, _ := newScoreHandler(t)
+	var state models.MatchdayState
+	for i := 0; i < 25; i++ {
+		_, state = post(t, h.HandlePoint, `{"team": "home"}`)
+	}
+	if state.Score.HomePoints != 25 {
+		t.Fatalf("score %+v after 25 points", state.Score)
+	}
+	sent := broadcaster.count
+
+	if status, _ := post(t, h.HandlePoint, `{"team": "away"}`); status != http.StatusBadRequest {
+		t.Errorf("point after the set was won: status %d, want 400", status)
+	}
+	if broadcaster.count != sent {
+		t.Error("rejected point was broadcast")
+	}
+
+	// Ending the set allows points again
+	if status, state := post(t, h.HandleSet, `{"action": "end"}`); status != http.StatusOK || state.Score.HomeSets != 1 {
+		t.Fatalf("end set: status %d, score %+v", status, state.Score)
+	}
+	if status, _ := post(t, h.HandlePoint, `{"team": "away"}`); status != http.StatusOK {
+		t.Errorf("point in the next set: status %d", status)
+	}
+}
+
+func TestHandlePointNotSaved(t *testing.T) {
+	h, broadcaster, dir := newScoreHandler(t)
+	_, before := post(t, h.HandlePoint, `{"team": "home"}`)
+
+	// A directory in place of the state file makes every save fail
+	file := filepath.Join(dir, "matchday-current.json")
+	if err := os.Remove(file); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Mkdir(file, 0755); err != nil {
+		t.Fatal(err)
+	}
+	sent := broadcaster.count
+
+	for _, tt := range []struct {
+		name   string
+		handle http.HandlerFunc
+		body   string
+	}{
+		{"point", h.HandlePoint, `{"team": "home"}`},
+		{"undo", h.HandleUndo, ""},
+		{"reset", h.HandleSet, `{"action": "reset"}`},
+	} {
+		if status, _ := post(t, tt.handle, tt.body); status != http.StatusInternalServerError {
+			t.Errorf("%s: status %d, want 500", tt.name, status)
+		}
+	}
+	if broadcaster.count != sent {
+		t.Error("unsaved change was broadcast")
+	}
+
+	// The failed changes were rolled back
+	os.Remove(file)
+	status, after := post(t, h.HandleUndo, "")
+	if status != http.StatusOK || after.Score.HomePoints != before.Score.HomePoints-1 {
+		t.Errorf("undo after failed saves: status %d, score %+v", status, after.Score)
+	}
+	if after.Version != before.Version+1 {
+		t.Errorf("version %d, want %d", after.Version, before.Version+1)
+	}
+}
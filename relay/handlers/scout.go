@@ -6,6 +6,8 @@ import (
 	"net/http"
 	"time"
 	"github.com/volleybratans/moblin-relay/models"
+	"github.com/volleybratans/moblin-relay/protocol"
+	"github.com/volleybratans/moblin-relay/services"
 )
 
 // ScoutStore interface for dependency injection
@@ -20,13 +22,15 @@ type ScoutStore interface {
 type ScoutHandler struct {
 	store       ScoutStore
 	broadcaster Broadcaster
+	audit       Auditor
 }
 
 // NewScoutHandler creates a new scout handler
-func NewScoutHandler(store ScoutStore, broadcaster Broadcaster) *ScoutHandler {
+func NewScoutHandler(store ScoutStore, broadcaster Broadcaster, audit Auditor) *ScoutHandler {
 	return &ScoutHandler{
 		store:       store,
 		broadcaster: broadcaster,
+		audit:       orNopAuditor(audit),
 	}
 }
 
@@ -54,15 +58,17 @@ func (h *ScoutHandler) HandleAPI(w http.ResponseWriter, r *http.Request) {
 
 		updatedState := h.store.GetState()
 		log.Printf("[SCOUT] State updated (version %d)", updatedState.Version)
+		h.audit.RecordRequest(r, services.AuditScoutUpdate, "", map[string]int64{"version": updatedState.Version})
 
-		// Broadcast update to all connected browsers via WebSocket
+		// Broadcast update to all subscribed clients via WebSocket
 		if h.broadcaster != nil {
 			broadcastMsg := map[string]interface{}{
 				"type":    "scout_update",
 				"version": updatedState.Version,
+				"data":    updatedState,
 			}
 			broadcastData, _ := json.Marshal(broadcastMsg)
-			h.broadcaster.Broadcast(broadcastData)
+			h.broadcaster.Broadcast(protocol.TopicScout, broadcastData)
 		}
 
 		json.NewEncoder(w).Encode(updatedState)
@@ -96,6 +102,7 @@ func (h *ScoutHandler) HandleArchive(w http.ResponseWriter, r *http.Request) {
 	}
 
 	log.Printf("[SCOUT] Match archived successfully")
+	h.audit.RecordRequest(r, services.AuditScoutArchive, "", nil)
 	json.NewEncoder(w).Encode(map[string]interface{}{
 		"status":  "ok",
 		"message": "Match archived successfully",
@@ -0,0 +1,35 @@
+package handlers
+
+import (
+	"encoding/json"
+	"net/http"
+
+	"github.com/volleybratans/moblin-relay/models"
+)
+
+// StreamStore interface for dependency injection
+type StreamStore interface {
+	GetSnapshot() models.StreamSnapshot
+}
+
+// StreamHandler handles stream-related HTTP endpoints
+type StreamHandler struct {
+	store StreamStore
+}
+
+// NewStreamHandler creates a new stream handler
+func NewStreamHandler(store StreamStore) *StreamHandler {
+	return &StreamHandler{store: store}
+}
+
+// HandleState returns the last known state of all Moblin devices
+func (h *StreamHandler) HandleState(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+
+	if r.Method != "GET" {
+		http.Error(w, `{"error": "Method not allowed"}`, http.StatusMethodNotAllowed)
+		return
+	}
+
+	json.NewEncoder(w).Encode(h.store.GetSnapshot())
+}
@@ -0,0 +1,135 @@
+package handlers
+
+import (
+	"encoding/json"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/volleybratans/moblin-relay/models"
+)
+
+// TelemetryStore interface for dependency injection
+type TelemetryStore interface {
+	Query(device string, from, to time.Time, resolution time.Duration) ([]models.TelemetrySession, error)
+}
+
+const (
+	defaultTelemetryRange = 6 * time.Hour
+	maxTelemetryPoints    = 720
+)
+
+// telemetryResolutions are the steps used when no resolution is given
+var telemetryResolutions = []time.Duration{
+	time.Second, 5 * time.Second, 10 * time.Second, 30 * time.Second,
+	time.Minute, 5 * time.Minute, 15 * time.Minute, time.Hour,
+}
+
+// TelemetryHandler serves recorded stream telemetry
+type TelemetryHandler struct {
+	store TelemetryStore
+}
+
+// NewTelemetryHandler creates a new telemetry handler
+func NewTelemetryHandler(store TelemetryStore) *TelemetryHandler {
+	return &TelemetryHandler{store: store}
+}
+
+// HandleTelemetry returns telemetry sessions between from and to
+// (RFC 3339 or unix seconds), optionally filtered by device
+func (h *TelemetryHandler) HandleTelemetry(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+
+	if r.Method != "GET" {
+		http.Error(w, `{"error": "Method not allowed"}`, http.StatusMethodNotAllowed)
+		return
+	}
+
+	query := r.URL.Query()
+	to := time.Now().UTC()
+	if v := query.Get("to"); v != "" {
+		t, ok := parseTime(v)
+		if !ok {
+			http.Error(w, `{"error": "Invalid to"}`, http.StatusBadRequest)
+			return
+		}
+		to = t
+	}
+	from := to.Add(-defaultTelemetryRange)
+	if v := query.Get("from"); v != "" {
+		t, ok := parseTime(v)
+		if !ok {
+			http.Error(w, `{"error": "Invalid from"}`, http.StatusBadRequest)
+			return
+		}
+		from = t
+	}
+	if !from.Before(to) {
+		http.Error(w, `{"error": "from must be before to"}`, http.StatusBadRequest)
+		return
+	}
+
+	var resolution time.Duration
+	switch v := query.Get("resolution"); v {
+	case "":
+		resolution = autoResolution(to.Sub(from))
+	case "raw":
+	default:
+		d, err := time.ParseDuration(v)
+		if err != nil || d < time.Second {
+			http.Error(w, `{"error": "Invalid resolution"}`, http.StatusBadRequest)
+			return
+		}
+		resolution = d
+	}
+
+	sessions, err := h.store.Query(query.Get("device"), from, to, resolution)
+	if err != nil {
+		http.Error(w, `{"error": "Failed to read telemetry"}`, http.StatusInternalServerError)
+		return
+	}
+
+	res := "raw"
+	if resolution > 0 {
+		res = formatResolution(resolution)
+	}
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"from":       from.UTC().Format(time.RFC3339),
+		"to":         to.UTC().Format(time.RFC3339),
+		"resolution": res,
+		"sessions":   sessions,
+	})
+}
+
+// autoResolution picks the smallest step that keeps a range below maxTelemetryPoints
+func autoResolution(span time.Duration) time.Duration {
+	for _, step := range telemetryResolutions {
+		if span/step <= maxTelemetryPoints {
+			return step
+		}
+	}
+	return telemetryResolutions[len(telemetryResolutions)-1]
+}
+
+// formatResolution prints 1m instead of 1m0s
+func formatResolution(d time.Duration) string {
+	s := d.String()
+	if strings.HasSuffix(s, "m0s") {
+		s = s[:len(s)-2]
+	}
+	if strings.HasSuffix(s, "h0m") {
+		s = s[:len(s)-2]
+	}
+	return s
+}
+
+func parseTime(v string) (time.Time, bool) {
+	if t, err := time.Parse(time.RFC3339, v); err == nil {
+		return t, true
+	}
+	if secs, err := strconv.ParseInt(v, 10, 64); err == nil {
+		return time.Unix(secs, 0).UTC(), true
+	}
+	return time.Time{}, false
+}
@@ -0,0 +1,145 @@
+/**
+ * Latency - Round trip measurement from WebSocket ping/pong
+ * Every client keeps its recent round trips; for Moblin devices the
+ * control link quality is forwarded to browsers and fed into the alerts.
+ */
+
+package main
+
+import (
+	"encoding/json"
+	"math"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/gorilla/websocket"
+	"github.com/volleybratans/moblin-relay/models"
+	"github.com/volleybratans/moblin-relay/protocol"
+)
+
+const (
+	// DefaultPingInterval is how often clients are pinged
+	DefaultPingInterval = 10 * time.Second
+
+	// latencyHistory is the number of round trips kept per client
+	latencyHistory = 30
+
+	// minPongWait is the shortest time a client may go without a pong or frame
+	minPongWait = 60 * time.Second
+)
+
+// latencyStats is a ring of the most recent round trips of a client
+type latencyStats struct {
+	samples [latencyHistory]time.Duration
+	count   int
+	next    int
+	mu      sync.Mutex
+}
+
+// add records a round trip
+func (l *latencyStats) add(rtt time.Duration) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.samples[l.next] = rtt
+	l.next = (l.next + 1) % latencyHistory
+	if l.count < latencyHistory {
+		l.count++
+	}
+}
+
+// last returns the most recent round trip, or 0 before the first pong
+func (l *latencyStats) last() time.Duration {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if l.count == 0 {
+		return 0
+	}
+	return l.samples[(l.next+latencyHistory-1)%latencyHistory]
+}
+
+// summary describes the recorded round trips, oldest first in History.
+// Jitter is the mean difference between consecutive round trips.
+// It returns nil before the first pong.
+func (l *latencyStats) summary(withHistory bool) *models.Latency {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if l.count == 0 {
+		return nil
+	}
+	start := (l.next - l.count + latencyHistory) % latencyHistory
+	lat := &models.Latency{Samples: l.count, MinMs: math.MaxFloat64}
+	var sum, diffs float64
+	prev := -1.0
+	for i := 0; i < l.count; i++ {
+		ms := durationMs(l.samples[(start+i)%latencyHistory])
+		sum += ms
+		lat.MinMs = math.Min(lat.MinMs, ms)
+		lat.MaxMs = math.Max(lat.MaxMs, ms)
+		if prev >= 0 {
+			diffs += math.Abs(ms - prev)
+		}
+		prev = ms
+		if withHistory {
+			lat.HistoryMs = append(lat.HistoryMs, ms)
+		}
+	}
+	lat.RTTMs = prev
+	lat.AvgMs = roundMs(sum / float64(l.count))
+	if l.count > 1 {
+		lat.JitterMs = roundMs(diffs / float64(l.count-1))
+	}
+	return lat
+}
+
+// durationMs converts a duration to milliseconds with one decimal
+func durationMs(d time.Duration) float64 {
+	return roundMs(float64(d) / float64(time.Millisecond))
+}
+
+func roundMs(ms float64) float64 {
+	return math.Round(ms*10) / 10
+}
+
+// pongWait is how long a client may stay silent before it is disconnected
+func (r *Relay) pongWait() time.Duration {
+	return max(minPongWait, 3*r.pingInterval)
+}
+
+// ping sends a WebSocket ping carrying the send time, so the pong
+// measures the round trip
+func (c *Client) ping() error {
+	payload := strconv.FormatInt(time.Now().UnixNano(), 10)
+	return c.Conn.WriteMessage(websocket.PingMessage, []byte(payload))
+}
+
+// handlePong records the round trip of a ping sent by ping
+func (c *Client) handlePong(appData string) {
+	sent, err := strconv.ParseInt(appData, 10, 64)
+	if err != nil {
+		return
+	}
+	rtt := time.Since(time.Unix(0, sent))
+	if rtt <= 0 {
+		return
+	}
+	c.latency.add(rtt)
+	if c.Type == ClientTypeMoblin && c.isAuthorized() {
+		c.Relay.reportLink(c)
+	}
+}
+
+// reportLink forwards the control link quality of a Moblin device to
+// browsers and the alert rules
+func (r *Relay) reportLink(c *Client) {
+	lat := c.latency.summary(false)
+	if lat == nil {
+		return
+	}
+	if r.alerts != nil {
+		r.alerts.ObserveLink(c.Device, int(math.Round(lat.RTTMs)), int(math.Round(lat.JitterMs)))
+	}
+	data, _ := json.Marshal(lat)
+	msg, _ := json.Marshal(Message{Type: protocol.TypeLinkQuality, Device: c.Device, Data: data})
+	r.routeToBrowsers(msg)
+}
@@ -0,0 +1,89 @@
+package main
+
+import (
+	"reflect"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/volleybratans/moblin-relay/models"
+)
+
+func TestLatencySummary(t *testing.T) {
+	tests := []struct {
+		name    string
+		samples []time.Duration
+		want    *models.Latency
+	}{
+		{"no pong yet", nil, nil},
+		{
+			name:    "single",
+			samples: []time.Duration{42 * time.Millisecond},
+			want:    &models.Latency{RTTMs: 42, AvgMs: 42, MinMs: 42, MaxMs: 42, Samples: 1, HistoryMs: []float64{42}},
+		},
+		{
+			name:    "jitter",
+			samples: []time.Duration{10 * time.Millisecond, 20 * time.Millisecond, 40 * time.Millisecond},
+			want:    &models.Latency{RTTMs: 40, AvgMs: 23.3, MinMs: 10, MaxMs: 40, JitterMs: 15, Samples: 3, HistoryMs: []float64{10, 20, 40}},
+		},
+		{
+			name:    "sub-millisecond",
+			samples: []time.Duration{1250 * time.Microsecond},
+			want:    &models.Latency{RTTMs: 1.3, AvgMs: 1.3, MinMs: 1.3, MaxMs: 1.3, Samples: 1, HistoryMs: []float64{1.3}},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var l latencyStats
+			for _, rtt := range tt.samples {
+				l.add(rtt)
+			}
+			if got := l.summary(true); !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("summary %+v, want %+v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestLatencyHistoryWraps(t *testing.T) {
+	var l latencyStats
+	for i := 1; i <= latencyHistory+5; i++ {
+		l.add(time.Duration(i) * time.Millisecond)
+	}
+	lat := l.summary(true)
+	if lat.Samples != latencyHistory || lat.HistoryMs[0] != 6 || lat.RTTMs != latencyHistory+5 {
+		t.Errorf("after wrapping: %d samples, oldest %v, latest %v", lat.Samples, lat.HistoryMs[0], lat.RTTMs)
+	}
+	if l.last() != (latencyHistory+5)*time.Millisecond {
+		t.Errorf("last %s", l.last())
+	}
+}
+
+func TestHandlePong(t *testing.T) {
+	tests := []struct {
+		name    string
+		payload string
+		ok      bool
+	}{
+		{"round trip", strconv.FormatInt(time.Now().Add(-50*time.Millisecond).UnixNano(), 10), true},
+		{"not a timestamp", "hello", false},
+		{"empty", "", false},
+		{"from the future", strconv.FormatInt(time.Now().Add(time.Hour).UnixNano(), 10), false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			c := &Client{Type: ClientTypeBrowser}
+			c.handlePong(tt.payload)
+			rtt := c.latency.last()
+			if !tt.ok {
+				if rtt != 0 {
+					t.Errorf("recorded %s", rtt)
+				}
+				return
+			}
+			if rtt < 50*time.Millisecond || rtt > 5*time.Second {
+				t.Errorf("round trip %s, want about 50ms", rtt)
+			}
+		})
+	}
+}
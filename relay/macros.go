@@ -0,0 +1,62 @@
+/**
+ * Macros - Runs stored command sequences on behalf of browsers and
+ * broadcasts their progress on the stream topic
+ */
+
+package main
+
+import (
+	"encoding/json"
+
+	"github.com/volleybratans/moblin-relay/models"
+	"github.com/volleybratans/moblin-relay/protocol"
+	"github.com/volleybratans/moblin-relay/services"
+)
+
+// broadcastMacroProgress sends macro progress to browsers
+func (r *Relay) broadcastMacroProgress(progress models.MacroProgress) {
+	data, _ := json.Marshal(progress)
+	msg, _ := json.Marshal(Message{Type: protocol.TypeMacroProgress, Data: data})
+	r.Broadcast(protocol.TopicStream, msg)
+}
+
+// handleRunMacro starts a macro for a browser
+func (c *Client) handleRunMacro(env protocol.Envelope, raw []byte) {
+	req, err := protocol.ParseRunMacro(raw)
+	if err != nil {
+		c.sendError(err, env)
+		return
+	}
+	if c.Relay.macros == nil {
+		c.sendMacroError(env, "Macros are disabled")
+		return
+	}
+	runID, err := c.Relay.macros.Run(req.Name, req.Device)
+	if err != nil {
+		c.sendMacroError(env, err.Error())
+		return
+	}
+	c.Relay.audit.Record(c.SessionID, c.RemoteIP, c.UserAgent, services.AuditMacroRun, req.Device, map[string]string{"name": req.Name, "run_id": runID})
+}
+
+// handleCancelMacro cancels a running macro for a browser
+func (c *Client) handleCancelMacro(env protocol.Envelope, raw []byte) {
+	req, err := protocol.ParseCancelMacro(raw)
+	if err != nil {
+		c.sendError(err, env)
+		return
+	}
+	if c.Relay.macros == nil {
+		c.sendMacroError(env, "Macros are disabled")
+		return
+	}
+	if err := c.Relay.macros.Cancel(req.RunID); err != nil {
+		c.sendMacroError(env, err.Error())
+		return
+	}
+	c.Relay.audit.Record(c.SessionID, c.RemoteIP, c.UserAgent, services.AuditMacroCancel, "", map[string]string{"run_id": req.RunID})
+}
+
+func (c *Client) sendMacroError(env protocol.Envelope, message string) {
+	c.sendJSON(Message{Type: protocol.TypeError, Status: "error", Code: protocol.CodeInvalidParams, Command: env.Type, Ref: env.Ref, Message: message})
+}
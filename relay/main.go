@@ -6,19 +6,29 @@
 package main
 
 import (
+	"context"
 	"encoding/json"
 	"flag"
 	"fmt"
 	"log"
 	"net/http"
 	"os"
+	"os/signal"
+	"regexp"
+	"sort"
 	"strings"
 	"sync"
+	"sync/atomic"
+	"syscall"
 	"time"
 
 	"github.com/gorilla/websocket"
+	"github.com/volleybratans/moblin-relay/capture"
+	"github.com/volleybratans/moblin-relay/events"
 	"github.com/volleybratans/moblin-relay/handlers"
 	"github.com/volleybratans/moblin-relay/middleware"
+	"github.com/volleybratans/moblin-relay/moblin"
+	"github.com/volleybratans/moblin-relay/protocol"
 	"github.com/volleybratans/moblin-relay/services"
 	"github.com/volleybratans/moblin-relay/stores"
 )
@@ -35,109 +45,330 @@ func init() {
 	log.SetOutput(timestampWriter{})
 }
 
-// ClientType distinguishes between Moblin App and Browser connections
+// ClientType distinguishes between Moblin App, Browser and Overlay connections
 type ClientType string
 
 const (
 	ClientTypeMoblin  ClientType = "moblin"
 	ClientTypeBrowser ClientType = "browser"
+	ClientTypeOverlay ClientType = "overlay" // read-only, token-scoped
 )
 
+// Moblin devices are addressed by name (?type=moblin&device=cam2)
+const (
+	DefaultDevice = "main"
+	DeviceAll     = "all"
+)
+
+var deviceNamePattern = regexp.MustCompile(`^[a-zA-Z0-9_-]{1,32}$`)
+
 // Client represents a connected WebSocket client
 type Client struct {
 	ID         string
 	Type       ClientType
+	Device     string
 	Conn       *websocket.Conn
-	Send       chan []byte
+	Send       chan []byte // bulk updates
+	Control    chan []byte // priority lane for Moblin commands
 	Relay      *Relay
 	Authorized bool
-	mu         sync.Mutex
+	RemoteIP   string
+	UserAgent  string
+	SessionID  string // login session that authorized this socket, if any
+	native     *moblin.Adapter
+	challenge  string
+	// Negotiated protocol version (protocol.MinVersion until the client says hello)
+	ProtocolVersion int
+	topics          map[string]bool
+	stats           deliveryStats
+	connectedAt     time.Time
+	lastMessage     int64 // unix nanos of the last received frame
+	latency         latencyStats
+	closed          bool
+	closeCode       int
+	closeReason     string
+	mu              sync.Mutex
 }
 
-// Message represents a JSON message structure
+// overlayTopics are the only topics read-only overlays may receive
+var overlayTopics = []string{protocol.TopicScout, protocol.TopicMatchday, protocol.TopicSams}
+
+// defaultTopics returns the topics a client is subscribed to on connect.
+// Moblin only receives commands addressed to it, never broadcasts.
+func defaultTopics(clientType ClientType) []string {
+	switch clientType {
+	case ClientTypeMoblin:
+		return nil
+	case ClientTypeOverlay:
+		return overlayTopics
+	}
+	return protocol.Topics
+}
+
+// topicAllowed reports whether a client type may subscribe to topic
+func topicAllowed(clientType ClientType, topic string) bool {
+	if clientType != ClientTypeOverlay {
+		return true
+	}
+	for _, t := range overlayTopics {
+		if t == topic {
+			return true
+		}
+	}
+	return false
+}
+
+// Message is the envelope for relay-level messages (auth, hello, relay
+// events). Moblin commands and events are typed in the protocol package.
 type Message struct {
 	Type     string          `json:"type"`
-	Password string          `json:"password,omitempty"`
+	Response string          `json:"response,omitempty"`
 	Data     json.RawMessage `json:"data,omitempty"`
-	Name     string          `json:"name,omitempty"`
-	Level    float64         `json:"level,omitempty"`
-	Kbps     int             `json:"kbps,omitempty"`
-	Scene    string          `json:"scene,omitempty"`
-	Bitrate  int             `json:"bitrate,omitempty"`
-	FPS      int             `json:"fps,omitempty"`
-	Battery  int             `json:"battery,omitempty"`
-	Viewers  int             `json:"viewers,omitempty"`
 	Message  string          `json:"message,omitempty"`
 	Status   string          `json:"status,omitempty"`
+	Code     string          `json:"code,omitempty"`
+	Device   string          `json:"device,omitempty"`
+	Devices  []string        `json:"devices,omitempty"`
+	Topics   []string        `json:"topics,omitempty"`
+
+	// Protocol negotiation and challenge/response authentication
+	ProtocolVersion    int    `json:"protocol_version,omitempty"`
+	MinProtocolVersion int    `json:"min_protocol_version,omitempty"`
+	Challenge          string `json:"challenge,omitempty"`
+	Salt               string `json:"salt,omitempty"`
+
+	// Command correlation
+	RequestID string `json:"request_id,omitempty"`
+	Command   string `json:"command,omitempty"`
+	Ref       string `json:"ref,omitempty"`
+
+	// Reconnect hint in milliseconds (server_restarting)
+	RetryAfter int `json:"retry_after,omitempty"`
 }
 
 // Relay manages all WebSocket connections and message routing
 type Relay struct {
-	clients    map[string]*Client
-	moblin     *Client
-	browsers   map[string]*Client
-	password   string
-	register   chan *Client
-	unregister chan *Client
-	broadcast  chan []byte
-	mu         sync.RWMutex
+	clients      map[string]*Client
+	moblins      map[string]*Client
+	browsers     map[string]*Client
+	overlays     map[string]*Client
+	password     string
+	salt         string
+	auth         *services.AuthService
+	authWait     time.Duration
+	slowTimeout  time.Duration
+	register     chan *Client
+	unregister   chan *Client
+	broadcast    chan broadcastMessage
+	commands     *CommandTracker
+	queue        *CommandQueue
+	streams      *stores.StreamStore
+	telemetry    *stores.TelemetryStore
+	alerts       *services.AlertService
+	events       *events.Bus
+	macros       *services.MacroService
+	sams         *services.SamsService
+	audit        *services.Auditor
+	capture      *capture.Recorder
+	control      controlLock
+	controlIdle  time.Duration
+	pingInterval time.Duration
+	closing      bool
+	mu           sync.RWMutex
+}
+
+// RelayConfig holds the tunable settings of a Relay
+type RelayConfig struct {
+	Password       string
+	AuthService    *services.AuthService
+	AuthTimeout    time.Duration
+	CommandTimeout time.Duration
+	// Clients whose send queue stays full this long are disconnected
+	SlowClientTimeout time.Duration
+	StreamStore       *stores.StreamStore
+	// Optional, records stream telemetry history
+	TelemetryStore *stores.TelemetryStore
+	// Optional, raises alerts from Moblin telemetry
+	AlertService *services.AlertService
+	// Optional, receives Moblin events, topic broadcasts and match events
+	Events *events.Bus
+	// Optional, runs command macros through this relay
+	MacroService *services.MacroService
+	// Optional, broadcasts SAMS ticker data
+	SamsService *services.SamsService
+	// Optional, records browser commands
+	Auditor *services.Auditor
+	// Optional, records every WebSocket frame
+	Capture *capture.Recorder
+	// The director lock is released after this long without a message from its holder
+	ControlIdle time.Duration
+	// Clients are pinged this often to measure their round trip
+	PingInterval time.Duration
+}
+
+// broadcastMessage is a message for all clients subscribed to a topic
+type broadcastMessage struct {
+	topic string
+	data  []byte
 }
 
 // Satisfies handlers.Broadcaster interface
-func (r *Relay) Broadcast(msg []byte) {
-	r.broadcast <- msg
+func (r *Relay) Broadcast(topic string, msg []byte) {
+	r.broadcast <- broadcastMessage{topic: topic, data: msg}
+	r.publishBroadcast(msg)
 }
 
-func NewRelay(password string) *Relay {
-	return &Relay{
-		clients:    make(map[string]*Client),
-		browsers:   make(map[string]*Client),
-		password:   password,
-		register:   make(chan *Client),
-		unregister: make(chan *Client),
-		broadcast:  make(chan []byte, 256),
+func NewRelay(cfg RelayConfig) *Relay {
+	r := &Relay{
+		clients:      make(map[string]*Client),
+		moblins:      make(map[string]*Client),
+		browsers:     make(map[string]*Client),
+		overlays:     make(map[string]*Client),
+		password:     cfg.Password,
+		salt:         protocol.NewNonce(),
+		auth:         cfg.AuthService,
+		authWait:     cfg.AuthTimeout,
+		slowTimeout:  cfg.SlowClientTimeout,
+		register:     make(chan *Client),
+		unregister:   make(chan *Client),
+		broadcast:    make(chan broadcastMessage, 256),
+		queue:        NewCommandQueue(),
+		streams:      cfg.StreamStore,
+		telemetry:    cfg.TelemetryStore,
+		alerts:       cfg.AlertService,
+		events:       cfg.Events,
+		macros:       cfg.MacroService,
+		sams:         cfg.SamsService,
+		audit:        cfg.Auditor,
+		capture:      cfg.Capture,
+		controlIdle:  cfg.ControlIdle,
+		pingInterval: cfg.PingInterval,
+	}
+	if r.streams == nil {
+		r.streams = stores.NewStreamStore()
+	}
+	if r.authWait <= 0 {
+		r.authWait = DefaultAuthTimeout
 	}
+	if r.slowTimeout <= 0 {
+		r.slowTimeout = DefaultSlowClientTimeout
+	}
+	if r.controlIdle <= 0 {
+		r.controlIdle = DefaultControlIdle
+	}
+	if r.pingInterval <= 0 {
+		r.pingInterval = DefaultPingInterval
+	}
+	if r.auth != nil {
+		r.auth.SessionStore.OnRemove(r.closeSession)
+	}
+	if r.alerts != nil {
+		r.alerts.OnAlert(r.broadcastAlert)
+	}
+	if r.macros != nil {
+		r.macros.SetSender(r)
+		r.macros.OnProgress(r.broadcastMacroProgress)
+	}
+	if r.sams != nil {
+		r.sams.OnUpdate(r.broadcastSams)
+	}
+	r.commands = NewCommandTracker(cfg.CommandTimeout, func(cmd *pendingCommand) {
+		log.Printf("[RELAY] Command %s (%s) to %s timed out", cmd.RequestID, cmd.Command, cmd.Device)
+		r.replyCommand(cmd, "command_timeout", "No response from Moblin")
+	})
+	return r
 }
 
 func (r *Relay) Run() {
+	go r.expireLoop()
+	go r.slowClientLoop()
+	go r.controlLoop()
+	if r.auth != nil {
+		go r.sessionLoop()
+	}
 	for {
 		select {
 		case client := <-r.register:
 			r.mu.Lock()
 			r.clients[client.ID] = client
 			if client.Type == ClientTypeMoblin {
-				r.moblin = client
-				log.Printf("[RELAY] Moblin app connected: %s", client.ID)
-				r.notifyBrowsers(Message{Type: "moblin_connected"})
+				if old, ok := r.moblins[client.Device]; ok {
+					// A reconnecting phone replaces its stale connection
+					log.Printf("[RELAY] Moblin device %s replaced: %s -> %s", client.Device, old.ID, client.ID)
+					old.Conn.Close()
+				}
+				r.moblins[client.Device] = client
+				r.streams.SetConnected(client.Device, true)
+				if r.alerts != nil {
+					r.alerts.SetConnected(client.Device, true)
+				}
+				log.Printf("[RELAY] Moblin app connected: %s (device: %s, total: %d)", client.ID, client.Device, len(r.moblins))
+				r.notifyBrowsers(Message{Type: "moblin_connected", Device: client.Device, Devices: r.deviceNames()})
+			} else if client.Type == ClientTypeOverlay {
+				r.overlays[client.ID] = client
+				log.Printf("[RELAY] Overlay connected: %s (total: %d)", client.ID, len(r.overlays))
 			} else {
 				r.browsers[client.ID] = client
-				log.Printf("[RELAY] Browser connected: %s (total: %d)", client.ID, len(r.browsers))
+				if client.SessionID != "" {
+					log.Printf("[RELAY] Browser connected: %s (session: %s, total: %d)", client.ID, shortID(client.SessionID), len(r.browsers))
+				} else {
+					log.Printf("[RELAY] Browser connected: %s (total: %d)", client.ID, len(r.browsers))
+				}
 			}
+			r.notifyPresence()
 			r.mu.Unlock()
+			if client.Type == ClientTypeMoblin && client.isAuthorized() {
+				r.flushQueue(client)
+			} else if client.Type == ClientTypeBrowser && client.isAuthorized() {
+				client.sendSnapshot()
+				client.sendAlerts()
+				client.sendControl()
+				client.sendSams()
+			} else if client.Type == ClientTypeOverlay {
+				client.sendSams()
+			}
 
 		case client := <-r.unregister:
 			r.mu.Lock()
 			if _, ok := r.clients[client.ID]; ok {
 				delete(r.clients, client.ID)
-				close(client.Send)
+				client.closeQueues()
 				if client.Type == ClientTypeMoblin {
-					r.moblin = nil
-					log.Printf("[RELAY] Moblin app disconnected")
-					r.notifyBrowsers(Message{Type: "moblin_disconnected"})
+					// Only drop the registry entry if it still points to this connection
+					if r.moblins[client.Device] == client {
+						delete(r.moblins, client.Device)
+						r.streams.SetConnected(client.Device, false)
+						if r.telemetry != nil {
+							r.telemetry.EndSession(client.Device)
+						}
+						if r.alerts != nil {
+							r.alerts.SetConnected(client.Device, false)
+						}
+						log.Printf("[RELAY] Moblin app disconnected: %s (device: %s)", client.ID, client.Device)
+						r.notifyBrowsers(Message{Type: "moblin_disconnected", Device: client.Device, Devices: r.deviceNames()})
+						go r.failDeviceCommands(client.Device)
+					}
+				} else if client.Type == ClientTypeOverlay {
+					delete(r.overlays, client.ID)
+					log.Printf("[RELAY] Overlay disconnected: %s (remaining: %d)", client.ID, len(r.overlays))
 				} else {
 					delete(r.browsers, client.ID)
 					log.Printf("[RELAY] Browser disconnected: %s (remaining: %d)", client.ID, len(r.browsers))
 				}
+				r.notifyPresence()
 			}
 			r.mu.Unlock()
+			if client.Type == ClientTypeBrowser {
+				r.dropControl(client)
+			}
 
 		case message := <-r.broadcast:
 			r.mu.RLock()
 			for _, client := range r.clients {
-				select {
-				case client.Send <- message:
-				default:
+				if !client.isAuthorized() || !client.IsSubscribed(message.topic) {
+					continue
 				}
+				client.enqueue(message.data)
 			}
 			r.mu.RUnlock()
 		}
@@ -147,23 +378,31 @@ func (r *Relay) Run() {
 func (r *Relay) notifyBrowsers(msg Message) {
 	data, _ := json.Marshal(msg)
 	for _, browser := range r.browsers {
-		select {
-		case browser.Send <- data:
-		default:
+		if !browser.isAuthorized() || !browser.IsSubscribed(protocol.TopicStream) {
+			continue
 		}
+		browser.enqueue(data)
+	}
+}
+
+// deviceNames returns the sorted names of connected Moblin devices.
+// Caller must hold r.mu.
+func (r *Relay) deviceNames() []string {
+	names := make([]string, 0, len(r.moblins))
+	for name := range r.moblins {
+		names = append(names, name)
 	}
+	sort.Strings(names)
+	return names
 }
 
-func (r *Relay) routeToMoblin(msg []byte) {
+// sendTo delivers a message to a single client by ID
+func (r *Relay) sendTo(clientID string, msg Message) {
 	r.mu.RLock()
-	moblin := r.moblin
+	client, ok := r.clients[clientID]
 	r.mu.RUnlock()
-	if moblin == nil {
-		return
-	}
-	select {
-	case moblin.Send <- msg:
-	default:
+	if ok {
+		client.sendJSON(msg)
 	}
 }
 
@@ -171,11 +410,8 @@ func (r *Relay) routeToBrowsers(msg []byte) {
 	r.mu.RLock()
 	defer r.mu.RUnlock()
 	for _, browser := range r.browsers {
-		if browser.Authorized {
-			select {
-			case browser.Send <- msg:
-			default:
-			}
+		if browser.isAuthorized() && browser.IsSubscribed(protocol.TopicStream) {
+			browser.enqueue(msg)
 		}
 	}
 }
@@ -191,36 +427,96 @@ var upgrader = websocket.Upgrader{
 }
 
 func (r *Relay) ServeWS(w http.ResponseWriter, req *http.Request) {
-	conn, err := upgrader.Upgrade(w, req, nil)
-	if err != nil {
+	if r.isClosing() {
+		http.Error(w, "Server restarting", http.StatusServiceUnavailable)
 		return
 	}
 	clientType := ClientTypeBrowser
-	if req.URL.Query().Get("type") == "moblin" {
+	device := ""
+	sessionID := ""
+	authorized := r.password == ""
+	switch req.URL.Query().Get("type") {
+	case "moblin":
 		clientType = ClientTypeMoblin
+		device = req.URL.Query().Get("device")
+		if device == "" {
+			device = DefaultDevice
+		}
+		if device == DeviceAll || !deviceNamePattern.MatchString(device) {
+			http.Error(w, "Invalid device name", http.StatusBadRequest)
+			return
+		}
+	case "overlay":
+		// Overlays cannot log in, they present a read-only token instead
+		if r.auth == nil || !r.auth.ValidOverlayToken(req.URL.Query().Get("token")) {
+			http.Error(w, "Invalid overlay token", http.StatusUnauthorized)
+			return
+		}
+		clientType = ClientTypeOverlay
+		authorized = true
+	default:
+		// Logged-in browsers reuse their HTTP session instead of the relay password
+		if sessionID = r.sessionFromRequest(req); sessionID != "" {
+			authorized = true
+		}
 	}
-	client := &Client{
-		ID:         fmt.Sprintf("%s-%d", clientType, time.Now().UnixNano()),
-		Type:       clientType,
-		Conn:       conn,
-		Send:       make(chan []byte, 256),
-		Relay:      r,
-		Authorized: r.password == "",
+	conn, err := upgrader.Upgrade(w, req, nil)
+	if err != nil {
+		return
 	}
+	client := r.newClient(conn, req, clientType, device)
+	client.Authorized = authorized
+	client.SessionID = sessionID
+	hello := Message{
+		Type:               protocol.TypeHello,
+		ProtocolVersion:    protocol.Version,
+		MinProtocolVersion: protocol.MinVersion,
+	}
+	if !authorized {
+		client.challenge = protocol.NewNonce()
+		hello.Challenge = client.challenge
+		hello.Salt = r.salt
+		client.enforceAuthTimeout(r.authWait)
+	}
+	client.sendJSON(hello)
 	r.register <- client
 	go client.writePump()
 	go client.readPump()
 }
 
+// newClient creates an unauthorized client for an upgraded connection
+func (r *Relay) newClient(conn *websocket.Conn, req *http.Request, clientType ClientType, device string) *Client {
+	client := &Client{
+		ID:        fmt.Sprintf("%s-%d", clientType, time.Now().UnixNano()),
+		Type:      clientType,
+		Device:    device,
+		Conn:      conn,
+		Send:      make(chan []byte, sendQueueSize),
+		Control:   make(chan []byte, controlQueueSize),
+		Relay:     r,
+		RemoteIP:  services.GetClientIP(req),
+		UserAgent: req.Header.Get("User-Agent"),
+
+		ProtocolVersion: protocol.MinVersion,
+		connectedAt:     time.Now(),
+		topics:          make(map[string]bool),
+	}
+	client.subscribe(defaultTopics(clientType))
+	client.captureOpen(req)
+	return client
+}
+
 func (c *Client) readPump() {
 	defer func() {
 		c.Relay.unregister <- c
 		c.Conn.Close()
+		c.captureClose()
 	}()
 	c.Conn.SetReadLimit(65536)
-	c.Conn.SetReadDeadline(time.Now().Add(60 * time.Second))
-	c.Conn.SetPongHandler(func(string) error {
-		c.Conn.SetReadDeadline(time.Now().Add(60 * time.Second))
+	c.Conn.SetReadDeadline(time.Now().Add(c.Relay.pongWait()))
+	c.Conn.SetPongHandler(func(appData string) error {
+		c.handlePong(appData)
+		c.Conn.SetReadDeadline(time.Now().Add(c.Relay.pongWait()))
 		return nil
 	})
 	for {
@@ -228,99 +524,365 @@ func (c *Client) readPump() {
 		if err != nil {
 			break
 		}
-		var msg Message
-		if err := json.Unmarshal(message, &msg); err != nil {
+		c.touch()
+		c.captureFrame(capture.In, message)
+		if c.native != nil {
+			c.handleNative(message)
 			continue
 		}
-		c.handleMessage(msg, message)
+		c.handleMessage(message)
 	}
 }
 
-func (c *Client) handleMessage(msg Message, raw []byte) {
-	if msg.Type == "auth" {
-		if c.Relay.password == "" || msg.Password == c.Relay.password {
-			c.mu.Lock()
-			c.Authorized = true
-			c.mu.Unlock()
-			c.sendJSON(Message{Type: "auth_success", Status: "ok"})
-		} else {
-			c.sendJSON(Message{Type: "auth_failed", Status: "error", Message: "Invalid password"})
+func (c *Client) handleMessage(raw []byte) {
+	env, err := protocol.ParseEnvelope(raw)
+	if err != nil {
+		c.sendError(err, env)
+		return
+	}
+	switch env.Type {
+	case protocol.TypeSubscribe, protocol.TypeUnsubscribe:
+		c.handleSubscription(env, raw)
+		return
+	}
+
+	var msg Message
+	if env.Type == protocol.TypeHello || env.Type == protocol.TypeAuth {
+		if err := json.Unmarshal(raw, &msg); err != nil {
+			c.sendError(err, env)
+			return
 		}
+	}
+
+	switch env.Type {
+	case protocol.TypeHello:
+		c.handleHello(msg, env)
+		return
+	case protocol.TypeAuth:
+		c.handleAuth(msg)
 		return
 	}
-	if !c.Authorized && c.Relay.password != "" {
+	if !c.isAuthorized() {
+		c.sendJSON(Message{Type: protocol.TypeError, Code: protocol.CodeNotAuthorized, Message: "Not authorized", Ref: env.Ref})
 		return
 	}
 	if c.Type == ClientTypeMoblin {
-		c.Relay.routeToBrowsers(raw)
+		ev, err := protocol.ParseEvent(raw)
+		if err != nil {
+			c.sendError(err, env)
+			return
+		}
+		if reply, ok := ev.(*protocol.CommandReply); ok {
+			c.Relay.handleCommandReply(c, reply)
+			return
+		}
+		data, err := protocol.EncodeEvent(ev, c.Device)
+		if err != nil {
+			return
+		}
+		c.Relay.streams.Apply(c.Device, data)
+		c.Relay.recordTelemetry(c.Device, ev)
+		if c.Relay.alerts != nil {
+			c.Relay.alerts.Observe(c.Device, ev)
+		}
+		c.Relay.publish(events.Event{Type: ev.EventType(), Device: c.Device, Data: data})
+		c.Relay.routeToBrowsers(data)
+	} else if c.Type == ClientTypeOverlay {
+		c.sendJSON(Message{Type: protocol.TypeError, Status: "error", Code: protocol.CodeForbidden, Command: env.Type, Ref: env.Ref, Message: "Overlays are read-only"})
+	} else if env.Type == protocol.TypeAckAlert {
+		c.handleAlertAck(env, raw)
+	} else if env.Type == protocol.TypeMatchEvent {
+		c.handleMatchEvent(env, raw)
+	} else if protocol.IsControlType(env.Type) {
+		c.handleControl(env, raw)
+	} else if !c.checkControl(env) {
+		return
+	} else if env.Type == protocol.TypeRunMacro {
+		c.handleRunMacro(env, raw)
+	} else if env.Type == protocol.TypeCancelMacro {
+		c.handleCancelMacro(env, raw)
 	} else {
-		c.Relay.routeToMoblin(raw)
+		cmd, err := protocol.ParseCommand(raw)
+		if err != nil {
+			c.sendError(err, env)
+			return
+		}
+		c.Relay.audit.Record(c.SessionID, c.RemoteIP, c.UserAgent, services.AuditCommand, env.Device, json.RawMessage(raw))
+		c.Relay.dispatchCommand(commandOrigin{BrowserID: c.ID, Ref: env.Ref}, env.Device, cmd)
 	}
 }
 
+// handleHello negotiates the protocol version announced by the client
+func (c *Client) handleHello(msg Message, env protocol.Envelope) {
+	version, err := protocol.NegotiateVersion(msg.ProtocolVersion)
+	if err != nil {
+		c.sendError(err, env)
+		return
+	}
+	c.mu.Lock()
+	c.ProtocolVersion = version
+	c.mu.Unlock()
+	c.sendJSON(Message{Type: protocol.TypeHelloAck, Status: "ok", ProtocolVersion: version})
+}
+
+// handleSubscription adds or removes topics for this client
+func (c *Client) handleSubscription(env protocol.Envelope, raw []byte) {
+	if !c.isAuthorized() {
+		c.sendJSON(Message{Type: protocol.TypeError, Code: protocol.CodeNotAuthorized, Message: "Not authorized", Ref: env.Ref})
+		return
+	}
+	sub, err := protocol.ParseSubscription(raw)
+	if err != nil {
+		c.sendError(err, env)
+		return
+	}
+	for _, topic := range sub.Topics {
+		if env.Type == protocol.TypeSubscribe && !topicAllowed(c.Type, topic) {
+			c.sendJSON(Message{Type: protocol.TypeError, Status: "error", Code: protocol.CodeForbidden, Command: env.Type, Ref: env.Ref, Message: fmt.Sprintf("Topic %q not available for %s clients", topic, c.Type)})
+			return
+		}
+	}
+	if env.Type == protocol.TypeSubscribe {
+		c.subscribe(sub.Topics)
+	} else {
+		c.unsubscribe(sub.Topics)
+	}
+	c.sendJSON(Message{Type: protocol.TypeSubscribed, Status: "ok", Topics: c.Topics(), Ref: env.Ref})
+}
+
+func (c *Client) subscribe(topics []string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	for _, topic := range topics {
+		c.topics[topic] = true
+	}
+}
+
+func (c *Client) unsubscribe(topics []string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	for _, topic := range topics {
+		delete(c.topics, topic)
+	}
+}
+
+// IsSubscribed reports whether the client receives broadcasts for topic
+func (c *Client) IsSubscribed(topic string) bool {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.topics[topic]
+}
+
+// Topics returns the sorted topics the client is subscribed to
+func (c *Client) Topics() []string {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	topics := make([]string, 0, len(c.topics))
+	for topic := range c.topics {
+		topics = append(topics, topic)
+	}
+	sort.Strings(topics)
+	return topics
+}
+
+func (c *Client) isAuthorized() bool {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.Authorized
+}
+
+// sendError replies with a structured error message
+func (c *Client) sendError(err error, env protocol.Envelope) {
+	reply := Message{
+		Type:      protocol.TypeError,
+		Status:    "error",
+		Code:      protocol.CodeInvalidJSON,
+		Message:   err.Error(),
+		Command:   env.Type,
+		Ref:       env.Ref,
+		RequestID: env.RequestID,
+	}
+	if perr, ok := err.(*protocol.Error); ok {
+		reply.Code = perr.Code
+		reply.Message = perr.Message
+	}
+	c.sendJSON(reply)
+}
+
 func (c *Client) sendJSON(msg Message) {
 	data, _ := json.Marshal(msg)
-	select {
-	case c.Send <- data:
-	default:
-	}
+	c.enqueue(data)
+}
+
+// sendSnapshot sends the last known Moblin state so late joiners see it immediately
+func (c *Client) sendSnapshot() {
+	data, _ := json.Marshal(c.Relay.streams.GetSnapshot())
+	c.sendJSON(Message{Type: "state_snapshot", Data: data})
 }
 
 func (c *Client) writePump() {
-	ticker := time.NewTicker(30 * time.Second)
+	ticker := time.NewTicker(c.Relay.pingInterval)
 	defer func() {
 		ticker.Stop()
 		c.Conn.Close()
 	}()
+	// Measure the round trip right away instead of after the first tick
+	if err := c.ping(); err != nil {
+		return
+	}
+	// Both queues are closed together; keep draining Send after Control
+	// is closed so pending messages still go out before the close frame
+	control := c.Control
 	for {
+		// Commands always go out before queued bulk updates
 		select {
+		case message, ok := <-control:
+			if !ok {
+				control = nil
+			} else if !c.write(message) {
+				return
+			}
+			continue
+		default:
+		}
+		select {
+		case message, ok := <-control:
+			if !ok {
+				control = nil
+			} else if !c.write(message) {
+				return
+			}
 		case message, ok := <-c.Send:
 			if !ok {
-				c.Conn.WriteMessage(websocket.CloseMessage, []byte{})
+				c.Conn.WriteMessage(websocket.CloseMessage, c.closeMessage())
 				return
 			}
-			w, err := c.Conn.NextWriter(websocket.TextMessage)
-			if err != nil {
+			if !c.write(message) {
 				return
 			}
-			w.Write(message)
-			w.Close()
 		case <-ticker.C:
-			if err := c.Conn.WriteMessage(websocket.PingMessage, nil); err != nil {
+			if err := c.ping(); err != nil {
 				return
 			}
 		}
 	}
 }
 
+// write sends a single message, translating it for native Moblin devices
+func (c *Client) write(message []byte) bool {
+	frames := [][]byte{message}
+	if c.native != nil {
+		frames = c.writeNative(message)
+	}
+	for _, frame := range frames {
+		if err := c.Conn.WriteMessage(websocket.TextMessage, frame); err != nil {
+			return false
+		}
+		c.captureFrame(capture.Out, frame)
+	}
+	atomic.AddUint64(&c.stats.delivered, 1)
+	if len(c.Send) < cap(c.Send)/2 {
+		atomic.StoreInt64(&c.stats.saturatedSince, 0)
+	}
+	return true
+}
+
 func main() {
 	port := flag.Int("port", 8080, "Server port")
 	password := flag.String("password", "", "WebSocket password")
 	dataDir := flag.String("data", "./data", "Data directory")
 	authPIN := flag.String("pin", "", "6-digit PIN")
+	overlayToken := flag.String("overlay-token", "", "Read-only token for overlay WebSocket clients")
+	authTimeout := flag.Duration("auth-timeout", DefaultAuthTimeout, "Disconnect WebSocket clients that don't authenticate within this time")
+	slowClientTimeout := flag.Duration("slow-client-timeout", DefaultSlowClientTimeout, "Disconnect clients whose send queue stays full this long")
+	commandTimeout := flag.Duration("command-timeout", DefaultCommandTimeout, "Timeout for Moblin command acknowledgements")
+	telemetryRetention := flag.Duration("telemetry-retention", stores.DefaultTelemetryRetention, "How long stream telemetry history is kept")
+	shutdownTimeout := flag.Duration("shutdown-timeout", DefaultShutdownTimeout, "Maximum time to drain clients on SIGTERM")
+	capturePath := flag.String("capture", "", "Record all WebSocket frames to this file (JSON lines)")
+	controlIdle := flag.Duration("control-idle", DefaultControlIdle, "Release director control after this long without activity")
+	pingInterval := flag.Duration("ping-interval", DefaultPingInterval, "How often clients are pinged to measure their round trip")
+	samsURL := flag.String("sams-url", services.DefaultSamsURL, "SAMS ticker backend polled for the matchday's match")
+	samsInterval := flag.Duration("sams-interval", services.DefaultSamsInterval, "How often the SAMS ticker is polled")
 	flag.Parse()
 
 	// Initialize Stores
 	scoutStore, _ := stores.NewScoutStore(*dataDir)
 	matchdayStore, _ := stores.NewMatchdayStore(*dataDir)
+	streamStore := stores.NewStreamStore()
+	telemetryStore, err := stores.NewTelemetryStore(*dataDir, *telemetryRetention)
+	if err != nil {
+		log.Printf("[SERVER] Telemetry history disabled: %v", err)
+	}
+	auditStore, err := stores.NewAuditStore(*dataDir)
+	if err != nil {
+		log.Printf("[SERVER] Audit log disabled: %v", err)
+	}
+
+	var recorder *capture.Recorder
+	if *capturePath != "" {
+		if recorder, err = capture.NewRecorder(*capturePath); err != nil {
+			log.Fatalf("[SERVER] Capture: %v", err)
+		}
+		log.Printf("[SERVER] Capturing WebSocket frames to %s", *capturePath)
+	}
 
 	// Initialize Services
-	authService := services.NewAuthService(*dataDir, *authPIN)
+	authService := services.NewAuthService(*dataDir, *authPIN, *overlayToken)
+	var auditor *services.Auditor
+	if auditStore != nil {
+		auditor = services.NewAuditor(auditStore, authService.SessionStore)
+	}
+	alertService := services.NewAlertService(*dataDir)
+	eventBus := events.NewBus()
+	macroService := services.NewMacroService(*dataDir)
+	samsService := services.NewSamsService(*samsURL, *samsInterval, func() string {
+		return matchdayStore.GetState().MatchID
+	})
 
 	// Relay for WebSockets and Broadcaster for handlers
-	relay := NewRelay(*password)
+	relay := NewRelay(RelayConfig{
+		Password:       *password,
+		AuthService:    authService,
+		AuthTimeout:    *authTimeout,
+		CommandTimeout: *commandTimeout,
+
+		SlowClientTimeout: *slowClientTimeout,
+		StreamStore:       streamStore,
+		TelemetryStore:    telemetryStore,
+		AlertService:      alertService,
+		Events:            eventBus,
+		MacroService:      macroService,
+		SamsService:       samsService,
+		Auditor:           auditor,
+		Capture:           recorder,
+		ControlIdle:       *controlIdle,
+		PingInterval:      *pingInterval,
+	})
 	go relay.Run()
+	go samsService.Run()
+
+	// Automation reacts to relay events with scene switches
+	automationService := services.NewAutomationService(*dataDir, relay)
+	eventBus.Subscribe(automationService.HandleEvent)
 
 	// Initialize Handlers
-	authHandler := handlers.NewAuthHandler(authService)
-	scoutHandler := handlers.NewScoutHandler(scoutStore, relay)
-	matchdayHandler := handlers.NewMatchdayHandler(matchdayStore, relay)
+	authHandler := handlers.NewAuthHandler(authService, auditor)
+	scoutHandler := handlers.NewScoutHandler(scoutStore, relay, auditor)
+	matchdayHandler := handlers.NewMatchdayHandler(matchdayStore, relay, relay, auditor)
+	streamHandler := handlers.NewStreamHandler(streamStore)
+	relayHandler := handlers.NewRelayHandler(relay)
+	clientsHandler := handlers.NewClientsHandler(relay, auditor)
+	alertHandler := handlers.NewAlertHandler(alertService)
+	automationHandler := handlers.NewAutomationHandler(automationService, relay)
+	macroHandler := handlers.NewMacroHandler(macroService, relay, auditor)
+	samsHandler := handlers.NewSamsHandler(samsService)
 
 	// Initialize Middleware
 	authMid := middleware.NewAuthMiddleware(authService)
 
 	// Routes
 	http.HandleFunc("/ws", relay.ServeWS)
+	http.HandleFunc("/moblin", relay.ServeMoblin)
 	http.HandleFunc("/health", func(w http.ResponseWriter, r *http.Request) {
 		json.NewEncoder(w).Encode(map[string]string{"status": "ok"})
 	})
@@ -329,6 +891,7 @@ func main() {
 	http.HandleFunc("/api/auth/login", middleware.CorsMiddleware(authHandler.HandleLogin))
 	http.HandleFunc("/api/auth/logout", middleware.CorsMiddleware(authHandler.HandleLogout))
 	http.HandleFunc("/api/auth/session", middleware.CorsMiddleware(authHandler.HandleSession))
+	http.HandleFunc("/api/auth/overlay-token", middleware.CorsMiddleware(authMid.Protect(authHandler.HandleOverlayToken)))
 
 	// Protected Scout API
 	http.HandleFunc("/api/scout", middleware.CorsMiddleware(authMid.Protect(scoutHandler.HandleAPI)))
@@ -338,6 +901,48 @@ func main() {
 	// Protected Matchday API
 	http.HandleFunc("/api/matchday", middleware.CorsMiddleware(authMid.Protect(matchdayHandler.HandleAPI)))
 	http.HandleFunc("/api/matchday/parse", middleware.CorsMiddleware(authMid.Protect(matchdayHandler.HandleParse)))
+	http.HandleFunc("/api/matchday/point", middleware.CorsMiddleware(authMid.Protect(matchdayHandler.HandlePoint)))
+	http.HandleFunc("/api/matchday/undo", middleware.CorsMiddleware(authMid.Protect(matchdayHandler.HandleUndo)))
+	http.HandleFunc("/api/matchday/set", middleware.CorsMiddleware(authMid.Protect(matchdayHandler.HandleSet)))
+	http.HandleFunc("/api/matchday/timeout", middleware.CorsMiddleware(authMid.Protect(matchdayHandler.HandleTimeout)))
+
+	// Protected Stream API
+	http.HandleFunc("/api/stream/state", middleware.CorsMiddleware(authMid.Protect(streamHandler.HandleState)))
+	if telemetryStore != nil {
+		telemetryHandler := handlers.NewTelemetryHandler(telemetryStore)
+		http.HandleFunc("/api/stream/telemetry", middleware.CorsMiddleware(authMid.Protect(telemetryHandler.HandleTelemetry)))
+	}
+
+	// Protected Alerts API
+	http.HandleFunc("/api/alerts", middleware.CorsMiddleware(authMid.Protect(alertHandler.HandleAlerts)))
+	http.HandleFunc("/api/alerts/ack", middleware.CorsMiddleware(authMid.Protect(alertHandler.HandleAck)))
+	http.HandleFunc("/api/alerts/rules", middleware.CorsMiddleware(authMid.Protect(alertHandler.HandleRules)))
+
+	// Protected Automation API
+	http.HandleFunc("/api/automation", middleware.CorsMiddleware(authMid.Protect(automationHandler.HandleConfig)))
+	http.HandleFunc("/api/automation/enabled", middleware.CorsMiddleware(authMid.Protect(automationHandler.HandleEnabled)))
+	http.HandleFunc("/api/automation/log", middleware.CorsMiddleware(authMid.Protect(automationHandler.HandleLog)))
+	http.HandleFunc("/api/automation/event", middleware.CorsMiddleware(authMid.Protect(automationHandler.HandleMatchEvent)))
+
+	// Protected Macros API
+	http.HandleFunc("/api/macros", middleware.CorsMiddleware(authMid.Protect(macroHandler.HandleMacros)))
+	http.HandleFunc("/api/macros/run", middleware.CorsMiddleware(authMid.Protect(macroHandler.HandleRun)))
+	http.HandleFunc("/api/macros/cancel", middleware.CorsMiddleware(authMid.Protect(macroHandler.HandleCancel)))
+	http.HandleFunc("/api/macros/runs", middleware.CorsMiddleware(authMid.Protect(macroHandler.HandleRuns)))
+
+	// Protected SAMS ticker
+	http.HandleFunc("/api/sams", middleware.CorsMiddleware(authMid.Protect(samsHandler.HandleStatus)))
+
+	// Protected Audit log
+	if auditStore != nil {
+		auditHandler := handlers.NewAuditHandler(auditStore)
+		http.HandleFunc("/api/audit", middleware.CorsMiddleware(authMid.Protect(auditHandler.HandleAudit)))
+	}
+
+	// Protected Relay diagnostics
+	http.HandleFunc("/api/relay/metrics", middleware.CorsMiddleware(authMid.Protect(relayHandler.HandleMetrics)))
+	http.HandleFunc("/api/clients", middleware.CorsMiddleware(authMid.Protect(clientsHandler.HandleClients)))
+	http.HandleFunc("/api/clients/", middleware.CorsMiddleware(authMid.Protect(clientsHandler.HandleClient)))
 
 	// Static files with auth
 	webDir := "./web"
@@ -358,8 +963,47 @@ func main() {
 		fileServer.ServeHTTP(w, r)
 	})
 
-	log.Printf("[SERVER] Starting on :%d", *port)
-	if err := http.ListenAndServe(fmt.Sprintf(":%d", *port), nil); err != nil {
-		log.Fatal(err)
+	server := &http.Server{Addr: fmt.Sprintf(":%d", *port)}
+	go func() {
+		log.Printf("[SERVER] Starting on :%d", *port)
+		if err := server.ListenAndServe(); err != nil && err != http.ErrServerClosed {
+			log.Fatal(err)
+		}
+	}()
+
+	// Graceful shutdown for docker compose redeploys
+	stop := make(chan os.Signal, 1)
+	signal.Notify(stop, syscall.SIGINT, syscall.SIGTERM)
+	sig := <-stop
+	log.Printf("[SERVER] Received %s, shutting down", sig)
+
+	ctx, cancel := context.WithTimeout(context.Background(), *shutdownTimeout)
+	defer cancel()
+	if err := server.Shutdown(ctx); err != nil {
+		log.Printf("[SERVER] HTTP shutdown: %v", err)
+	}
+	if err := relay.Shutdown(ctx); err != nil {
+		log.Printf("[SERVER] Relay shutdown: %v", err)
+	}
+
+	// Persist stores
+	if scoutStore != nil {
+		if err := scoutStore.Flush(); err != nil {
+			log.Printf("[SERVER] Failed to save scout state: %v", err)
+		}
+	}
+	if matchdayStore != nil {
+		if err := matchdayStore.Flush(); err != nil {
+			log.Printf("[SERVER] Failed to save matchday state: %v", err)
+		}
+	}
+	authService.SessionStore.Flush()
+	if telemetryStore != nil {
+		telemetryStore.Close()
+	}
+	if auditStore != nil {
+		auditStore.Close()
 	}
+	recorder.Close()
+	log.Printf("[SERVER] Stopped")
 }
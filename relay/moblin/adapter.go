@@ -0,0 +1,392 @@
+package moblin
+
+import (
+	"crypto/hmac"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math"
+	"regexp"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/volleybratans/moblin-relay/protocol"
+)
+
+// pendingTimeout is how long a request waits for Moblin's response before it
+// is forgotten. The relay has answered the command with command_timeout by then.
+const pendingTimeout = time.Minute
+
+// ErrNotIdentified is returned for messages sent before a successful identify
+var ErrNotIdentified = errors.New("streamer not identified")
+
+// Inbound is the translation of a single frame received from Moblin
+type Inbound struct {
+	Identified bool     // the streamer just authenticated successfully
+	Rejected   bool     // the streamer sent a wrong password
+	Ready      bool     // settings are loaded, commands can be translated
+	StreamerID string   // Moblin's identify ID
+	Replies    [][]byte // native frames to send back to Moblin
+	Messages   [][]byte // relay-dialect messages from the device
+}
+
+// pendingRequest links a native request ID to the relay command it carries.
+// Internal requests (status polling, settings) have no relay request ID.
+type pendingRequest struct {
+	RequestID string
+	Command   string
+	Internal  string
+	sent      time.Time
+}
+
+// Adapter translates between Moblin's native remote control protocol and
+// the relay's browser dialect for one connection
+type Adapter struct {
+	password   string
+	challenge  string
+	salt       string
+	identified bool
+	nextID     int
+	pending    map[int]pendingRequest
+	settings   Settings
+	state      State
+	now        func() time.Time // clock for pendingTimeout, replaced in tests
+	mu         sync.Mutex
+}
+
+// NewAdapter creates an adapter for a streamer connection. An empty
+// password accepts any identify.
+func NewAdapter(password string) *Adapter {
+	return &Adapter{
+		password:  password,
+		challenge: protocol.NewNonce(),
+		salt:      protocol.NewNonce(),
+		pending:   make(map[int]pendingRequest),
+		now:       time.Now,
+	}
+}
+
+// Hello returns the greeting that starts the identify handshake
+func (a *Adapter) Hello() []byte {
+	return encode(MessageToStreamer{Hello: &Hello{
+		APIVersion:     APIVersion,
+		Authentication: Authentication{Challenge: a.challenge, Salt: a.salt},
+	}})
+}
+
+// PollStatus returns a getStatus request, or nil before identify
+func (a *Adapter) PollStatus() []byte {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	if !a.identified {
+		return nil
+	}
+	return a.request(RequestData{GetStatus: &Empty{}}, pendingRequest{Internal: "getStatus"})
+}
+
+// request encodes a native request and remembers what it was for, dropping
+// requests that went unanswered for pendingTimeout. Caller must hold a.mu.
+func (a *Adapter) request(data RequestData, p pendingRequest) []byte {
+	now := a.now()
+	for id, pending := range a.pending {
+		if now.Sub(pending.sent) > pendingTimeout {
+			delete(a.pending, id)
+		}
+	}
+	a.nextID++
+	p.sent = now
+	a.pending[a.nextID] = p
+	return encode(MessageToStreamer{Request: &Request{ID: a.nextID, Data: data}})
+}
+
+// Inbound translates a frame received from Moblin
+func (a *Adapter) Inbound(raw []byte) (Inbound, error) {
+	var msg MessageToAssistant
+	if err := json.Unmarshal(raw, &msg); err != nil {
+		return Inbound{}, err
+	}
+
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	var in Inbound
+	switch {
+	case msg.Identify != nil:
+		a.identify(msg.Identify, &in)
+	case !a.identified:
+		return in, ErrNotIdentified
+	case msg.Ping != nil:
+		in.Replies = append(in.Replies, encode(MessageToStreamer{Pong: &Empty{}}))
+	case msg.Response != nil:
+		a.response(msg.Response, &in)
+	case msg.Event != nil && msg.Event.Data.State != nil:
+		a.stateChanged(msg.Event.Data.State.Data, &in)
+	}
+	return in, nil
+}
+
+func (a *Adapter) identify(id *Identify, in *Inbound) {
+	if a.identified {
+		in.Replies = append(in.Replies, encode(MessageToStreamer{Identified: &Identified{Result: Result{AlreadyIdentified: &Empty{}}}}))
+		return
+	}
+	expected := HashPassword(a.challenge, a.salt, a.password)
+	if a.password != "" && !hmac.Equal([]byte(expected), []byte(id.Authentication)) {
+		in.Rejected = true
+		in.Replies = append(in.Replies, encode(MessageToStreamer{Identified: &Identified{Result: ResultWrongPassword}}))
+		return
+	}
+	a.identified = true
+	in.Identified = true
+	in.StreamerID = id.ID
+	in.Replies = append(in.Replies,
+		encode(MessageToStreamer{Identified: &Identified{Result: ResultOk}}),
+		a.request(RequestData{GetSettings: &Empty{}}, pendingRequest{Internal: "getSettings"}),
+		a.request(RequestData{GetStatus: &Empty{}}, pendingRequest{Internal: "getStatus"}),
+	)
+}
+
+func (a *Adapter) response(resp *Response, in *Inbound) {
+	p, ok := a.pending[resp.ID]
+	if !ok {
+		return
+	}
+	delete(a.pending, resp.ID)
+
+	switch p.Internal {
+	case "getSettings":
+		if resp.Data != nil && resp.Data.GetSettings != nil {
+			a.settings = resp.Data.GetSettings.Data
+		}
+		in.Ready = true
+	case "getStatus":
+		if resp.Data != nil && resp.Data.GetStatus != nil {
+			in.Messages = append(in.Messages, statusToStreamInfo(resp.Data.GetStatus))
+		}
+	default:
+		if resp.Result.IsOk() {
+			in.Messages = append(in.Messages, commandReply(protocol.TypeCommandAck, p.RequestID, ""))
+		} else {
+			in.Messages = append(in.Messages, commandReply(protocol.TypeCommandFailed, p.RequestID, "Moblin: "+resp.Result.String()))
+		}
+	}
+}
+
+// stateChanged merges a state event and emits browser events for changed fields
+func (a *Adapter) stateChanged(s State, in *Inbound) {
+	if s.Scene != nil && !equalString(a.state.Scene, s.Scene) {
+		in.Messages = append(in.Messages, event(protocol.SceneChanged{Scene: a.sceneName(*s.Scene)}))
+		a.state.Scene = s.Scene
+	}
+	if s.Streaming != nil && !equalBool(a.state.Streaming, s.Streaming) {
+		if *s.Streaming {
+			in.Messages = append(in.Messages, event(protocol.StreamStarted{}))
+		} else {
+			in.Messages = append(in.Messages, event(protocol.StreamEnded{}))
+		}
+		a.state.Streaming = s.Streaming
+	}
+	if s.Recording != nil && !equalBool(a.state.Recording, s.Recording) {
+		in.Messages = append(in.Messages, event(protocol.RecordingState{Recording: *s.Recording}))
+		a.state.Recording = s.Recording
+	}
+	if s.Muted != nil && !equalBool(a.state.Muted, s.Muted) {
+		in.Messages = append(in.Messages, event(protocol.MicState{Muted: *s.Muted}))
+		a.state.Muted = s.Muted
+	}
+	if s.TorchOn != nil && !equalBool(a.state.TorchOn, s.TorchOn) {
+		in.Messages = append(in.Messages, event(protocol.TorchState{Enabled: *s.TorchOn}))
+		a.state.TorchOn = s.TorchOn
+	}
+	if s.Zoom != nil {
+		a.state.Zoom = s.Zoom
+	}
+	if s.Bitrate != nil {
+		a.state.Bitrate = s.Bitrate
+	}
+}
+
+// Outbound translates a relay-dialect frame for Moblin. Frames without a
+// "type" are already native and pass through unchanged. Commands that
+// cannot be expressed natively are answered with a command_failed message.
+func (a *Adapter) Outbound(raw []byte) (frames [][]byte, messages [][]byte) {
+	var probe map[string]json.RawMessage
+	if err := json.Unmarshal(raw, &probe); err != nil {
+		return nil, nil
+	}
+	if _, ok := probe["type"]; !ok {
+		return [][]byte{raw}, nil
+	}
+
+	env, err := protocol.ParseEnvelope(raw)
+	if err != nil || !protocol.IsCommand(env.Type) {
+		// Relay-level messages (hello, auth_success, ...) have no native equivalent
+		return nil, nil
+	}
+	cmd, err := protocol.ParseCommand(raw)
+	if err != nil {
+		return nil, [][]byte{commandReply(protocol.TypeCommandFailed, env.RequestID, err.Error())}
+	}
+
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	data, err := a.translate(cmd)
+	if err != nil {
+		return nil, [][]byte{commandReply(protocol.TypeCommandFailed, env.RequestID, err.Error())}
+	}
+	return [][]byte{a.request(data, pendingRequest{RequestID: env.RequestID, Command: env.Type})}, nil
+}
+
+// translate maps a relay command to a native request. Caller must hold a.mu.
+func (a *Adapter) translate(cmd protocol.Command) (RequestData, error) {
+	switch c := cmd.(type) {
+	case *protocol.SetScene:
+		for _, scene := range a.settings.Scenes {
+			if normalizeSceneName(scene.Name) == normalizeSceneName(c.Name) {
+				return RequestData{SetScene: &ID{ID: scene.ID}}, nil
+			}
+		}
+		return RequestData{}, fmt.Errorf("Scene %q not configured in Moblin", c.Name)
+	case *protocol.SetBitrate:
+		preset, ok := a.nearestPreset(c.Kbps)
+		if !ok {
+			return RequestData{}, errors.New("No bitrate presets configured in Moblin")
+		}
+		return RequestData{SetBitratePreset: &ID{ID: preset.ID}}, nil
+	case *protocol.SetZoom:
+		return RequestData{SetZoom: &Zoom{X: c.Level}}, nil
+	case *protocol.GoLive:
+		return RequestData{SetStream: &OnOff{On: true}}, nil
+	case *protocol.End:
+		return RequestData{SetStream: &OnOff{On: false}}, nil
+	case *protocol.ToggleMic:
+		return RequestData{SetMute: &OnOff{On: !valueOf(a.state.Muted)}}, nil
+	case *protocol.ToggleTorch:
+		return RequestData{SetTorch: &OnOff{On: !valueOf(a.state.TorchOn)}}, nil
+	case *protocol.ToggleRecording:
+		return RequestData{SetRecord: &OnOff{On: !valueOf(a.state.Recording)}}, nil
+	}
+	return RequestData{}, fmt.Errorf("%s is not supported by Moblin remote control", cmd.CommandType())
+}
+
+// nearestPreset picks the bitrate preset closest to kbps. Caller must hold a.mu.
+func (a *Adapter) nearestPreset(kbps int) (SettingsBitratePreset, bool) {
+	var best SettingsBitratePreset
+	bestDiff := math.MaxInt
+	for _, preset := range a.settings.BitratePresets {
+		diff := preset.Bitrate/1000 - kbps
+		if diff < 0 {
+			diff = -diff
+		}
+		if diff < bestDiff {
+			best, bestDiff = preset, diff
+		}
+	}
+	return best, bestDiff != math.MaxInt
+}
+
+// sceneName resolves a scene UUID to its name. Caller must hold a.mu.
+func (a *Adapter) sceneName(id string) string {
+	for _, scene := range a.settings.Scenes {
+		if scene.ID == id {
+			return scene.Name
+		}
+	}
+	return id
+}
+
+var bitratePattern = regexp.MustCompile(`([\d.,]+)\s*([kKmM])bps`)
+var digitsPattern = regexp.MustCompile(`\d+`)
+
+// statusToStreamInfo converts Moblin's status bar into a stream_info event
+func statusToStreamInfo(status *Status) []byte {
+	var info protocol.StreamInfo
+	if g := status.General; g != nil {
+		if g.BatteryLevel != nil {
+			battery := *g.BatteryLevel
+			info.Battery = &battery
+		}
+		if thermal, ok := flameToThermalState(g.Flame); ok {
+			info.ThermalState = &thermal
+		}
+	}
+	if item := status.TopRight.Bitrate; item != nil {
+		if m := bitratePattern.FindStringSubmatch(item.Message); m != nil {
+			value, err := strconv.ParseFloat(strings.ReplaceAll(m[1], ",", ""), 64)
+			if err == nil {
+				if strings.EqualFold(m[2], "m") {
+					value *= 1000
+				}
+				kbps := int(value)
+				info.Bitrate = &kbps
+			}
+		}
+	}
+	if item := status.TopLeft.Viewers; item != nil {
+		if m := digitsPattern.FindString(strings.ReplaceAll(item.Message, ",", "")); m != "" {
+			viewers, _ := strconv.Atoi(m)
+			info.Viewers = &viewers
+		}
+	}
+	return event(info)
+}
+
+// flameToThermalState maps Moblin's flame indicator (white/yellow/red) to
+// iOS thermal states. Accepts both "red" and {"red":{}} encodings.
+func flameToThermalState(raw json.RawMessage) (string, bool) {
+	if len(raw) == 0 {
+		return "", false
+	}
+	var name string
+	if err := json.Unmarshal(raw, &name); err != nil {
+		var obj map[string]json.RawMessage
+		if err := json.Unmarshal(raw, &obj); err != nil {
+			return "", false
+		}
+		for key := range obj {
+			name = key
+		}
+	}
+	switch name {
+	case "white":
+		return "fair", true
+	case "yellow":
+		return "serious", true
+	case "red":
+		return "critical", true
+	}
+	return "", false
+}
+
+func normalizeSceneName(name string) string {
+	return strings.NewReplacer(" ", "_", "-", "_").Replace(strings.ToLower(strings.TrimSpace(name)))
+}
+
+func commandReply(replyType, requestID, message string) []byte {
+	data, _ := protocol.Encode(replyType, protocol.CommandReply{RequestID: requestID, Message: message}, nil)
+	return data
+}
+
+func event(ev protocol.Event) []byte {
+	data, _ := protocol.Encode(ev.EventType(), ev, nil)
+	return data
+}
+
+func encode(v interface{}) []byte {
+	data, _ := json.Marshal(v)
+	return data
+}
+
+func valueOf(b *bool) bool {
+	return b != nil && *b
+}
+
+func equalBool(a, b *bool) bool {
+	return a != nil && b != nil && *a == *b
+}
+
+func equalString(a, b *string) bool {
+	return a != nil && b != nil && *a == *b
+}
@@ -0,0 +1,307 @@
+package moblin
+
+import (
+	"encoding/json"
+	"errors"
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestHashPassword(t *testing.T) {
+	tests := []struct {
+		challenge, salt, password string
+		want                      string
+	}{
+		{"challenge", "salt", "password", "zTM5ki6L2vVvBQiTG9ckH1Lh64AbnCf6XZ226UmnkIA="},
+		{"3a7f9c21", "b5e2d0f4", "volleybratans", "49OlHsg/taBXmyaBy0vy7RXw4aBKLWWfytiP0fO/Xv8="},
+		{"", "", "", "XEB0z23rR/W2r5xf4+C70OQrlZb+iKxU1ca275h+DyA="},
+	}
+	for _, tt := range tests {
+		if got := HashPassword(tt.challenge, tt.salt, tt.password); got != tt.want {
+			t.Errorf("HashPassword(%q, %q, %q) = %s, want %s", tt.challenge, tt.salt, tt.password, got, tt.want)
+		}
+	}
+}
+
+// identify answers the adapter's hello like Moblin with password
+func identify(t *testing.T, a *Adapter, password string) Inbound {
+	t.Helper()
+	var hello MessageToStreamer
+	if err := json.Unmarshal(a.Hello(), &hello); err != nil || hello.Hello == nil {
+		t.Fatalf("invalid hello: %v", err)
+	}
+	auth := hello.Hello.Authentication
+	in, err := a.Inbound(encode(MessageToAssistant{Identify: &Identify{
+		ID:             "streamer-1",
+		Authentication: HashPassword(auth.Challenge, auth.Salt, password),
+	}}))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return in
+}
+
+func identifiedResult(t *testing.T, frame []byte) Result {
+	t.Helper()
+	var msg MessageToStreamer
+	if err := json.Unmarshal(frame, &msg); err != nil || msg.Identified == nil {
+		t.Fatalf("not an identified message: %s", frame)
+	}
+	return msg.Identified.Result
+}
+
+func TestIdentify(t *testing.T) {
+	tests := []struct {
+		name     string
+		password string
+		sent     string
+		want     Result
+	}{
+		{"correct password", "secret", "secret", ResultOk},
+		{"wrong password", "secret", "guess", ResultWrongPassword},
+		{"no password configured", "", "anything", ResultOk},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			a := NewAdapter(tt.password)
+			in := identify(t, a, tt.sent)
+			ok := tt.want.IsOk()
+			if in.Identified != ok || in.Rejected == ok {
+				t.Errorf("identified %v, rejected %v", in.Identified, in.Rejected)
+			}
+			if len(in.Replies) == 0 {
+				t.Fatal("no reply to identify")
+			}
+			if got := identifiedResult(t, in.Replies[0]); got.String() != tt.want.String() {
+				t.Errorf("result %s, want %s", got, tt.want)
+			}
+			if ok && in.StreamerID != "streamer-1" {
+				t.Errorf("streamer ID %q", in.StreamerID)
+			}
+			// Settings and status are requested right after identify
+			if wantReplies := map[bool]int{true: 3, false: 1}[ok]; len(in.Replies) != wantReplies {
+				t.Errorf("%d replies, want %d", len(in.Replies), wantReplies)
+			}
+			if got := a.PollStatus() != nil; got != ok {
+				t.Errorf("status polled %v after identify", got)
+			}
+		})
+	}
+}
+
+func TestIdentifyOnce(t *testing.T) {
+	a := NewAdapter("secret")
+	identify(t, a, "secret")
+	in := identify(t, a, "secret")
+	if in.Identified {
+		t.Error("second identify reported as a new identification")
+	}
+	if got := identifiedResult(t, in.Replies[0]); got.String() != "alreadyIdentified" {
+		t.Errorf("result %s, want alreadyIdentified", got)
+	}
+}
+
+func TestInboundBeforeIdentify(t *testing.T) {
+	a := NewAdapter("secret")
+	_, err := a.Inbound(encode(MessageToAssistant{Ping: &Empty{}}))
+	if !errors.Is(err, ErrNotIdentified) {
+		t.Errorf("error %v, want ErrNotIdentified", err)
+	}
+}
+
+// readyAdapter returns an identified adapter with loaded settings
+func readyAdapter(t *testing.T) *Adapter {
+	t.Helper()
+	a := NewAdapter("")
+	identify(t, a, "")
+	in, err := a.Inbound(encode(MessageToAssistant{Response: &Response{ID: 1, Result: ResultOk, Data: &ResponseData{
+		GetSettings: &SettingsData{Data: Settings{
+			Scenes: []SettingsScene{
+				{ID: "scene-main", Name: "Main"},
+				{ID: "scene-court", Name: "Court overview"},
+			},
+			BitratePresets: []SettingsBitratePreset{
+				{ID: "preset-3000", Bitrate: 3000000},
+				{ID: "preset-6000", Bitrate: 6000000},
+			},
+		}},
+	}}}))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !in.Ready {
+		t.Fatal("adapter not ready after settings")
+	}
+	return a
+}
+
+// request decodes a native request frame
+func request(t *testing.T, frame []byte) Request {
+	t.Helper()
+	var msg MessageToStreamer
+	if err := json.Unmarshal(frame, &msg); err != nil || msg.Request == nil {
+		t.Fatalf("not a request: %s", frame)
+	}
+	return *msg.Request
+}
+
+// fields decodes a relay-dialect message
+func fields(t *testing.T, message []byte) map[string]any {
+	t.Helper()
+	var m map[string]any
+	if err := json.Unmarshal(message, &m); err != nil {
+		t.Fatal(err)
+	}
+	return m
+}
+
+func TestOutbound(t *testing.T) {
+	tests := []struct {
+		name    string
+		command string
+		want    RequestData // zero: command_failed expected
+	}{
+		{"scene by normalized name", `{"type":"set_scene","name":"court_overview","request_id":"req-1"}`, RequestData{SetScene: &ID{ID: "scene-court"}}},
+		{"nearest bitrate preset", `{"type":"set_bitrate","kbps":5000,"request_id":"req-1"}`, RequestData{SetBitratePreset: &ID{ID: "preset-6000"}}},
+		{"zoom", `{"type":"set_zoom","level":2.5,"request_id":"req-1"}`, RequestData{SetZoom: &Zoom{X: 2.5}}},
+		{"go live", `{"type":"go_live","request_id":"req-1"}`, RequestData{SetStream: &OnOff{On: true}}},
+		{"end", `{"type":"end","request_id":"req-1"}`, RequestData{SetStream: &OnOff{On: false}}},
+		{"toggle mic", `{"type":"toggle_mic","request_id":"req-1"}`, RequestData{SetMute: &OnOff{On: true}}},
+		{"toggle torch", `{"type":"toggle_torch","request_id":"req-1"}`, RequestData{SetTorch: &OnOff{On: true}}},
+		{"toggle recording", `{"type":"toggle_recording","request_id":"req-1"}`, RequestData{SetRecord: &OnOff{On: true}}},
+		{"unknown scene", `{"type":"set_scene","name":"interview","request_id":"req-1"}`, RequestData{}},
+		{"unsupported command", `{"type":"snapshot","request_id":"req-1"}`, RequestData{}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			a := readyAdapter(t)
+			frames, messages := a.Outbound([]byte(tt.command))
+			if reflect.DeepEqual(tt.want, RequestData{}) {
+				if len(frames) != 0 || len(messages) != 1 {
+					t.Fatalf("%d frames and %d messages, want a single reply", len(frames), len(messages))
+				}
+				reply := fields(t, messages[0])
+				if reply["type"] != "command_failed" || reply["request_id"] != "req-1" {
+					t.Errorf("reply %s, want command_failed for req-1", messages[0])
+				}
+				return
+			}
+			if len(frames) != 1 || len(messages) != 0 {
+				t.Fatalf("%d frames and %d messages, want one request", len(frames), len(messages))
+			}
+			if got := request(t, frames[0]).Data; !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("request %s, want %s", encode(got), encode(tt.want))
+			}
+		})
+	}
+}
+
+func TestOutboundPassthrough(t *testing.T) {
+	a := readyAdapter(t)
+	native := []byte(`{"pong":{}}`)
+	if frames, _ := a.Outbound(native); len(frames) != 1 || string(frames[0]) != string(native) {
+		t.Errorf("native frame translated to %q", frames)
+	}
+	if frames, messages := a.Outbound([]byte(`{"type":"hello","protocol_version":2}`)); frames != nil || messages != nil {
+		t.Errorf("relay message translated to %q and %q", frames, messages)
+	}
+}
+
+func TestCommandResponse(t *testing.T) {
+	tests := []struct {
+		name   string
+		result Result
+		want   string
+	}{
+		{"ok", ResultOk, "command_ack"},
+		{"unknown request", ResultUnknown, "command_failed"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			a := readyAdapter(t)
+			frames, _ := a.Outbound([]byte(`{"type":"go_live","request_id":"req-7"}`))
+			id := request(t, frames[0]).ID
+			in, err := a.Inbound(encode(MessageToAssistant{Response: &Response{ID: id, Result: tt.result}}))
+			if err != nil {
+				t.Fatal(err)
+			}
+			if len(in.Messages) != 1 {
+				t.Fatalf("%d messages, want 1", len(in.Messages))
+			}
+			reply := fields(t, in.Messages[0])
+			if reply["type"] != tt.want || reply["request_id"] != "req-7" {
+				t.Errorf("reply %s, want %s for req-7", in.Messages[0], tt.want)
+			}
+
+			// A response is only routed once
+			if in, _ = a.Inbound(encode(MessageToAssistant{Response: &Response{ID: id, Result: tt.result}})); len(in.Messages) != 0 {
+				t.Errorf("repeated response routed: %s", in.Messages)
+			}
+		})
+	}
+}
+
+func TestStateEvents(t *testing.T) {
+	on, scene := true, "scene-court"
+	a := readyAdapter(t)
+	state := State{Scene: &scene, Streaming: &on, Muted: &on}
+	in, err := a.Inbound(encode(MessageToAssistant{Event: &Event{Data: EventData{State: &StateEvent{Data: state}}}}))
+	if err != nil {
+		t.Fatal(err)
+	}
+	var types []string
+	for _, message := range in.Messages {
+		types = append(types, fields(t, message)["type"].(string))
+	}
+	if want := []string{"scene_changed", "stream_started", "mic_state"}; !reflect.DeepEqual(types, want) {
+		t.Errorf("events %v, want %v", types, want)
+	}
+	if got := fields(t, in.Messages[0]); got["scene"] != "Court overview" {
+		t.Errorf("scene_changed %v, want the scene name", got)
+	}
+
+	// Unchanged state is not reported again
+	in, _ = a.Inbound(encode(MessageToAssistant{Event: &Event{Data: EventData{State: &StateEvent{Data: state}}}}))
+	if len(in.Messages) != 0 {
+		t.Errorf("unchanged state reported: %s", in.Messages)
+	}
+}
+
+func TestStatusToStreamInfo(t *testing.T) {
+	battery := 42
+	status := &Status{
+		General:  &StatusGeneral{BatteryLevel: &battery, Flame: json.RawMessage(`{"red":{}}`)},
+		TopLeft:  StatusTopLeft{Viewers: &StatusItem{Message: "1,234 viewers"}},
+		TopRight: StatusTopRight{Bitrate: &StatusItem{Message: "5.5 Mbps"}},
+	}
+	got := fields(t, statusToStreamInfo(status))
+	want := map[string]any{"type": "stream_info", "battery": 42.0, "thermal_state": "critical", "viewers": 1234.0, "bitrate": 5500.0}
+	for key, value := range want {
+		if got[key] != value {
+			t.Errorf("%s = %v, want %v", key, got[key], value)
+		}
+	}
+}
+
+func TestPendingTimeout(t *testing.T) {
+	a := readyAdapter(t)
+	now := time.Now()
+	a.now = func() time.Time { return now }
+
+	frames, _ := a.Outbound([]byte(`{"type":"go_live","request_id":"req-7"}`))
+	unanswered := request(t, frames[0]).ID
+	now = now.Add(pendingTimeout + time.Second)
+	frames, _ = a.Outbound([]byte(`{"type":"end","request_id":"req-8"}`))
+	answered := request(t, frames[0]).ID
+
+	if _, ok := a.pending[unanswered]; ok {
+		t.Errorf("request %d still pending after %s", unanswered, pendingTimeout)
+	}
+	if in, _ := a.Inbound(encode(MessageToAssistant{Response: &Response{ID: unanswered, Result: ResultOk}})); len(in.Messages) != 0 {
+		t.Errorf("late response routed: %s", in.Messages)
+	}
+	if in, _ := a.Inbound(encode(MessageToAssistant{Response: &Response{ID: answered, Result: ResultOk}})); len(in.Messages) != 1 {
+		t.Errorf("%d messages for a timely response, want 1", len(in.Messages))
+	}
+}
@@ -0,0 +1,230 @@
+// Package moblintest provides a fake Moblin streamer speaking the native
+// remote control protocol, for exercising the relay without a phone.
+package moblintest
+
+import (
+	"encoding/json"
+	"errors"
+	"sync"
+	"time"
+
+	"github.com/gorilla/websocket"
+	"github.com/volleybratans/moblin-relay/moblin"
+)
+
+// Streamer is a fake Moblin app. It identifies with Password, answers
+// requests like the real app and pushes state events on every change.
+type Streamer struct {
+	ID             string
+	Password       string
+	Scenes         []moblin.SettingsScene
+	BitratePresets []moblin.SettingsBitratePreset
+	Status         moblin.Status
+	// FailRequests makes every set* request fail with unknownRequest
+	FailRequests bool
+	// SettingsDelay holds back the getSettings response, like a slow phone
+	SettingsDelay time.Duration
+
+	conn       *websocket.Conn
+	state      moblin.State
+	requests   []moblin.RequestData
+	identified chan moblin.Result
+	writeMu    sync.Mutex
+	mu         sync.Mutex
+}
+
+// NewStreamer creates a streamer with typical VolleyBratans scenes and presets
+func NewStreamer(password string) *Streamer {
+	battery := 80
+	return &Streamer{
+		ID:       "4c1f4a52-6d1a-4b6e-9c55-2f0c6c0b7a01",
+		Password: password,
+		Scenes: []moblin.SettingsScene{
+			{ID: "a1b1c1d1-0000-0000-0000-000000000001", Name: "Main"},
+			{ID: "a1b1c1d1-0000-0000-0000-000000000002", Name: "Wide"},
+			{ID: "a1b1c1d1-0000-0000-0000-000000000003", Name: "Court overview"},
+			{ID: "a1b1c1d1-0000-0000-0000-000000000004", Name: "Scoreboard"},
+			{ID: "a1b1c1d1-0000-0000-0000-000000000005", Name: "BRB"},
+		},
+		BitratePresets: []moblin.SettingsBitratePreset{
+			{ID: "b2b2b2b2-0000-0000-0000-000000003000", Bitrate: 3000000},
+			{ID: "b2b2b2b2-0000-0000-0000-000000006000", Bitrate: 6000000},
+			{ID: "b2b2b2b2-0000-0000-0000-000000010000", Bitrate: 10000000},
+		},
+		Status: moblin.Status{
+			General:  &moblin.StatusGeneral{BatteryLevel: &battery, Flame: json.RawMessage(`"white"`)},
+			TopLeft:  moblin.StatusTopLeft{Viewers: &moblin.StatusItem{Message: "0", Ok: true}},
+			TopRight: moblin.StatusTopRight{Bitrate: &moblin.StatusItem{Message: "0 kbps", Ok: true}},
+		},
+		identified: make(chan moblin.Result, 1),
+	}
+}
+
+// Connect dials the relay's assistant endpoint and starts answering messages
+func (s *Streamer) Connect(url string) error {
+	conn, _, err := websocket.DefaultDialer.Dial(url, nil)
+	if err != nil {
+		return err
+	}
+	s.conn = conn
+	go s.readLoop()
+	return nil
+}
+
+// WaitIdentified blocks until the relay answered identify
+func (s *Streamer) WaitIdentified(timeout time.Duration) (moblin.Result, error) {
+	select {
+	case result := <-s.identified:
+		return result, nil
+	case <-time.After(timeout):
+		return moblin.Result{}, errors.New("moblintest: not identified in time")
+	}
+}
+
+// Requests returns all requests received so far
+func (s *Streamer) Requests() []moblin.RequestData {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return append([]moblin.RequestData(nil), s.requests...)
+}
+
+// State returns the current streamer state
+func (s *Streamer) State() moblin.State {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.state
+}
+
+// PushState changes the state as if the operator touched the phone
+func (s *Streamer) PushState(state moblin.State) error {
+	s.mu.Lock()
+	mergeState(&s.state, state)
+	s.mu.Unlock()
+	return s.send(moblin.MessageToAssistant{Event: &moblin.Event{Data: moblin.EventData{State: &moblin.StateEvent{Data: state}}}})
+}
+
+// Ping sends a keep-alive like the real app
+func (s *Streamer) Ping() error {
+	return s.send(moblin.MessageToAssistant{Ping: &moblin.Empty{}})
+}
+
+// Close disconnects from the relay
+func (s *Streamer) Close() error {
+	if s.conn == nil {
+		return nil
+	}
+	return s.conn.Close()
+}
+
+func (s *Streamer) readLoop() {
+	for {
+		_, raw, err := s.conn.ReadMessage()
+		if err != nil {
+			return
+		}
+		var msg moblin.MessageToStreamer
+		if err := json.Unmarshal(raw, &msg); err != nil {
+			continue
+		}
+		switch {
+		case msg.Hello != nil:
+			auth := msg.Hello.Authentication
+			s.send(moblin.MessageToAssistant{Identify: &moblin.Identify{
+				ID:             s.ID,
+				Authentication: moblin.HashPassword(auth.Challenge, auth.Salt, s.Password),
+			}})
+		case msg.Identified != nil:
+			select {
+			case s.identified <- msg.Identified.Result:
+			default:
+			}
+		case msg.Request != nil:
+			s.handleRequest(msg.Request)
+		}
+	}
+}
+
+func (s *Streamer) handleRequest(req *moblin.Request) {
+	s.mu.Lock()
+	s.requests = append(s.requests, req.Data)
+	resp := moblin.Response{ID: req.ID, Result: moblin.ResultOk}
+	var change moblin.State
+	d := req.Data
+	switch {
+	case d.GetStatus != nil:
+		status := s.Status
+		resp.Data = &moblin.ResponseData{GetStatus: &status}
+	case d.GetSettings != nil:
+		resp.Data = &moblin.ResponseData{GetSettings: &moblin.SettingsData{Data: moblin.Settings{
+			Scenes:         s.Scenes,
+			BitratePresets: s.BitratePresets,
+		}}}
+	case s.FailRequests:
+		resp.Result = moblin.ResultUnknown
+	case d.SetScene != nil:
+		change.Scene = &d.SetScene.ID
+	case d.SetBitratePreset != nil:
+		change.Bitrate = &d.SetBitratePreset.ID
+	case d.SetZoom != nil:
+		change.Zoom = &d.SetZoom.X
+	case d.SetStream != nil:
+		change.Streaming = &d.SetStream.On
+	case d.SetRecord != nil:
+		change.Recording = &d.SetRecord.On
+	case d.SetMute != nil:
+		change.Muted = &d.SetMute.On
+	case d.SetTorch != nil:
+		change.TorchOn = &d.SetTorch.On
+	default:
+		resp.Result = moblin.ResultUnknown
+	}
+	mergeState(&s.state, change)
+	delay := s.SettingsDelay
+	s.mu.Unlock()
+
+	if d.GetSettings != nil && delay > 0 {
+		time.AfterFunc(delay, func() { s.send(moblin.MessageToAssistant{Response: &resp}) })
+		return
+	}
+	s.send(moblin.MessageToAssistant{Response: &resp})
+	if change != (moblin.State{}) {
+		s.send(moblin.MessageToAssistant{Event: &moblin.Event{Data: moblin.EventData{State: &moblin.StateEvent{Data: change}}}})
+	}
+}
+
+func (s *Streamer) send(msg moblin.MessageToAssistant) error {
+	data, err := json.Marshal(msg)
+	if err != nil {
+		return err
+	}
+	s.writeMu.Lock()
+	defer s.writeMu.Unlock()
+	return s.conn.WriteMessage(websocket.TextMessage, data)
+}
+
+func mergeState(dst *moblin.State, src moblin.State) {
+	if src.Scene != nil {
+		dst.Scene = src.Scene
+	}
+	if src.Mic != nil {
+		dst.Mic = src.Mic
+	}
+	if src.Bitrate != nil {
+		dst.Bitrate = src.Bitrate
+	}
+	if src.Zoom != nil {
+		dst.Zoom = src.Zoom
+	}
+	if src.Streaming != nil {
+		dst.Streaming = src.Streaming
+	}
+	if src.Recording != nil {
+		dst.Recording = src.Recording
+	}
+	if src.Muted != nil {
+		dst.Muted = src.Muted
+	}
+	if src.TorchOn != nil {
+		dst.TorchOn = src.TorchOn
+	}
+}
@@ -0,0 +1,234 @@
+/**
+ * Moblin Remote Control Protocol - Native message types
+ * The relay acts as a remote control "assistant": Moblin (the streamer)
+ * connects, answers the hello challenge with identify and then receives
+ * requests and sends responses and events.
+ *
+ * Messages use Swift's Codable encoding of enums with associated values:
+ * every message is an object with a single key naming the case,
+ * e.g. {"request":{"id":1,"data":{"setZoom":{"x":2.0}}}}.
+ */
+
+package moblin
+
+import (
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+)
+
+// APIVersion is the remote control API version announced in hello
+const APIVersion = "0.1"
+
+// Empty encodes Swift enum cases without associated values ({})
+type Empty struct{}
+
+// MessageToStreamer is sent by the assistant (relay) to Moblin
+type MessageToStreamer struct {
+	Hello      *Hello      `json:"hello,omitempty"`
+	Identified *Identified `json:"identified,omitempty"`
+	Request    *Request    `json:"request,omitempty"`
+	Pong       *Empty      `json:"pong,omitempty"`
+}
+
+// MessageToAssistant is sent by Moblin to the assistant (relay)
+type MessageToAssistant struct {
+	Identify *Identify `json:"identify,omitempty"`
+	Response *Response `json:"response,omitempty"`
+	Event    *Event    `json:"event,omitempty"`
+	Ping     *Empty    `json:"ping,omitempty"`
+}
+
+type Hello struct {
+	APIVersion     string         `json:"apiVersion"`
+	Authentication Authentication `json:"authentication"`
+}
+
+type Authentication struct {
+	Challenge string `json:"challenge"`
+	Salt      string `json:"salt"`
+}
+
+type Identify struct {
+	ID             string `json:"id"`
+	Authentication string `json:"authentication"`
+}
+
+type Identified struct {
+	Result Result `json:"result"`
+}
+
+// Result is the outcome of identify and requests
+type Result struct {
+	Ok                *Empty `json:"ok,omitempty"`
+	WrongPassword     *Empty `json:"wrongPassword,omitempty"`
+	UnknownRequest    *Empty `json:"unknownRequest,omitempty"`
+	NotIdentified     *Empty `json:"notIdentified,omitempty"`
+	AlreadyIdentified *Empty `json:"alreadyIdentified,omitempty"`
+}
+
+var (
+	ResultOk            = Result{Ok: &Empty{}}
+	ResultWrongPassword = Result{WrongPassword: &Empty{}}
+	ResultUnknown       = Result{UnknownRequest: &Empty{}}
+)
+
+// IsOk reports whether the result is ok
+func (r Result) IsOk() bool {
+	return r.Ok != nil
+}
+
+// String names the result case
+func (r Result) String() string {
+	switch {
+	case r.Ok != nil:
+		return "ok"
+	case r.WrongPassword != nil:
+		return "wrongPassword"
+	case r.UnknownRequest != nil:
+		return "unknownRequest"
+	case r.NotIdentified != nil:
+		return "notIdentified"
+	case r.AlreadyIdentified != nil:
+		return "alreadyIdentified"
+	}
+	return "unknown"
+}
+
+type Request struct {
+	ID   int         `json:"id"`
+	Data RequestData `json:"data"`
+}
+
+// RequestData holds exactly one request case
+type RequestData struct {
+	GetStatus        *Empty `json:"getStatus,omitempty"`
+	GetSettings      *Empty `json:"getSettings,omitempty"`
+	SetRecord        *OnOff `json:"setRecord,omitempty"`
+	SetStream        *OnOff `json:"setStream,omitempty"`
+	SetZoom          *Zoom  `json:"setZoom,omitempty"`
+	SetMute          *OnOff `json:"setMute,omitempty"`
+	SetTorch         *OnOff `json:"setTorch,omitempty"`
+	SetScene         *ID    `json:"setScene,omitempty"`
+	SetBitratePreset *ID    `json:"setBitratePreset,omitempty"`
+}
+
+type OnOff struct {
+	On bool `json:"on"`
+}
+
+type Zoom struct {
+	X float64 `json:"x"`
+}
+
+type ID struct {
+	ID string `json:"id"`
+}
+
+type Response struct {
+	ID     int           `json:"id"`
+	Result Result        `json:"result"`
+	Data   *ResponseData `json:"data,omitempty"`
+}
+
+type ResponseData struct {
+	GetStatus   *Status       `json:"getStatus,omitempty"`
+	GetSettings *SettingsData `json:"getSettings,omitempty"`
+}
+
+// Status is the content of Moblin's status bar
+type Status struct {
+	General  *StatusGeneral `json:"general,omitempty"`
+	TopLeft  StatusTopLeft  `json:"topLeft"`
+	TopRight StatusTopRight `json:"topRight"`
+}
+
+type StatusGeneral struct {
+	BatteryCharging *bool           `json:"batteryCharging,omitempty"`
+	BatteryLevel    *int            `json:"batteryLevel,omitempty"`
+	Flame           json.RawMessage `json:"flame,omitempty"`
+	IsLive          *bool           `json:"isLive,omitempty"`
+	IsRecording     *bool           `json:"isRecording,omitempty"`
+	IsMuted         *bool           `json:"isMuted,omitempty"`
+}
+
+// StatusItem is a single status bar entry
+type StatusItem struct {
+	Message string `json:"message"`
+	Ok      bool   `json:"ok"`
+}
+
+type StatusTopLeft struct {
+	Stream  *StatusItem `json:"stream,omitempty"`
+	Camera  *StatusItem `json:"camera,omitempty"`
+	Mic     *StatusItem `json:"mic,omitempty"`
+	Zoom    *StatusItem `json:"zoom,omitempty"`
+	Obs     *StatusItem `json:"obs,omitempty"`
+	Viewers *StatusItem `json:"viewers,omitempty"`
+}
+
+type StatusTopRight struct {
+	Bitrate   *StatusItem `json:"bitrate,omitempty"`
+	Uptime    *StatusItem `json:"uptime,omitempty"`
+	Srtla     *StatusItem `json:"srtla,omitempty"`
+	SrtlaRtts *StatusItem `json:"srtlaRtts,omitempty"`
+	Recording *StatusItem `json:"recording,omitempty"`
+}
+
+type SettingsData struct {
+	Data Settings `json:"data"`
+}
+
+// Settings lists the scenes and bitrate presets configured in Moblin
+type Settings struct {
+	Scenes         []SettingsScene         `json:"scenes"`
+	BitratePresets []SettingsBitratePreset `json:"bitratePresets"`
+}
+
+type SettingsScene struct {
+	ID   string `json:"id"`
+	Name string `json:"name"`
+}
+
+type SettingsBitratePreset struct {
+	ID      string `json:"id"`
+	Bitrate int    `json:"bitrate"` // bits per second
+}
+
+type Event struct {
+	Data EventData `json:"data"`
+}
+
+type EventData struct {
+	State *StateEvent `json:"state,omitempty"`
+	Log   *LogEvent   `json:"log,omitempty"`
+}
+
+type StateEvent struct {
+	Data State `json:"data"`
+}
+
+type LogEvent struct {
+	Entry string `json:"entry"`
+}
+
+// State is the streamer state pushed on every change. Only changed
+// fields are set.
+type State struct {
+	Scene     *string  `json:"scene,omitempty"`
+	Mic       *string  `json:"mic,omitempty"`
+	Bitrate   *string  `json:"bitrate,omitempty"`
+	Zoom      *float64 `json:"zoom,omitempty"`
+	Streaming *bool    `json:"streaming,omitempty"`
+	Recording *bool    `json:"recording,omitempty"`
+	Muted     *bool    `json:"muted,omitempty"`
+	TorchOn   *bool    `json:"torchOn,omitempty"`
+}
+
+// HashPassword computes the identify authentication the same way Moblin does:
+// base64(SHA256(base64(SHA256(password + salt)) + challenge))
+func HashPassword(challenge, salt, password string) string {
+	hash := sha256.Sum256([]byte(password + salt))
+	hash = sha256.Sum256([]byte(base64.StdEncoding.EncodeToString(hash[:]) + challenge))
+	return base64.StdEncoding.EncodeToString(hash[:])
+}
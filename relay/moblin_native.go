@@ -0,0 +1,118 @@
+/**
+ * Native Moblin Remote Control - Stock Moblin app as a relay device
+ * Moblin connects to /moblin as if the relay were a remote control
+ * assistant. The moblin.Adapter translates its native protocol to and
+ * from the relay dialect, so the rest of the relay treats it like any
+ * other Moblin device.
+ */
+
+package main
+
+import (
+	"log"
+	"net/http"
+	"time"
+
+	"github.com/gorilla/websocket"
+	"github.com/volleybratans/moblin-relay/moblin"
+)
+
+// nativeStatusInterval is how often native devices are polled for status
+const nativeStatusInterval = 5 * time.Second
+
+// ServeMoblin accepts Moblin's native remote control connection
+// (Settings → Remote control → Assistant URL: wss://host/moblin?device=cam2)
+func (r *Relay) ServeMoblin(w http.ResponseWriter, req *http.Request) {
+	if r.isClosing() {
+		http.Error(w, "Server restarting", http.StatusServiceUnavailable)
+		return
+	}
+	device := req.URL.Query().Get("device")
+	if device == "" {
+		device = DefaultDevice
+	}
+	if device == DeviceAll || !deviceNamePattern.MatchString(device) {
+		http.Error(w, "Invalid device name", http.StatusBadRequest)
+		return
+	}
+	conn, err := upgrader.Upgrade(w, req, nil)
+	if err != nil {
+		return
+	}
+	client := r.newClient(conn, req, ClientTypeMoblin, device)
+	client.native = moblin.NewAdapter(r.password)
+	// Moblin identifies with its own challenge/response, not the relay's
+	client.enforceAuthTimeout(r.authWait)
+	client.enqueueControl(client.native.Hello())
+	r.register <- client
+	go client.writePump()
+	go client.readPump()
+}
+
+// handleNative processes a frame from a native Moblin connection
+func (c *Client) handleNative(raw []byte) {
+	if !c.isAuthorized() && c.tooManyAuthFailures() {
+		log.Printf("[AUTH] Too many failed WebSocket logins from %s", c.RemoteIP)
+		c.closeWith(websocket.ClosePolicyViolation, "too many failed attempts")
+		return
+	}
+	in, err := c.native.Inbound(raw)
+	if err != nil {
+		log.Printf("[MOBLIN] %s: %v", c.ID, err)
+		return
+	}
+	for _, frame := range in.Replies {
+		c.enqueueControl(frame)
+	}
+	if in.Rejected {
+		log.Printf("[AUTH] Moblin identify failed: %s (%s)", c.ID, c.RemoteIP)
+		c.recordAuthFailure()
+	}
+	if in.Identified {
+		log.Printf("[MOBLIN] %s identified as streamer %s (device: %s)", c.ID, in.StreamerID, c.Device)
+	}
+	if in.Ready {
+		// The device counts as authorized once scenes and bitrate presets are
+		// known; until then commands are queued instead of failing to translate
+		c.mu.Lock()
+		authorized := c.Authorized
+		c.Authorized = true
+		c.mu.Unlock()
+		if !authorized {
+			go c.Relay.pollNative(c)
+		}
+	}
+	for _, msg := range in.Messages {
+		c.handleMessage(msg)
+	}
+	if in.Ready {
+		c.Relay.flushQueue(c)
+	}
+}
+
+// writeNative translates an outgoing relay-dialect frame for a native device
+func (c *Client) writeNative(message []byte) [][]byte {
+	frames, replies := c.native.Outbound(message)
+	for _, reply := range replies {
+		c.handleMessage(reply)
+	}
+	return frames
+}
+
+// pollNative requests Moblin's status periodically; the responses are
+// turned into stream_info events. Stops once the client is gone.
+func (r *Relay) pollNative(c *Client) {
+	ticker := time.NewTicker(nativeStatusInterval)
+	defer ticker.Stop()
+	for range ticker.C {
+		r.mu.RLock()
+		registered := r.clients[c.ID] == c
+		r.mu.RUnlock()
+		if !registered {
+			return
+		}
+		if frame := c.native.PollStatus(); frame != nil {
+			c.enqueue(frame)
+		}
+	}
+}
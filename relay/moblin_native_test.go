@@ -0,0 +1,186 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gorilla/websocket"
+	"github.com/volleybratans/moblin-relay/moblin"
+	"github.com/volleybratans/moblin-relay/moblin/moblintest"
+	"github.com/volleybratans/moblin-relay/protocol"
+	"github.com/volleybratans/moblin-relay/services"
+)
+
+const testPassword = "secret"
+
+// startTestRelay runs a relay with testPassword and returns its WebSocket base URL
+func startTestRelay(t *testing.T) string {
+	t.Helper()
+	relay := NewRelay(RelayConfig{Password: testPassword, PingInterval: time.Hour})
+	go relay.Run()
+	mux := http.NewServeMux()
+	mux.HandleFunc("/ws", relay.ServeWS)
+	mux.HandleFunc("/moblin", relay.ServeMoblin)
+	server := httptest.NewServer(mux)
+	t.Cleanup(server.Close)
+	return "ws" + strings.TrimPrefix(server.URL, "http")
+}
+
+// connectBrowser logs a browser in with the relay password
+func connectBrowser(t *testing.T, base string) *websocket.Conn {
+	t.Helper()
+	conn, _, err := websocket.DefaultDialer.Dial(base+"/ws", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { conn.Close() })
+	hello, _ := readUntil(t, conn, protocol.TypeHello)
+	conn.WriteJSON(map[string]string{
+		"type":     protocol.TypeAuth,
+		"response": protocol.AuthResponse(testPassword, hello.Salt, hello.Challenge),
+	})
+	readUntil(t, conn, "auth_success")
+	return conn
+}
+
+// readUntil reads messages until one of type msgType arrives and returns
+// it decoded and as received
+func readUntil(t *testing.T, conn *websocket.Conn, msgType string) (Message, []byte) {
+	t.Helper()
+	conn.SetReadDeadline(time.Now().Add(5 * time.Second))
+	for {
+		_, raw, err := conn.ReadMessage()
+		if err != nil {
+			t.Fatalf("waiting for %s: %v", msgType, err)
+		}
+		var msg Message
+		if json.Unmarshal(raw, &msg) == nil && msg.Type == msgType {
+			return msg, raw
+		}
+	}
+}
+
+func TestNativeMoblin(t *testing.T) {
+	base := startTestRelay(t)
+	browser := connectBrowser(t, base)
+
+	streamer := moblintest.NewStreamer(testPassword)
+	if err := streamer.Connect(base + "/moblin?device=cam2"); err != nil {
+		t.Fatal(err)
+	}
+	defer streamer.Close()
+	result, err := streamer.WaitIdentified(5 * time.Second)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !result.IsOk() {
+		t.Fatalf("identify result %s", result)
+	}
+
+	// The status requested after identify arrives once settings are loaded
+	if info, _ := readUntil(t, browser, protocol.TypeStreamInfo); info.Device != "cam2" {
+		t.Errorf("stream_info from %q, want cam2", info.Device)
+	}
+
+	// Browser command -> native request -> response -> command_ack
+	browser.WriteJSON(map[string]any{"type": protocol.TypeSetScene, "name": "court_overview", "device": "cam2", "ref": "r1"})
+	if ack, _ := readUntil(t, browser, protocol.TypeCommandAck); ack.Ref != "r1" || ack.Device != "cam2" {
+		t.Errorf("command_ack %+v, want ref r1 from cam2", ack)
+	}
+	_, raw := readUntil(t, browser, protocol.TypeSceneChanged)
+	var scene protocol.SceneChanged
+	json.Unmarshal(raw, &scene)
+	if scene.Scene != "Court overview" {
+		t.Errorf("scene_changed to %q, want Court overview", scene.Scene)
+	}
+	if got := streamer.State().Scene; got == nil || *got != streamer.Scenes[2].ID {
+		t.Errorf("streamer scene %v, want %s", got, streamer.Scenes[2].ID)
+	}
+
+	// Native state event -> relay event
+	live := true
+	streamer.PushState(moblin.State{Streaming: &live})
+	if started, _ := readUntil(t, browser, protocol.TypeStreamStarted); started.Device != "cam2" {
+		t.Errorf("stream_started from %q, want cam2", started.Device)
+	}
+}
+
+func TestNativeMoblinSettingsDelay(t *testing.T) {
+	base := startTestRelay(t)
+	browser := connectBrowser(t, base)
+
+	streamer := moblintest.NewStreamer(testPassword)
+	streamer.SettingsDelay = 300 * time.Millisecond
+	if err := streamer.Connect(base + "/moblin?device=cam2"); err != nil {
+		t.Fatal(err)
+	}
+	defer streamer.Close()
+	if _, err := streamer.WaitIdentified(5 * time.Second); err != nil {
+		t.Fatal(err)
+	}
+
+	// Identified but without scenes yet: the command waits for the settings
+	browser.WriteJSON(map[string]any{"type": protocol.TypeSetScene, "name": "court_overview", "device": "cam2", "ref": "r1"})
+	if queued, _ := readUntil(t, browser, "command_queued"); queued.Ref != "r1" {
+		t.Errorf("command_queued %+v, want ref r1", queued)
+	}
+	if ack, _ := readUntil(t, browser, protocol.TypeCommandAck); ack.Ref != "r1" || ack.Device != "cam2" {
+		t.Errorf("command_ack %+v, want ref r1 from cam2", ack)
+	}
+}
+
+func TestNativeMoblinWrongPassword(t *testing.T) {
+	base := startTestRelay(t)
+	streamer := moblintest.NewStreamer("guess")
+	if err := streamer.Connect(base + "/moblin"); err != nil {
+		t.Fatal(err)
+	}
+	defer streamer.Close()
+	result, err := streamer.WaitIdentified(5 * time.Second)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if result.WrongPassword == nil {
+		t.Errorf("identify result %s, want wrongPassword", result)
+	}
+	if len(streamer.Requests()) != 0 {
+		t.Errorf("rejected streamer received requests: %+v", streamer.Requests())
+	}
+}
+
+func TestNativeMoblinAuthLimit(t *testing.T) {
+	relay := NewRelay(RelayConfig{
+		Password:     testPassword,
+		AuthService:  services.NewAuthService(t.TempDir(), "", ""),
+		PingInterval: time.Hour,
+	})
+	go relay.Run()
+	server := httptest.NewServer(http.HandlerFunc(relay.ServeMoblin))
+	defer server.Close()
+	url := "ws" + strings.TrimPrefix(server.URL, "http")
+
+	for i := 0; i < maxAuthFailures; i++ {
+		streamer := moblintest.NewStreamer("guess")
+		if err := streamer.Connect(url); err != nil {
+			t.Fatal(err)
+		}
+		if result, err := streamer.WaitIdentified(5 * time.Second); err != nil || result.WrongPassword == nil {
+			t.Fatalf("attempt %d: result %s, error %v", i+1, result, err)
+		}
+		streamer.Close()
+	}
+
+	// Once locked out, even the right password is not checked
+	streamer := moblintest.NewStreamer(testPassword)
+	if err := streamer.Connect(url); err != nil {
+		t.Fatal(err)
+	}
+	defer streamer.Close()
+	if result, err := streamer.WaitIdentified(time.Second); err == nil {
+		t.Errorf("identified with %s after %d failures", result, maxAuthFailures)
+	}
+}
@@ -1,5 +1,10 @@
 package models
 
+import (
+	"encoding/json"
+	"time"
+)
+
 // MatchdayState represents the central match configuration
 type MatchdayState struct {
 	Version     int64  `json:"version"`
@@ -9,6 +14,43 @@ type MatchdayState struct {
 	Date        string `json:"date"`
 	DvvLink     string `json:"dvvLink"`
 	MatchID     string `json:"matchId"`
+	// Scoring rules (rules.Formats), indoor if empty
+	Format string `json:"format,omitempty"`
+	// Live score, only changed through the score endpoints
+	Score MatchScore `json:"score"`
+}
+
+// MatchScore is the authoritative live score of the match
+type MatchScore struct {
+	HomePoints int        `json:"homePoints"`
+	AwayPoints int        `json:"awayPoints"`
+	HomeSets   int        `json:"homeSets"`
+	AwaySets   int        `json:"awaySets"`
+	CurrentSet int        `json:"currentSet"`
+	SetHistory []SetScore `json:"setHistory"`
+	Serving    string     `json:"serving,omitempty"`    // home or away, the team that won the last rally
+	FirstServe string     `json:"firstServe,omitempty"` // team that served first in the current set
+
+	// Timeouts taken in the current set
+	HomeTimeouts int `json:"homeTimeouts"`
+	AwayTimeouts int `json:"awayTimeouts"`
+
+	// Points played in the current set when the teams last switched sides,
+	// so corrected points don't announce the same switch twice
+	SidesSwitchedAt int `json:"sidesSwitchedAt,omitempty"`
+
+	// Derived by the rules after every change
+	SetBall     string `json:"setBall,omitempty"`     // team one point from winning the set
+	MatchBall   string `json:"matchBall,omitempty"`   // team one point from winning the match
+	SwitchSides bool   `json:"switchSides,omitempty"` // the last point requires a side switch
+	Finished    bool   `json:"finished,omitempty"`
+	Winner      string `json:"winner,omitempty"`
+}
+
+// SetScore is the final score of a finished set
+type SetScore struct {
+	Home int `json:"home"`
+	Away int `json:"away"`
 }
 
 // ScoutState represents the scout data state
@@ -29,3 +71,258 @@ type Player struct {
 	Active   bool             `json:"active"`
 	Scores   map[string][]int `json:"scores"`
 }
+
+// StreamSnapshot is the last known state of all Moblin devices
+type StreamSnapshot struct {
+	LastUpdated string                 `json:"lastUpdated"`
+	Devices     map[string]StreamState `json:"devices"`
+}
+
+// StreamState is the last known state of a single Moblin device
+type StreamState struct {
+	Device       string               `json:"device"`
+	Connected    bool                 `json:"connected"`
+	Live         bool                 `json:"live"`
+	Scene        string               `json:"scene,omitempty"`
+	Bitrate      int                  `json:"bitrate"`
+	FPS          int                  `json:"fps"`
+	Battery      int                  `json:"battery"`
+	Viewers      int                  `json:"viewers"`
+	ThermalState string               `json:"thermal_state,omitempty"`
+	Recording    bool                 `json:"recording"`
+	MicMuted     bool                 `json:"mic_muted"`
+	TorchEnabled bool                 `json:"torch_enabled"`
+	UploadStats  map[string]LinkStats `json:"upload_stats,omitempty"`
+	LastUpdated  string               `json:"lastUpdated"`
+}
+
+// LinkStats describes a single uplink (lte, wifi, ...) of a Moblin device
+type LinkStats struct {
+	Kbps int `json:"kbps"`
+	RTT  int `json:"rtt"`
+}
+
+// ClientMetrics describes message delivery to a single WebSocket client
+type ClientMetrics struct {
+	ID              string `json:"id"`
+	Type            string `json:"type"`
+	Device          string `json:"device,omitempty"`
+	Delivered       uint64 `json:"delivered"`
+	Dropped         uint64 `json:"dropped"`
+	QueueDepth      int    `json:"queue_depth"`
+	QueueCapacity   int    `json:"queue_capacity"`
+	ControlDepth    int    `json:"control_depth"`
+	ControlCapacity int    `json:"control_capacity"`
+	SaturatedSince  string `json:"saturated_since,omitempty"`
+}
+
+// TelemetrySample is a single point of a device's stream telemetry; metrics
+// the device has not reported are left out
+type TelemetrySample struct {
+	Time         string               `json:"t"`
+	Bitrate      *int                 `json:"bitrate,omitempty"`
+	FPS          *int                 `json:"fps,omitempty"`
+	Battery      *int                 `json:"battery,omitempty"`
+	Viewers      *int                 `json:"viewers,omitempty"`
+	ThermalState string               `json:"thermal_state,omitempty"`
+	UploadStats  map[string]LinkStats `json:"upload_stats,omitempty"`
+}
+
+// TelemetrySession is the recorded telemetry of one stream session
+type TelemetrySession struct {
+	Device  string            `json:"device"`
+	Started string            `json:"started"`
+	Ended   string            `json:"ended"`
+	Samples []TelemetrySample `json:"samples"`
+}
+
+// AlertRule is a condition on Moblin telemetry that raises an alert
+type AlertRule struct {
+	ID       string   `json:"id"`
+	Metric   string   `json:"metric"` // battery, bitrate, viewers, thermal, disconnected, link_rtt, link_jitter
+	Below    *int     `json:"below,omitempty"`
+	Above    *int     `json:"above,omitempty"`
+	States   []string `json:"states,omitempty"` // thermal states that trigger the rule
+	For      int      `json:"for,omitempty"`    // seconds the condition must hold
+	LiveOnly bool     `json:"live_only,omitempty"`
+	Severity string   `json:"severity"` // info, warning, critical
+	Message  string   `json:"message,omitempty"`
+	Disabled bool     `json:"disabled,omitempty"`
+}
+
+// Alert is a rule that fired for a device
+type Alert struct {
+	ID           string `json:"id"`
+	Rule         string `json:"rule"`
+	Device       string `json:"device"`
+	Severity     string `json:"severity"`
+	Message      string `json:"message"`
+	State        string `json:"state"` // firing, resolved
+	Value        string `json:"value,omitempty"`
+	Count        int    `json:"count"`
+	Acknowledged bool   `json:"acknowledged"`
+	FiredAt      string `json:"fired_at"`
+	ResolvedAt   string `json:"resolved_at,omitempty"`
+}
+
+// AutomationConfig is the set of scene switching rules and their master switch
+type AutomationConfig struct {
+	Enabled bool             `json:"enabled"`
+	Rules   []AutomationRule `json:"rules"`
+}
+
+// AutomationRule switches a scene when a relay event happens, optionally
+// switching to Then after For seconds
+type AutomationRule struct {
+	ID       string `json:"id"`
+	On       string `json:"on"`               // event type, e.g. set_end, timeout, stream_started
+	Device   string `json:"device,omitempty"` // target device, all if empty
+	Scene    string `json:"scene"`
+	For      int    `json:"for,omitempty"`
+	Then     string `json:"then,omitempty"`
+	Disabled bool   `json:"disabled,omitempty"`
+}
+
+// AutomationLogEntry records a scene switch made by an automation rule
+type AutomationLogEntry struct {
+	Time   string `json:"time"`
+	Rule   string `json:"rule"`
+	Event  string `json:"event"`
+	Device string `json:"device,omitempty"`
+	Scene  string `json:"scene"`
+	Result string `json:"result"` // sent, then the command reply (command_ack, command_failed, ...)
+}
+
+// Macro is a named sequence of Moblin commands and wait steps, e.g.
+// {"type": "set_bitrate", "kbps": 6000} or {"type": "wait", "seconds": 300}
+type Macro struct {
+	Name   string            `json:"name"`
+	Device string            `json:"device,omitempty"`
+	Steps  []json.RawMessage `json:"steps"`
+}
+
+// MacroProgress reports the state of a running macro
+type MacroProgress struct {
+	RunID   string `json:"run_id"`
+	Macro   string `json:"macro"`
+	Device  string `json:"device,omitempty"`
+	State   string `json:"state"` // running, completed, failed, cancelled
+	Step    int    `json:"step"`  // 1-based index of the current step
+	Steps   int    `json:"steps"`
+	Command string `json:"command,omitempty"`
+	Result  string `json:"result,omitempty"`
+	Message string `json:"message,omitempty"`
+	Started string `json:"started"`
+}
+
+// AuditEntry is one line of the audit log
+type AuditEntry struct {
+	Time      string          `json:"time"`
+	Action    string          `json:"action"`               // command, scout_update, matchday_update, scout_archive, login, ...
+	SessionID string          `json:"session_id,omitempty"` // first 8 characters of the login session
+	IP        string          `json:"ip,omitempty"`
+	UserAgent string          `json:"user_agent,omitempty"`
+	Device    string          `json:"device,omitempty"`
+	Data      json.RawMessage `json:"data,omitempty"` // the command, or details of the change
+}
+
+// AuditQuery filters the audit log; zero values match everything
+type AuditQuery struct {
+	Action    string
+	SessionID string
+	Device    string
+	From      time.Time
+	To        time.Time
+	Limit     int
+}
+
+// ClientInfo describes a connected WebSocket client
+type ClientInfo struct {
+	ID              string   `json:"id"`
+	Type            string   `json:"type"`
+	Device          string   `json:"device,omitempty"`
+	Authorized      bool     `json:"authorized"`
+	RemoteIP        string   `json:"remote_ip"`
+	UserAgent       string   `json:"user_agent,omitempty"`
+	Session         string   `json:"session,omitempty"` // first 8 characters of the login session
+	ProtocolVersion int      `json:"protocol_version"`
+	Topics          []string `json:"topics"`
+	ConnectedAt     string   `json:"connected_at"`
+	LastMessage     string   `json:"last_message,omitempty"` // last frame received from the client
+	RTTMs           *float64 `json:"rtt_ms,omitempty"`       // WebSocket ping round trip, once measured
+	Latency         *Latency `json:"latency,omitempty"`
+}
+
+// Presence counts connected clients by type
+type Presence struct {
+	Browsers int      `json:"browsers"`
+	Moblins  int      `json:"moblins"`
+	Overlays int      `json:"overlays"`
+	Devices  []string `json:"devices"`
+}
+
+// ControlStatus describes who holds the director lock for Moblin commands
+type ControlStatus struct {
+	Locked bool   `json:"locked"`
+	Holder string `json:"holder,omitempty"` // client ID of the director
+	Name   string `json:"name,omitempty"`
+	Since  string `json:"since,omitempty"`
+	Mine   bool   `json:"mine"`             // the receiving browser is the director
+	Reason string `json:"reason,omitempty"` // what changed: claimed, released, stolen, handover, disconnected, idle
+}
+
+// Latency summarizes the recent WebSocket ping round trips of a client
+type Latency struct {
+	RTTMs     float64   `json:"rtt_ms"` // most recent round trip
+	AvgMs     float64   `json:"avg_ms"`
+	MinMs     float64   `json:"min_ms"`
+	MaxMs     float64   `json:"max_ms"`
+	JitterMs  float64   `json:"jitter_ms"` // mean difference between consecutive round trips
+	Samples   int       `json:"samples"`
+	HistoryMs []float64 `json:"history_ms,omitempty"` // oldest first
+}
+
+// SamsMatch is a match from the SAMS live ticker, normalized from the
+// backend.sams-ticker.de feed. Teams are home (team1) and away (team2).
+type SamsMatch struct {
+	ID            string      `json:"id"`
+	Teams         SamsTeams   `json:"teams"`
+	Logos         SamsTeams   `json:"logos"` // logo URLs, empty if unknown
+	Sets          SetScore    `json:"sets"`  // sets won
+	SetHistory    []SetScore  `json:"setHistory"`
+	CurrentPoints SetScore    `json:"currentPoints"`
+	Serving       string      `json:"serving,omitempty"` // home or away
+	Status        string      `json:"status"`            // upcoming, live or finished
+	Date          string      `json:"date,omitempty"`
+	StartTime     string      `json:"startTime,omitempty"`
+	Series        string      `json:"series,omitempty"`
+	IsMatchball   bool        `json:"isMatchball"`
+	IsSatzball    bool        `json:"isSatzball"`
+	Timeline      []SamsEvent `json:"timeline"` // last 10 ticker events, oldest first
+	HasStats      bool        `json:"hasStats"`
+}
+
+// SamsTeams holds a value per team
+type SamsTeams struct {
+	Home string `json:"home"`
+	Away string `json:"away"`
+}
+
+// SamsEvent is a ticker event such as a point, timeout or substitution
+type SamsEvent struct {
+	Type        string `json:"type"`
+	Team        string `json:"team,omitempty"` // home or away
+	Time        string `json:"time,omitempty"`
+	Description string `json:"description"`
+}
+
+// SamsStatus is the state of the SAMS ticker poller
+type SamsStatus struct {
+	MatchID    string     `json:"matchId"` // from the matchday state, empty when not polling
+	Match      *SamsMatch `json:"match"`   // last match data, nil before the first successful poll
+	LastUpdate string     `json:"lastUpdate,omitempty"`
+	LastPoll   string     `json:"lastPoll,omitempty"`
+	LastError  string     `json:"lastError,omitempty"`
+	Failures   int        `json:"failures"` // consecutive failed polls
+	NextPoll   string     `json:"nextPoll,omitempty"`
+}
@@ -0,0 +1,117 @@
+/**
+ * Presence - Who is connected to the relay
+ * Lists clients for /api/clients, disconnects them on request and tells
+ * browsers how many operators, cameras and overlays are online.
+ */
+
+package main
+
+import (
+	"encoding/json"
+	"log"
+	"sort"
+	"sync/atomic"
+	"time"
+
+	"github.com/gorilla/websocket"
+	"github.com/volleybratans/moblin-relay/models"
+	"github.com/volleybratans/moblin-relay/protocol"
+	"github.com/volleybratans/moblin-relay/services"
+)
+
+// presence counts the connected clients. Caller must hold r.mu.
+func (r *Relay) presence() models.Presence {
+	return models.Presence{
+		Browsers: len(r.browsers),
+		Moblins:  len(r.moblins),
+		Overlays: len(r.overlays),
+		Devices:  r.deviceNames(),
+	}
+}
+
+// notifyPresence sends the current presence to browsers subscribed to the
+// stream topic. Caller must hold r.mu.
+func (r *Relay) notifyPresence() {
+	data, _ := json.Marshal(r.presence())
+	msg, _ := json.Marshal(Message{Type: protocol.TypePresence, Data: data})
+	for _, browser := range r.browsers {
+		if browser.isAuthorized() && browser.IsSubscribed(protocol.TopicStream) {
+			browser.enqueue(msg)
+		}
+	}
+}
+
+// sendPresence sends the current presence to a newly authorized browser
+func (c *Client) sendPresence() {
+	if !c.IsSubscribed(protocol.TopicStream) {
+		return
+	}
+	c.Relay.mu.RLock()
+	data, _ := json.Marshal(c.Relay.presence())
+	c.Relay.mu.RUnlock()
+	c.sendJSON(Message{Type: protocol.TypePresence, Data: data})
+}
+
+// Clients lists all connected clients, oldest connection first
+func (r *Relay) Clients() []models.ClientInfo {
+	r.mu.RLock()
+	clients := make([]*Client, 0, len(r.clients))
+	for _, client := range r.clients {
+		clients = append(clients, client)
+	}
+	r.mu.RUnlock()
+
+	sort.Slice(clients, func(i, j int) bool {
+		return clients[i].connectedAt.Before(clients[j].connectedAt)
+	})
+	infos := make([]models.ClientInfo, 0, len(clients))
+	for _, client := range clients {
+		infos = append(infos, client.Info())
+	}
+	return infos
+}
+
+// Kick disconnects a client; it returns false if there is no such client
+func (r *Relay) Kick(id string) bool {
+	r.mu.RLock()
+	client, ok := r.clients[id]
+	r.mu.RUnlock()
+	if !ok {
+		return false
+	}
+	log.Printf("[RELAY] Disconnecting %s on operator request (%s)", client.ID, client.RemoteIP)
+	client.closeWith(websocket.ClosePolicyViolation, "disconnected by operator")
+	return true
+}
+
+// Info describes the client for the client list
+func (c *Client) Info() models.ClientInfo {
+	c.mu.Lock()
+	info := models.ClientInfo{
+		ID:              c.ID,
+		Type:            string(c.Type),
+		Device:          c.Device,
+		Authorized:      c.Authorized,
+		RemoteIP:        c.RemoteIP,
+		UserAgent:       c.UserAgent,
+		Session:         services.SessionPrefix(c.SessionID),
+		ProtocolVersion: c.ProtocolVersion,
+		ConnectedAt:     c.connectedAt.UTC().Format(time.RFC3339),
+	}
+	c.mu.Unlock()
+	info.Topics = c.Topics()
+	if last := atomic.LoadInt64(&c.lastMessage); last != 0 {
+		info.LastMessage = time.Unix(0, last).UTC().Format(time.RFC3339)
+	}
+	if rtt := c.latency.last(); rtt != 0 {
+		ms := durationMs(rtt)
+		info.RTTMs = &ms
+	}
+	info.Latency = c.latency.summary(true)
+	return info
+}
+
+// touch records that a frame was received from the client
+func (c *Client) touch() {
+	atomic.StoreInt64(&c.lastMessage, time.Now().UnixNano())
+}
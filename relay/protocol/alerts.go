@@ -0,0 +1,29 @@
+package protocol
+
+// Alert message types
+const (
+	TypeAlert    = "alert"     // Relay -> Browser
+	TypeAckAlert = "ack_alert" // Browser -> Relay
+)
+
+// AlertAck acknowledges an alert so other operators see it is handled
+type AlertAck struct {
+	ID string `json:"id"`
+}
+
+// Validate checks that an alert ID is given
+func (a AlertAck) Validate() error {
+	if a.ID == "" {
+		return errorf(CodeInvalidParams, TypeAckAlert, "Missing alert id")
+	}
+	return nil
+}
+
+// ParseAlertAck decodes and validates an ack_alert message
+func ParseAlertAck(raw []byte) (AlertAck, error) {
+	var ack AlertAck
+	if err := decode(raw, TypeAckAlert, &ack); err != nil {
+		return ack, err
+	}
+	return ack, ack.Validate()
+}
@@ -0,0 +1,30 @@
+package protocol
+
+import (
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+)
+
+// NewNonce returns a random hex string for challenges and salts
+func NewNonce() string {
+	bytes := make([]byte, 32)
+	rand.Read(bytes)
+	return hex.EncodeToString(bytes)
+}
+
+// AuthResponse computes the answer to a relay challenge:
+// hex(HMAC-SHA256(key = hex(SHA256(salt + password)), challenge))
+func AuthResponse(password, salt, challenge string) string {
+	key := sha256.Sum256([]byte(salt + password))
+	mac := hmac.New(sha256.New, []byte(hex.EncodeToString(key[:])))
+	mac.Write([]byte(challenge))
+	return hex.EncodeToString(mac.Sum(nil))
+}
+
+// VerifyAuthResponse checks a client's answer in constant time
+func VerifyAuthResponse(password, salt, challenge, response string) bool {
+	expected := AuthResponse(password, salt, challenge)
+	return hmac.Equal([]byte(expected), []byte(response))
+}
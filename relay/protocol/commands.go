@@ -0,0 +1,146 @@
+package protocol
+
+// Command types (Browser -> Moblin)
+const (
+	TypeSetScene        = "set_scene"
+	TypeSetBitrate      = "set_bitrate"
+	TypeSetZoom         = "set_zoom"
+	TypeGoLive          = "go_live"
+	TypeEnd             = "end"
+	TypeToggleMic       = "toggle_mic"
+	TypeToggleTorch     = "toggle_torch"
+	TypeSnapshot        = "snapshot"
+	TypeToggleRecording = "toggle_recording"
+)
+
+// Documented parameter ranges
+const (
+	MinBitrateKbps = 1000
+	MaxBitrateKbps = 15000
+	MinZoom        = 1.0
+	MaxZoom        = 5.0
+)
+
+// KnownScenes lists the scene names configured in Moblin
+var KnownScenes = []string{"main", "wide", "closeup", "pip", "court_overview", "scoreboard", "interview", "replay", "BRB"}
+
+// Command is a validated browser -> Moblin command
+type Command interface {
+	CommandType() string
+	Validate() error
+}
+
+type SetScene struct {
+	Name string `json:"name"`
+}
+
+type SetBitrate struct {
+	Kbps int `json:"kbps"`
+}
+
+type SetZoom struct {
+	Level float64 `json:"level"`
+}
+
+type GoLive struct{}
+type End struct{}
+type ToggleMic struct{}
+type ToggleTorch struct{}
+type Snapshot struct{}
+type ToggleRecording struct{}
+
+func (SetScene) CommandType() string        { return TypeSetScene }
+func (SetBitrate) CommandType() string      { return TypeSetBitrate }
+func (SetZoom) CommandType() string         { return TypeSetZoom }
+func (GoLive) CommandType() string          { return TypeGoLive }
+func (End) CommandType() string             { return TypeEnd }
+func (ToggleMic) CommandType() string       { return TypeToggleMic }
+func (ToggleTorch) CommandType() string     { return TypeToggleTorch }
+func (Snapshot) CommandType() string        { return TypeSnapshot }
+func (ToggleRecording) CommandType() string { return TypeToggleRecording }
+
+func (c SetScene) Validate() error {
+	if !IsKnownScene(c.Name) {
+		return errorf(CodeInvalidParams, TypeSetScene, "Unknown scene %q", c.Name)
+	}
+	return nil
+}
+
+func (c SetBitrate) Validate() error {
+	if c.Kbps < MinBitrateKbps || c.Kbps > MaxBitrateKbps {
+		return errorf(CodeInvalidParams, TypeSetBitrate, "Bitrate must be between %d and %d kbps", MinBitrateKbps, MaxBitrateKbps)
+	}
+	return nil
+}
+
+func (c SetZoom) Validate() error {
+	if c.Level < MinZoom || c.Level > MaxZoom {
+		return errorf(CodeInvalidParams, TypeSetZoom, "Zoom must be between %.1f and %.1f", MinZoom, MaxZoom)
+	}
+	return nil
+}
+
+func (GoLive) Validate() error          { return nil }
+func (End) Validate() error             { return nil }
+func (ToggleMic) Validate() error       { return nil }
+func (ToggleTorch) Validate() error     { return nil }
+func (Snapshot) Validate() error        { return nil }
+func (ToggleRecording) Validate() error { return nil }
+
+// IsKnownScene reports whether name is one of the configured scenes
+func IsKnownScene(name string) bool {
+	for _, scene := range KnownScenes {
+		if scene == name {
+			return true
+		}
+	}
+	return false
+}
+
+var commandFactories = map[string]func() Command{
+	TypeSetScene:        func() Command { return &SetScene{} },
+	TypeSetBitrate:      func() Command { return &SetBitrate{} },
+	TypeSetZoom:         func() Command { return &SetZoom{} },
+	TypeGoLive:          func() Command { return &GoLive{} },
+	TypeEnd:             func() Command { return &End{} },
+	TypeToggleMic:       func() Command { return &ToggleMic{} },
+	TypeToggleTorch:     func() Command { return &ToggleTorch{} },
+	TypeSnapshot:        func() Command { return &Snapshot{} },
+	TypeToggleRecording: func() Command { return &ToggleRecording{} },
+}
+
+// IsCommand reports whether msgType is a browser -> Moblin command
+func IsCommand(msgType string) bool {
+	_, ok := commandFactories[msgType]
+	return ok
+}
+
+// ParseCommand decodes and validates a browser -> Moblin command
+func ParseCommand(raw []byte) (Command, error) {
+	env, err := ParseEnvelope(raw)
+	if err != nil {
+		return nil, err
+	}
+	factory, ok := commandFactories[env.Type]
+	if !ok {
+		return nil, errorf(CodeUnknownType, env.Type, "Unknown command %q", env.Type)
+	}
+	cmd := factory()
+	if err := decode(raw, env.Type, cmd); err != nil {
+		return nil, err
+	}
+	if err := cmd.Validate(); err != nil {
+		return nil, err
+	}
+	return cmd, nil
+}
+
+// EncodeCommand renders a validated command for Moblin, tagged with the
+// relay-assigned request ID
+func EncodeCommand(cmd Command, requestID string) ([]byte, error) {
+	extra := map[string]interface{}{}
+	if requestID != "" {
+		extra["request_id"] = requestID
+	}
+	return Encode(cmd.CommandType(), cmd, extra)
+}
@@ -0,0 +1,63 @@
+package protocol
+
+// Director control lock message types
+const (
+	TypeClaimControl    = "claim_control"    // Browser -> Relay
+	TypeReleaseControl  = "release_control"  // Browser -> Relay
+	TypeHandoverControl = "handover_control" // Browser -> Relay, director offers the lock
+	TypeAcceptControl   = "accept_control"   // Browser -> Relay, answer to control_offer
+	TypeDeclineControl  = "decline_control"  // Browser -> Relay, answer to control_offer
+	TypeControlStatus   = "control_status"   // Relay -> Browser
+	TypeControlOffer    = "control_offer"    // Relay -> Browser
+)
+
+// MaxControlName bounds the display name of a director
+const MaxControlName = 40
+
+// ClaimControl asks for the director lock. Steal takes it from the current
+// director; clients should confirm this with the user first.
+type ClaimControl struct {
+	Name  string `json:"name,omitempty"`
+	Steal bool   `json:"steal,omitempty"`
+}
+
+// HandoverControl offers the lock to one browser, or to all others if To is empty
+type HandoverControl struct {
+	To string `json:"to,omitempty"`
+}
+
+// IsControlType reports whether a browser message belongs to the director lock
+func IsControlType(msgType string) bool {
+	switch msgType {
+	case TypeClaimControl, TypeReleaseControl, TypeHandoverControl, TypeAcceptControl, TypeDeclineControl:
+		return true
+	}
+	return false
+}
+
+func (c ClaimControl) Validate() error {
+	if len(c.Name) > MaxControlName {
+		return errorf(CodeInvalidParams, TypeClaimControl, "Name must be at most %d characters", MaxControlName)
+	}
+	return nil
+}
+
+func (HandoverControl) Validate() error { return nil }
+
+// ParseClaimControl decodes and validates a claim_control message
+func ParseClaimControl(raw []byte) (ClaimControl, error) {
+	var c ClaimControl
+	if err := decode(raw, TypeClaimControl, &c); err != nil {
+		return c, err
+	}
+	return c, c.Validate()
+}
+
+// ParseHandoverControl decodes a handover_control message
+func ParseHandoverControl(raw []byte) (HandoverControl, error) {
+	var h HandoverControl
+	if err := decode(raw, TypeHandoverControl, &h); err != nil {
+		return h, err
+	}
+	return h, h.Validate()
+}
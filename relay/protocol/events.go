@@ -0,0 +1,200 @@
+package protocol
+
+// Event types (Moblin -> Browser)
+const (
+	TypeStatus          = "status"
+	TypeStreamInfo      = "stream_info"
+	TypeSceneChanged    = "scene_changed"
+	TypeStreamStarted   = "stream_started"
+	TypeStreamEnded     = "stream_ended"
+	TypeThermalUpdate   = "thermal_update"
+	TypeUploadStats     = "upload_stats"
+	TypeRecordingState  = "recording_state"
+	TypeMicState        = "mic_state"
+	TypeTorchState      = "torch_state"
+	TypeObsConnected    = "obs_connected"
+	TypeObsDisconnected = "obs_disconnected"
+	TypeCommandAck      = "command_ack"
+	TypeCommandFailed   = "command_failed"
+)
+
+// ThermalStates lists the thermal states reported by iOS
+var ThermalStates = []string{"fair", "nominal", "serious", "critical"}
+
+// Event is a validated Moblin -> browser message
+type Event interface {
+	EventType() string
+	Validate() error
+}
+
+// LinkStats describes a single uplink of the phone
+type LinkStats struct {
+	Kbps int `json:"kbps"`
+	RTT  int `json:"rtt"`
+}
+
+// StreamInfo is the periodic status report of a Moblin device.
+// Pointer fields are omitted when Moblin did not send them.
+type StreamInfo struct {
+	Bitrate      *int                 `json:"bitrate,omitempty"`
+	FPS          *int                 `json:"fps,omitempty"`
+	Battery      *int                 `json:"battery,omitempty"`
+	Viewers      *int                 `json:"viewers,omitempty"`
+	ThermalState *string              `json:"thermal_state,omitempty"`
+	UploadStats  map[string]LinkStats `json:"upload_stats,omitempty"`
+	legacy       bool
+}
+
+type SceneChanged struct {
+	Scene string `json:"scene"`
+}
+
+type ThermalUpdate struct {
+	ThermalState string `json:"thermal_state"`
+}
+
+type UploadStats struct {
+	UploadStats map[string]LinkStats `json:"upload_stats"`
+}
+
+type RecordingState struct {
+	Recording bool `json:"recording"`
+}
+
+type MicState struct {
+	Muted bool `json:"muted"`
+}
+
+type TorchState struct {
+	Enabled bool `json:"enabled"`
+}
+
+// CommandReply acknowledges or rejects a command by request ID
+type CommandReply struct {
+	RequestID string `json:"request_id"`
+	Message   string `json:"message,omitempty"`
+	failed    bool
+}
+
+type StreamStarted struct{}
+type StreamEnded struct{}
+type ObsConnected struct{}
+type ObsDisconnected struct{}
+
+func (e StreamInfo) EventType() string {
+	if e.legacy {
+		return TypeStatus
+	}
+	return TypeStreamInfo
+}
+func (SceneChanged) EventType() string    { return TypeSceneChanged }
+func (ThermalUpdate) EventType() string   { return TypeThermalUpdate }
+func (UploadStats) EventType() string     { return TypeUploadStats }
+func (RecordingState) EventType() string  { return TypeRecordingState }
+func (MicState) EventType() string        { return TypeMicState }
+func (TorchState) EventType() string      { return TypeTorchState }
+func (StreamStarted) EventType() string   { return TypeStreamStarted }
+func (StreamEnded) EventType() string     { return TypeStreamEnded }
+func (ObsConnected) EventType() string    { return TypeObsConnected }
+func (ObsDisconnected) EventType() string { return TypeObsDisconnected }
+
+func (e CommandReply) EventType() string {
+	if e.failed {
+		return TypeCommandFailed
+	}
+	return TypeCommandAck
+}
+
+// Failed reports whether Moblin rejected the command
+func (e CommandReply) Failed() bool {
+	return e.failed
+}
+
+func (e StreamInfo) Validate() error {
+	if e.Battery != nil && (*e.Battery < 0 || *e.Battery > 100) {
+		return errorf(CodeInvalidParams, TypeStreamInfo, "Battery must be between 0 and 100")
+	}
+	if e.Bitrate != nil && *e.Bitrate < 0 || e.FPS != nil && *e.FPS < 0 || e.Viewers != nil && *e.Viewers < 0 {
+		return errorf(CodeInvalidParams, TypeStreamInfo, "Negative values are not allowed")
+	}
+	if e.ThermalState != nil && !isThermalState(*e.ThermalState) {
+		return errorf(CodeInvalidParams, TypeStreamInfo, "Unknown thermal state %q", *e.ThermalState)
+	}
+	return nil
+}
+
+func (e ThermalUpdate) Validate() error {
+	if !isThermalState(e.ThermalState) {
+		return errorf(CodeInvalidParams, TypeThermalUpdate, "Unknown thermal state %q", e.ThermalState)
+	}
+	return nil
+}
+
+func (e CommandReply) Validate() error {
+	if e.RequestID == "" {
+		return errorf(CodeInvalidParams, e.EventType(), "Missing request_id")
+	}
+	return nil
+}
+
+func (SceneChanged) Validate() error    { return nil }
+func (UploadStats) Validate() error     { return nil }
+func (RecordingState) Validate() error  { return nil }
+func (MicState) Validate() error        { return nil }
+func (TorchState) Validate() error      { return nil }
+func (StreamStarted) Validate() error   { return nil }
+func (StreamEnded) Validate() error     { return nil }
+func (ObsConnected) Validate() error    { return nil }
+func (ObsDisconnected) Validate() error { return nil }
+
+func isThermalState(state string) bool {
+	for _, s := range ThermalStates {
+		if s == state {
+			return true
+		}
+	}
+	return false
+}
+
+var eventFactories = map[string]func() Event{
+	TypeStatus:          func() Event { return &StreamInfo{legacy: true} },
+	TypeStreamInfo:      func() Event { return &StreamInfo{} },
+	TypeSceneChanged:    func() Event { return &SceneChanged{} },
+	TypeThermalUpdate:   func() Event { return &ThermalUpdate{} },
+	TypeUploadStats:     func() Event { return &UploadStats{} },
+	TypeRecordingState:  func() Event { return &RecordingState{} },
+	TypeMicState:        func() Event { return &MicState{} },
+	TypeTorchState:      func() Event { return &TorchState{} },
+	TypeStreamStarted:   func() Event { return &StreamStarted{} },
+	TypeStreamEnded:     func() Event { return &StreamEnded{} },
+	TypeObsConnected:    func() Event { return &ObsConnected{} },
+	TypeObsDisconnected: func() Event { return &ObsDisconnected{} },
+	TypeCommandAck:      func() Event { return &CommandReply{} },
+	TypeCommandFailed:   func() Event { return &CommandReply{failed: true} },
+}
+
+// ParseEvent decodes and validates a Moblin -> browser event
+func ParseEvent(raw []byte) (Event, error) {
+	env, err := ParseEnvelope(raw)
+	if err != nil {
+		return nil, err
+	}
+	factory, ok := eventFactories[env.Type]
+	if !ok {
+		return nil, errorf(CodeUnknownType, env.Type, "Unknown event %q", env.Type)
+	}
+	ev := factory()
+	if err := decode(raw, env.Type, ev); err != nil {
+		return nil, err
+	}
+	if err := ev.Validate(); err != nil {
+		return nil, err
+	}
+	return ev, nil
+}
+
+// EncodeEvent renders a validated event for browsers, tagged with the
+// originating device
+func EncodeEvent(ev Event, device string) ([]byte, error) {
+	return Encode(ev.EventType(), ev, map[string]interface{}{"device": device})
+}
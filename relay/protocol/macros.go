@@ -0,0 +1,87 @@
+package protocol
+
+// Macro message types
+const (
+	TypeRunMacro      = "run_macro"      // Browser -> Relay
+	TypeCancelMacro   = "cancel_macro"   // Browser -> Relay
+	TypeMacroProgress = "macro_progress" // Relay -> Browser
+
+	// TypeWait is a macro step that pauses before the next command
+	TypeWait = "wait"
+)
+
+// MaxWaitSeconds bounds a single wait step
+const MaxWaitSeconds = 3600
+
+// RunMacro starts a stored macro, optionally on a different device
+type RunMacro struct {
+	Name   string `json:"name"`
+	Device string `json:"device,omitempty"`
+}
+
+// CancelMacro stops a running macro
+type CancelMacro struct {
+	RunID string `json:"run_id"`
+}
+
+// Wait is the payload of a wait step
+type Wait struct {
+	Seconds int `json:"seconds"`
+}
+
+func (m RunMacro) Validate() error {
+	if m.Name == "" {
+		return errorf(CodeInvalidParams, TypeRunMacro, "Missing macro name")
+	}
+	return nil
+}
+
+func (m CancelMacro) Validate() error {
+	if m.RunID == "" {
+		return errorf(CodeInvalidParams, TypeCancelMacro, "Missing run_id")
+	}
+	return nil
+}
+
+func (w Wait) Validate() error {
+	if w.Seconds <= 0 || w.Seconds > MaxWaitSeconds {
+		return errorf(CodeInvalidParams, TypeWait, "Wait must be between 1 and %d seconds", MaxWaitSeconds)
+	}
+	return nil
+}
+
+// ParseRunMacro decodes and validates a run_macro message
+func ParseRunMacro(raw []byte) (RunMacro, error) {
+	var m RunMacro
+	if err := decode(raw, TypeRunMacro, &m); err != nil {
+		return m, err
+	}
+	return m, m.Validate()
+}
+
+// ParseCancelMacro decodes and validates a cancel_macro message
+func ParseCancelMacro(raw []byte) (CancelMacro, error) {
+	var m CancelMacro
+	if err := decode(raw, TypeCancelMacro, &m); err != nil {
+		return m, err
+	}
+	return m, m.Validate()
+}
+
+// ParseMacroStep decodes a macro step: either a wait or a Moblin command.
+// Exactly one of the results is set.
+func ParseMacroStep(raw []byte) (*Wait, Command, error) {
+	env, err := ParseEnvelope(raw)
+	if err != nil {
+		return nil, nil, err
+	}
+	if env.Type == TypeWait {
+		var w Wait
+		if err := decode(raw, TypeWait, &w); err != nil {
+			return nil, nil, err
+		}
+		return &w, nil, w.Validate()
+	}
+	cmd, err := ParseCommand(raw)
+	return nil, cmd, err
+}
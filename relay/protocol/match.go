@@ -0,0 +1,49 @@
+package protocol
+
+// Match events logged by operators (Browser -> Relay), e.g. from the
+// scoreboard's "end set" button. They drive automation rules.
+const (
+	TypeMatchEvent = "match_event"
+
+	MatchSetEnd     = "set_end"
+	MatchTimeout    = "timeout"
+	MatchTimeoutEnd = "timeout_end"
+	MatchStart      = "match_start"
+	MatchEnd        = "match_end"
+	MatchSideSwitch = "side_switch"
+)
+
+// MatchEvents lists the events operators can log
+var MatchEvents = []string{MatchSetEnd, MatchTimeout, MatchTimeoutEnd, MatchStart, MatchEnd, MatchSideSwitch}
+
+// MatchEvent is the payload of a match_event message
+type MatchEvent struct {
+	Event string `json:"event"`
+}
+
+// Validate checks that the event is known
+func (m MatchEvent) Validate() error {
+	if !IsMatchEvent(m.Event) {
+		return errorf(CodeInvalidParams, TypeMatchEvent, "Unknown match event %q", m.Event)
+	}
+	return nil
+}
+
+// IsMatchEvent reports whether name is a known match event
+func IsMatchEvent(name string) bool {
+	for _, e := range MatchEvents {
+		if e == name {
+			return true
+		}
+	}
+	return false
+}
+
+// ParseMatchEvent decodes and validates a match_event message
+func ParseMatchEvent(raw []byte) (MatchEvent, error) {
+	var ev MatchEvent
+	if err := decode(raw, TypeMatchEvent, &ev); err != nil {
+		return ev, err
+	}
+	return ev, ev.Validate()
+}
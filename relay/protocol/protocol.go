@@ -0,0 +1,112 @@
+/**
+ * WebSocket Protocol - Typed messages between Browser, Relay and Moblin
+ * Mirrors docs/API.md; anything not defined here is rejected by the relay.
+ */
+
+package protocol
+
+import (
+	"encoding/json"
+	"fmt"
+)
+
+// Protocol versions understood by the relay. Clients that never send a
+// hello are treated as MinVersion.
+const (
+	Version    = 2
+	MinVersion = 1
+)
+
+// Relay-level message types handled by the relay itself
+const (
+	TypeHello       = "hello"
+	TypeHelloAck    = "hello_ack"
+	TypeAuth        = "auth"
+	TypeError       = "error"
+	TypePresence    = "presence"
+	TypeLinkQuality = "link_quality"
+)
+
+// Error codes sent in structured error replies
+const (
+	CodeInvalidJSON        = "invalid_json"
+	CodeUnknownType        = "unknown_type"
+	CodeInvalidParams      = "invalid_params"
+	CodeUnsupportedVersion = "unsupported_version"
+	CodeNotAuthorized      = "not_authorized"
+	CodeForbidden          = "forbidden"
+	CodeControlLocked      = "control_locked"
+)
+
+// Envelope holds the fields common to every message
+type Envelope struct {
+	Type      string `json:"type"`
+	Device    string `json:"device,omitempty"`
+	Ref       string `json:"ref,omitempty"`
+	RequestID string `json:"request_id,omitempty"`
+}
+
+// Error is a structured protocol error, sent to the client as an "error" message
+type Error struct {
+	Code    string `json:"code"`
+	Message string `json:"message"`
+	Type    string `json:"-"`
+}
+
+func (e *Error) Error() string {
+	return fmt.Sprintf("%s: %s", e.Code, e.Message)
+}
+
+func errorf(code, msgType, format string, args ...interface{}) *Error {
+	return &Error{Code: code, Type: msgType, Message: fmt.Sprintf(format, args...)}
+}
+
+// ParseEnvelope decodes the common fields of a raw message
+func ParseEnvelope(raw []byte) (Envelope, error) {
+	var env Envelope
+	if err := json.Unmarshal(raw, &env); err != nil {
+		return env, errorf(CodeInvalidJSON, "", "Message is not a JSON object")
+	}
+	if env.Type == "" {
+		return env, errorf(CodeInvalidParams, "", "Missing message type")
+	}
+	return env, nil
+}
+
+// NegotiateVersion picks the protocol version for a client announcing requested
+func NegotiateVersion(requested int) (int, error) {
+	if requested < MinVersion {
+		return 0, errorf(CodeUnsupportedVersion, TypeHello, "Protocol version %d not supported (min %d)", requested, MinVersion)
+	}
+	if requested > Version {
+		return Version, nil
+	}
+	return requested, nil
+}
+
+// Encode renders a typed message with its type and additional top-level fields
+func Encode(msgType string, v interface{}, extra map[string]interface{}) ([]byte, error) {
+	body, err := json.Marshal(v)
+	if err != nil {
+		return nil, err
+	}
+	obj := make(map[string]json.RawMessage)
+	if err := json.Unmarshal(body, &obj); err != nil {
+		return nil, err
+	}
+	for key, value := range extra {
+		if obj[key], err = json.Marshal(value); err != nil {
+			return nil, err
+		}
+	}
+	obj["type"], _ = json.Marshal(msgType)
+	return json.Marshal(obj)
+}
+
+// decode unmarshals the payload of a message into its typed struct
+func decode(raw []byte, msgType string, v interface{}) error {
+	if err := json.Unmarshal(raw, v); err != nil {
+		return errorf(CodeInvalidParams, msgType, "Invalid %s payload: %v", msgType, err)
+	}
+	return nil
+}
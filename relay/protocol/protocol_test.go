@@ -0,0 +1,170 @@
+package protocol
+
+import (
+	"errors"
+	"testing"
+)
+
+// checkCode fails unless err is a protocol error with code, or nil if code is empty
+func checkCode(t *testing.T, err error, code string) {
+	t.Helper()
+	if code == "" {
+		if err != nil {
+			t.Errorf("rejected: %v", err)
+		}
+		return
+	}
+	var perr *Error
+	if !errors.As(err, &perr) {
+		t.Fatalf("error %v, want %s", err, code)
+	}
+	if perr.Code != code {
+		t.Errorf("code %s (%s), want %s", perr.Code, perr.Message, code)
+	}
+}
+
+func TestParseCommand(t *testing.T) {
+	tests := []struct {
+		raw  string
+		code string // empty: accepted
+	}{
+		// Boundaries
+		{`{"type":"set_bitrate","kbps":1000}`, ""},
+		{`{"type":"set_bitrate","kbps":15000}`, ""},
+		{`{"type":"set_bitrate","kbps":999}`, CodeInvalidParams},
+		{`{"type":"set_bitrate","kbps":15001}`, CodeInvalidParams},
+		{`{"type":"set_bitrate"}`, CodeInvalidParams},
+		{`{"type":"set_zoom","level":1.0}`, ""},
+		{`{"type":"set_zoom","level":5.0}`, ""},
+		{`{"type":"set_zoom","level":0.9}`, CodeInvalidParams},
+		{`{"type":"set_zoom","level":5.01}`, CodeInvalidParams},
+		{`{"type":"set_scene","name":"court_overview"}`, ""},
+		{`{"type":"set_scene","name":"BRB"}`, ""},
+		{`{"type":"set_scene","name":"brb"}`, CodeInvalidParams},
+		{`{"type":"set_scene","name":""}`, CodeInvalidParams},
+		{`{"type":"go_live"}`, ""},
+		{`{"type":"toggle_recording","device":"cam2","ref":"r1"}`, ""},
+
+		// Malformed
+		{`{"type":"set_bitrate","kbps":"5000"}`, CodeInvalidParams},
+		{`{"type":"set_zoom","level":[2]}`, CodeInvalidParams},
+		{`{"type":"set_scene","name":7}`, CodeInvalidParams},
+		{`{"type":"reboot"}`, CodeUnknownType},
+		{`{"kbps":5000}`, CodeInvalidParams},
+		{`{"type":"set_bitrate","kbps":5000`, CodeInvalidJSON},
+		{`["set_bitrate"]`, CodeInvalidJSON},
+	}
+	for _, tt := range tests {
+		t.Run(tt.raw, func(t *testing.T) {
+			cmd, err := ParseCommand([]byte(tt.raw))
+			checkCode(t, err, tt.code)
+			if err == nil && cmd == nil {
+				t.Error("no command returned")
+			}
+		})
+	}
+}
+
+func TestParseEvent(t *testing.T) {
+	tests := []struct {
+		raw  string
+		code string
+	}{
+		{`{"type":"stream_info","battery":0,"bitrate":0,"fps":0,"viewers":0}`, ""},
+		{`{"type":"stream_info","battery":100,"thermal_state":"critical"}`, ""},
+		{`{"type":"stream_info"}`, ""},
+		{`{"type":"stream_info","battery":101}`, CodeInvalidParams},
+		{`{"type":"stream_info","battery":-1}`, CodeInvalidParams},
+		{`{"type":"stream_info","bitrate":-1}`, CodeInvalidParams},
+		{`{"type":"stream_info","fps":-1}`, CodeInvalidParams},
+		{`{"type":"stream_info","viewers":-1}`, CodeInvalidParams},
+		{`{"type":"stream_info","thermal_state":"hot"}`, CodeInvalidParams},
+		{`{"type":"status","battery":50}`, ""},
+		{`{"type":"thermal_update","thermal_state":"fair"}`, ""},
+		{`{"type":"thermal_update","thermal_state":""}`, CodeInvalidParams},
+		{`{"type":"command_ack","request_id":"req-1"}`, ""},
+		{`{"type":"command_failed","request_id":"req-1","message":"busy"}`, ""},
+		{`{"type":"command_ack"}`, CodeInvalidParams},
+		{`{"type":"scene_changed","scene":"wide"}`, ""},
+		{`{"type":"stream_info","battery":"full"}`, CodeInvalidParams},
+		{`{"type":"upload_stats","upload_stats":[]}`, CodeInvalidParams},
+		{`{"type":"battery_low"}`, CodeUnknownType},
+		{`not json`, CodeInvalidJSON},
+	}
+	for _, tt := range tests {
+		t.Run(tt.raw, func(t *testing.T) {
+			_, err := ParseEvent([]byte(tt.raw))
+			checkCode(t, err, tt.code)
+		})
+	}
+}
+
+func TestParseMessages(t *testing.T) {
+	ignore := func(_ any, err error) error { return err }
+	parsers := map[string]func([]byte) error{
+		TypeAckAlert:     func(raw []byte) error { return ignore(ParseAlertAck(raw)) },
+		TypeClaimControl: func(raw []byte) error { return ignore(ParseClaimControl(raw)) },
+		TypeRunMacro:     func(raw []byte) error { return ignore(ParseRunMacro(raw)) },
+		TypeCancelMacro:  func(raw []byte) error { return ignore(ParseCancelMacro(raw)) },
+		TypeMatchEvent:   func(raw []byte) error { return ignore(ParseMatchEvent(raw)) },
+		TypeSubscribe:    func(raw []byte) error { return ignore(ParseSubscription(raw)) },
+		TypeWait: func(raw []byte) error {
+			_, _, err := ParseMacroStep(raw)
+			return err
+		},
+	}
+	tests := []struct {
+		parser string
+		raw    string
+		code   string
+	}{
+		{TypeAckAlert, `{"type":"ack_alert","id":"alert-3"}`, ""},
+		{TypeAckAlert, `{"type":"ack_alert"}`, CodeInvalidParams},
+		{TypeAckAlert, `{"type":"ack_alert","id":3}`, CodeInvalidParams},
+		{TypeClaimControl, `{"type":"claim_control"}`, ""},
+		{TypeClaimControl, `{"type":"claim_control","name":"1234567890123456789012345678901234567890"}`, ""},
+		{TypeClaimControl, `{"type":"claim_control","name":"12345678901234567890123456789012345678901"}`, CodeInvalidParams},
+		{TypeClaimControl, `{"type":"claim_control","steal":"yes"}`, CodeInvalidParams},
+		{TypeRunMacro, `{"type":"run_macro","name":"intro"}`, ""},
+		{TypeRunMacro, `{"type":"run_macro","name":""}`, CodeInvalidParams},
+		{TypeCancelMacro, `{"type":"cancel_macro","run_id":"run-1"}`, ""},
+		{TypeCancelMacro, `{"type":"cancel_macro"}`, CodeInvalidParams},
+		{TypeMatchEvent, `{"type":"match_event","event":"side_switch"}`, ""},
+		{TypeMatchEvent, `{"type":"match_event","event":"goal"}`, CodeInvalidParams},
+		{TypeSubscribe, `{"type":"subscribe","topics":["sams","scout"]}`, ""},
+		{TypeSubscribe, `{"type":"subscribe","topics":[]}`, CodeInvalidParams},
+		{TypeSubscribe, `{"type":"subscribe","topics":["scout","chat"]}`, CodeInvalidParams},
+		{TypeSubscribe, `{"type":"subscribe","topics":"scout"}`, CodeInvalidParams},
+		{TypeWait, `{"type":"wait","seconds":1}`, ""},
+		{TypeWait, `{"type":"wait","seconds":3600}`, ""},
+		{TypeWait, `{"type":"wait","seconds":0}`, CodeInvalidParams},
+		{TypeWait, `{"type":"wait","seconds":3601}`, CodeInvalidParams},
+		{TypeWait, `{"type":"set_zoom","level":9}`, CodeInvalidParams},
+		{TypeWait, `{"seconds":5}`, CodeInvalidParams},
+	}
+	for _, tt := range tests {
+		t.Run(tt.raw, func(t *testing.T) {
+			checkCode(t, parsers[tt.parser]([]byte(tt.raw)), tt.code)
+		})
+	}
+}
+
+func TestNegotiateVersion(t *testing.T) {
+	tests := []struct {
+		requested int
+		want      int
+		code      string
+	}{
+		{MinVersion, MinVersion, ""},
+		{Version, Version, ""},
+		{Version + 1, Version, ""},
+		{MinVersion - 1, 0, CodeUnsupportedVersion},
+	}
+	for _, tt := range tests {
+		got, err := NegotiateVersion(tt.requested)
+		checkCode(t, err, tt.code)
+		if got != tt.want {
+			t.Errorf("NegotiateVersion(%d) = %d, want %d", tt.requested, got, tt.want)
+		}
+	}
+}
@@ -0,0 +1,6 @@
+package protocol
+
+// SAMS ticker message types
+const (
+	TypeSamsUpdate = "sams_update" // Relay -> Browser, Overlay
+)
@@ -0,0 +1,57 @@
+package protocol
+
+// Subscription message types (Client -> Relay)
+const (
+	TypeSubscribe   = "subscribe"
+	TypeUnsubscribe = "unsubscribe"
+	TypeSubscribed  = "subscribed"
+)
+
+// Topics group relay broadcasts so clients only receive what they asked for
+const (
+	TopicStream   = "stream"
+	TopicScout    = "scout"
+	TopicMatchday = "matchday"
+	TopicAlerts   = "alerts"
+	TopicSams     = "sams"
+)
+
+// Topics lists all topics a client can subscribe to
+var Topics = []string{TopicStream, TopicScout, TopicMatchday, TopicAlerts, TopicSams}
+
+// Subscription is the payload of subscribe/unsubscribe messages
+type Subscription struct {
+	Topics []string `json:"topics"`
+}
+
+// Validate checks that all requested topics exist
+func (s Subscription) Validate() error {
+	if len(s.Topics) == 0 {
+		return errorf(CodeInvalidParams, TypeSubscribe, "No topics given")
+	}
+	for _, topic := range s.Topics {
+		if !IsTopic(topic) {
+			return errorf(CodeInvalidParams, TypeSubscribe, "Unknown topic %q", topic)
+		}
+	}
+	return nil
+}
+
+// IsTopic reports whether topic is a known broadcast topic
+func IsTopic(topic string) bool {
+	for _, t := range Topics {
+		if t == topic {
+			return true
+		}
+	}
+	return false
+}
+
+// ParseSubscription decodes and validates a subscribe/unsubscribe message
+func ParseSubscription(raw []byte) (Subscription, error) {
+	var sub Subscription
+	if err := decode(raw, TypeSubscribe, &sub); err != nil {
+		return sub, err
+	}
+	return sub, sub.Validate()
+}
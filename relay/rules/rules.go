@@ -0,0 +1,319 @@
+// Package rules implements volleyball scoring: when sets and matches end,
+// set and match balls, side switches, serving and timeout limits. It has
+// no state of its own; MatchdayStore applies it to the live score.
+package rules
+
+import (
+	"fmt"
+	"sort"
+
+	"github.com/volleybratans/moblin-relay/models"
+	"github.com/volleybratans/moblin-relay/protocol"
+)
+
+// Teams
+const (
+	Home = "home"
+	Away = "away"
+)
+
+// DefaultFormat is used when the matchday state names no format
+const DefaultFormat = "indoor"
+
+// Format describes how a match is played
+type Format struct {
+	Name      string `json:"name"`
+	SetsToWin int    `json:"setsToWin"` // 3 for best of five, 2 for best of three
+	// Points needed to win a set and the deciding set, with MinLead
+	Points         int `json:"points"`
+	TieBreakPoints int `json:"tieBreakPoints"`
+	MinLead        int `json:"minLead"`
+	// Teams switch sides every SideSwitch points played (0: only between
+	// sets); in the deciding set every TieBreakSideSwitch points, or once
+	// when the first team reaches TieBreakSideSwitchAt
+	SideSwitch           int `json:"sideSwitch,omitempty"`
+	TieBreakSideSwitch   int `json:"tieBreakSideSwitch,omitempty"`
+	TieBreakSideSwitchAt int `json:"tieBreakSideSwitchAt,omitempty"`
+	TimeoutsPerSet       int `json:"timeoutsPerSet"`
+}
+
+// Formats are the built-in formats by name
+var Formats = map[string]Format{
+	"indoor": {
+		Name: "indoor", SetsToWin: 3, Points: 25, TieBreakPoints: 15, MinLead: 2,
+		TieBreakSideSwitchAt: 8, TimeoutsPerSet: 2,
+	},
+	"indoor-bo3": {
+		Name: "indoor-bo3", SetsToWin: 2, Points: 25, TieBreakPoints: 15, MinLead: 2,
+		TieBreakSideSwitchAt: 8, TimeoutsPerSet: 2,
+	},
+	"youth": {
+		Name: "youth", SetsToWin: 2, Points: 15, TieBreakPoints: 15, MinLead: 2,
+		TieBreakSideSwitchAt: 8, TimeoutsPerSet: 2,
+	},
+	"beach": {
+		Name: "beach", SetsToWin: 2, Points: 21, TieBreakPoints: 15, MinLead: 2,
+		SideSwitch: 7, TieBreakSideSwitch: 5, TimeoutsPerSet: 1,
+	},
+}
+
+// Lookup returns a built-in format; an empty name is DefaultFormat
+func Lookup(name string) (Format, error) {
+	if name == "" {
+		name = DefaultFormat
+	}
+	format, ok := Formats[name]
+	if !ok {
+		return Format{}, fmt.Errorf("unknown format %q", name)
+	}
+	return format, nil
+}
+
+// FormatNames returns the names of the built-in formats, sorted
+func FormatNames() []string {
+	names := make([]string, 0, len(Formats))
+	for name := range Formats {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// IsTieBreak reports whether set (1-based) is the deciding set
+func (f Format) IsTieBreak(set int) bool {
+	return set == 2*f.SetsToWin-1
+}
+
+// SetTarget returns the points needed to win set
+func (f Format) SetTarget(set int) int {
+	if f.IsTieBreak(set) {
+		return f.TieBreakPoints
+	}
+	return f.Points
+}
+
+// SetWinner returns the team that has won set at home:away, or ""
+func (f Format) SetWinner(set, home, away int) string {
+	target := f.SetTarget(set)
+	switch {
+	case home >= target && home-away >= f.MinLead:
+		return Home
+	case away >= target && away-home >= f.MinLead:
+		return Away
+	}
+	return ""
+}
+
+// MatchWinner returns the team that has won the match with these sets, or ""
+func (f Format) MatchWinner(homeSets, awaySets int) string {
+	switch {
+	case homeSets >= f.SetsToWin:
+		return Home
+	case awaySets >= f.SetsToWin:
+		return Away
+	}
+	return ""
+}
+
+// Point scores a rally won by team. The winner of a rally serves next.
+// It returns side_switch if the point requires the teams to switch sides.
+func (f Format) Point(s *models.MatchScore, team string) ([]string, error) {
+	if err := f.checkPlayable(s); err != nil {
+		return nil, err
+	}
+	if err := checkTeam(team); err != nil {
+		return nil, err
+	}
+	if team == Home {
+		s.HomePoints++
+	} else {
+		s.AwayPoints++
+	}
+	s.Serving = team
+	s.SwitchSides = f.sideSwitch(s, team)
+	if s.SwitchSides {
+		s.SidesSwitchedAt = s.HomePoints + s.AwayPoints
+	}
+	f.Annotate(s)
+
+	if s.SwitchSides {
+		return []string{protocol.MatchSideSwitch}, nil
+	}
+	return nil, nil
+}
+
+// Serve sets the team serving the first rally of the set, which decides
+// who serves first in the next set
+func (f Format) Serve(s *models.MatchScore, team string) error {
+	if s.Finished {
+		return fmt.Errorf("match is over")
+	}
+	if err := checkTeam(team); err != nil {
+		return err
+	}
+	if s.HomePoints+s.AwayPoints > 0 {
+		return fmt.Errorf("serve can only be set before the first rally of a set")
+	}
+	s.Serving = team
+	s.FirstServe = team
+	return nil
+}
+
+// RemovePoint corrects a point given by mistake. Serving is kept, as the
+// rally that led to it is unknown.
+func (f Format) RemovePoint(s *models.MatchScore, team string) error {
+	if s.Finished {
+		return fmt.Errorf("match is over")
+	}
+	if err := checkTeam(team); err != nil {
+		return err
+	}
+	points := &s.HomePoints
+	if team == Away {
+		points = &s.AwayPoints
+	}
+	if *points == 0 {
+		return fmt.Errorf("%s has no points", team)
+	}
+	*points--
+	s.SwitchSides = false
+	f.Annotate(s)
+	return nil
+}
+
+// EndSet closes the current set once a team has won it. It returns
+// set_end, followed by match_end if the set decides the match.
+func (f Format) EndSet(s *models.MatchScore) ([]string, error) {
+	if s.Finished {
+		return nil, fmt.Errorf("match is over")
+	}
+	winner := f.SetWinner(s.CurrentSet, s.HomePoints, s.AwayPoints)
+	if winner == "" {
+		return nil, fmt.Errorf("set %d is not over at %d:%d (%d points with a %d-point lead needed)",
+			s.CurrentSet, s.HomePoints, s.AwayPoints, f.SetTarget(s.CurrentSet), f.MinLead)
+	}
+
+	s.SetHistory = append(s.SetHistory, models.SetScore{Home: s.HomePoints, Away: s.AwayPoints})
+	if winner == Home {
+		s.HomeSets++
+	} else {
+		s.AwaySets++
+	}
+	events := []string{protocol.MatchSetEnd}
+	if match := f.MatchWinner(s.HomeSets, s.AwaySets); match != "" {
+		s.Finished = true
+		s.Winner = match
+		s.Serving = ""
+		events = append(events, protocol.MatchEnd)
+	} else {
+		s.CurrentSet++
+		// First serve alternates between sets; the deciding set is tossed
+		s.Serving = ""
+		if !f.IsTieBreak(s.CurrentSet) {
+			s.Serving = other(s.FirstServe)
+		}
+	}
+	s.FirstServe = s.Serving
+	s.HomePoints = 0
+	s.AwayPoints = 0
+	s.HomeTimeouts = 0
+	s.AwayTimeouts = 0
+	s.SwitchSides = false
+	s.SidesSwitchedAt = 0
+	f.Annotate(s)
+	return events, nil
+}
+
+// Timeout records a timeout for team, at most TimeoutsPerSet per set
+func (f Format) Timeout(s *models.MatchScore, team string) ([]string, error) {
+	if err := f.checkPlayable(s); err != nil {
+		return nil, err
+	}
+	if err := checkTeam(team); err != nil {
+		return nil, err
+	}
+	timeouts := &s.HomeTimeouts
+	if team == Away {
+		timeouts = &s.AwayTimeouts
+	}
+	if *timeouts >= f.TimeoutsPerSet {
+		return nil, fmt.Errorf("%s has no timeouts left in set %d", team, s.CurrentSet)
+	}
+	*timeouts++
+	return []string{protocol.MatchTimeout}, nil
+}
+
+// Annotate derives set ball, match ball and the match result from the score
+func (f Format) Annotate(s *models.MatchScore) {
+	s.SetBall = ""
+	s.MatchBall = ""
+	if s.Finished {
+		return
+	}
+	s.Winner = ""
+	if f.SetWinner(s.CurrentSet, s.HomePoints, s.AwayPoints) != "" {
+		return
+	}
+	switch {
+	case f.SetWinner(s.CurrentSet, s.HomePoints+1, s.AwayPoints) == Home:
+		s.SetBall = Home
+		if s.HomeSets+1 >= f.SetsToWin {
+			s.MatchBall = Home
+		}
+	case f.SetWinner(s.CurrentSet, s.HomePoints, s.AwayPoints+1) == Away:
+		s.SetBall = Away
+		if s.AwaySets+1 >= f.SetsToWin {
+			s.MatchBall = Away
+		}
+	}
+}
+
+// checkPlayable rejects rallies after the set or match is decided
+func (f Format) checkPlayable(s *models.MatchScore) error {
+	if s.Finished {
+		return fmt.Errorf("match is over")
+	}
+	if f.SetWinner(s.CurrentSet, s.HomePoints, s.AwayPoints) != "" {
+		return fmt.Errorf("set %d is over at %d:%d, end the set first", s.CurrentSet, s.HomePoints, s.AwayPoints)
+	}
+	return nil
+}
+
+// sideSwitch reports whether the point just scored by team requires a
+// side switch. A switch that already happened in the set, before a point
+// was corrected, is not announced again.
+func (f Format) sideSwitch(s *models.MatchScore, team string) bool {
+	played := s.HomePoints + s.AwayPoints
+	if played <= s.SidesSwitchedAt {
+		return false
+	}
+	if f.IsTieBreak(s.CurrentSet) {
+		if f.TieBreakSideSwitchAt > 0 {
+			scored, opponent := s.HomePoints, s.AwayPoints
+			if team == Away {
+				scored, opponent = opponent, scored
+			}
+			return s.SidesSwitchedAt == 0 && scored == f.TieBreakSideSwitchAt && opponent < f.TieBreakSideSwitchAt
+		}
+		return f.TieBreakSideSwitch > 0 && played%f.TieBreakSideSwitch == 0
+	}
+	return f.SideSwitch > 0 && played%f.SideSwitch == 0
+}
+
+func checkTeam(team string) error {
+	if team != Home && team != Away {
+		return fmt.Errorf("team must be home or away")
+	}
+	return nil
+}
+
+// other returns the opposing team, or "" if team is unknown
+func other(team string) string {
+	switch team {
+	case Home:
+		return Away
+	case Away:
+		return Home
+	}
+	return ""
+}
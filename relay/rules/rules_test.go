@@ -0,0 +1,489 @@
+package rules
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/volleybratans/moblin-relay/models"
+	"github.com/volleybratans/moblin-relay/protocol"
+)
+
+func format(t *testing.T, name string) Format {
+	t.Helper()
+	f, err := Lookup(name)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return f
+}
+
+// score returns a score in set with the given sets and points
+func score(set, homeSets, awaySets, home, away int) models.MatchScore {
+	return models.MatchScore{
+		CurrentSet: set,
+		HomeSets:   homeSets,
+		AwaySets:   awaySets,
+		HomePoints: home,
+		AwayPoints: away,
+		SetHistory: []models.SetScore{},
+	}
+}
+
+// play scores rallies given as "h" (home) and "a" (away) and returns the
+// 1-based rallies that raised side_switch
+func play(t *testing.T, f Format, s *models.MatchScore, rallies string) []int {
+	t.Helper()
+	var switches []int
+	for i, r := range rallies {
+		team := Home
+		if r == 'a' {
+			team = Away
+		}
+		events, err := f.Point(s, team)
+		if err != nil {
+			t.Fatalf("rally %d: %v", i+1, err)
+		}
+		if len(events) > 0 {
+			if events[0] != protocol.MatchSideSwitch {
+				t.Fatalf("rally %d: unexpected events %v", i+1, events)
+			}
+			switches = append(switches, i+1)
+		}
+	}
+	return switches
+}
+
+func TestLookup(t *testing.T) {
+	if f := format(t, ""); f.Name != DefaultFormat {
+		t.Errorf("empty format = %q, want %q", f.Name, DefaultFormat)
+	}
+	if _, err := Lookup("squash"); err == nil {
+		t.Error("unknown format accepted")
+	}
+	want := []string{"beach", "indoor", "indoor-bo3", "youth"}
+	if got := FormatNames(); !reflect.DeepEqual(got, want) {
+		t.Errorf("FormatNames() = %v, want %v", got, want)
+	}
+}
+
+func TestSetWinner(t *testing.T) {
+	tests := []struct {
+		format     string
+		set        int
+		home, away int
+		want       string
+	}{
+		{"indoor", 1, 24, 20, ""},
+		{"indoor", 1, 25, 23, Home},
+		{"indoor", 1, 25, 24, ""},
+		{"indoor", 1, 24, 24, ""},
+		{"indoor", 1, 26, 24, Home},
+		{"indoor", 1, 30, 32, Away},
+		{"indoor", 4, 23, 25, Away},
+		{"indoor", 5, 15, 13, Home},
+		{"indoor", 5, 14, 14, ""},
+		{"indoor", 5, 15, 14, ""},
+		{"indoor", 5, 14, 16, Away},
+		{"indoor-bo3", 2, 15, 10, ""},
+		{"indoor-bo3", 3, 15, 10, Home},
+		{"indoor-bo3", 3, 14, 14, ""},
+		{"youth", 1, 15, 13, Home},
+		{"youth", 1, 15, 14, ""},
+		{"youth", 3, 17, 15, Home},
+		{"beach", 1, 21, 19, Home},
+		{"beach", 1, 21, 20, ""},
+		{"beach", 2, 20, 22, Away},
+		{"beach", 3, 15, 13, Home},
+		{"beach", 3, 14, 14, ""},
+	}
+	for _, tt := range tests {
+		f := format(t, tt.format)
+		if got := f.SetWinner(tt.set, tt.home, tt.away); got != tt.want {
+			t.Errorf("%s set %d %d:%d: winner %q, want %q", tt.format, tt.set, tt.home, tt.away, got, tt.want)
+		}
+	}
+}
+
+func TestMatchWinner(t *testing.T) {
+	tests := []struct {
+		format     string
+		home, away int
+		want       string
+	}{
+		{"indoor", 2, 2, ""},
+		{"indoor", 3, 1, Home},
+		{"indoor", 0, 3, Away},
+		{"indoor-bo3", 1, 1, ""},
+		{"indoor-bo3", 2, 0, Home},
+		{"youth", 1, 2, Away},
+		{"beach", 2, 1, Home},
+	}
+	for _, tt := range tests {
+		if got := format(t, tt.format).MatchWinner(tt.home, tt.away); got != tt.want {
+			t.Errorf("%s sets %d:%d: winner %q, want %q", tt.format, tt.home, tt.away, got, tt.want)
+		}
+	}
+}
+
+func TestEndSet(t *testing.T) {
+	tests := []struct {
+		name         string
+		format       string
+		score        models.MatchScore
+		wantErr      string
+		wantEvents   []string
+		wantSets     [2]int
+		wantSet      int
+		wantFinished string // winner once the match is over
+	}{
+		{name: "deuce", format: "indoor", score: score(1, 0, 0, 24, 24), wantErr: "not over"},
+		{name: "one point lead", format: "indoor", score: score(1, 0, 0, 25, 24), wantErr: "not over"},
+		{name: "short of target", format: "indoor", score: score(1, 0, 0, 20, 10), wantErr: "not over"},
+		{name: "two point lead after deuce", format: "indoor", score: score(1, 0, 0, 26, 24),
+			wantEvents: []string{protocol.MatchSetEnd}, wantSets: [2]int{1, 0}, wantSet: 2},
+		{name: "away wins set", format: "indoor", score: score(2, 1, 0, 21, 25),
+			wantEvents: []string{protocol.MatchSetEnd}, wantSets: [2]int{1, 1}, wantSet: 3},
+		{name: "match end 3:0", format: "indoor", score: score(3, 2, 0, 25, 17),
+			wantEvents: []string{protocol.MatchSetEnd, protocol.MatchEnd}, wantSets: [2]int{3, 0}, wantSet: 3, wantFinished: Home},
+		{name: "tie-break deuce", format: "indoor", score: score(5, 2, 2, 14, 14), wantErr: "not over"},
+		{name: "tie-break to 15", format: "indoor", score: score(5, 2, 2, 13, 15),
+			wantEvents: []string{protocol.MatchSetEnd, protocol.MatchEnd}, wantSets: [2]int{2, 3}, wantSet: 5, wantFinished: Away},
+		{name: "best of three first set", format: "indoor-bo3", score: score(1, 0, 0, 25, 20),
+			wantEvents: []string{protocol.MatchSetEnd}, wantSets: [2]int{1, 0}, wantSet: 2},
+		{name: "best of three match end", format: "indoor-bo3", score: score(2, 1, 0, 25, 20),
+			wantEvents: []string{protocol.MatchSetEnd, protocol.MatchEnd}, wantSets: [2]int{2, 0}, wantSet: 2, wantFinished: Home},
+		{name: "youth set to 15", format: "youth", score: score(1, 0, 0, 15, 13),
+			wantEvents: []string{protocol.MatchSetEnd}, wantSets: [2]int{1, 0}, wantSet: 2},
+		{name: "youth 15:14", format: "youth", score: score(1, 0, 0, 15, 14), wantErr: "not over"},
+		{name: "beach set to 21", format: "beach", score: score(1, 0, 0, 19, 21),
+			wantEvents: []string{protocol.MatchSetEnd}, wantSets: [2]int{0, 1}, wantSet: 2},
+		{name: "beach tie-break", format: "beach", score: score(3, 1, 1, 15, 12),
+			wantEvents: []string{protocol.MatchSetEnd, protocol.MatchEnd}, wantSets: [2]int{2, 1}, wantSet: 3, wantFinished: Home},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			f := format(t, tt.format)
+			s := tt.score
+			events, err := f.EndSet(&s)
+			if tt.wantErr != "" {
+				if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+					t.Fatalf("error %v, want %q", err, tt.wantErr)
+				}
+				if !reflect.DeepEqual(s, tt.score) {
+					t.Errorf("rejected EndSet changed the score: %+v", s)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !reflect.DeepEqual(events, tt.wantEvents) {
+				t.Errorf("events %v, want %v", events, tt.wantEvents)
+			}
+			if got := [2]int{s.HomeSets, s.AwaySets}; got != tt.wantSets {
+				t.Errorf("sets %v, want %v", got, tt.wantSets)
+			}
+			if s.CurrentSet != tt.wantSet {
+				t.Errorf("current set %d, want %d", s.CurrentSet, tt.wantSet)
+			}
+			if s.Finished != (tt.wantFinished != "") || s.Winner != tt.wantFinished {
+				t.Errorf("finished %v winner %q, want winner %q", s.Finished, s.Winner, tt.wantFinished)
+			}
+			last := s.SetHistory[len(s.SetHistory)-1]
+			if last.Home != tt.score.HomePoints || last.Away != tt.score.AwayPoints {
+				t.Errorf("set history %+v, want %d:%d", last, tt.score.HomePoints, tt.score.AwayPoints)
+			}
+			if s.HomePoints != 0 || s.AwayPoints != 0 {
+				t.Errorf("points %d:%d after set end, want 0:0", s.HomePoints, s.AwayPoints)
+			}
+			if tt.wantFinished != "" {
+				if _, err := f.EndSet(&s); err == nil {
+					t.Error("EndSet accepted after match end")
+				}
+			}
+		})
+	}
+}
+
+func TestServeAlternation(t *testing.T) {
+	tests := []struct {
+		format string
+		first  string
+		// team serving first in each set, "" for the tossed deciding set
+		want []string
+	}{
+		{"indoor", Home, []string{Home, Away, Home, Away, ""}},
+		{"indoor", Away, []string{Away, Home, Away, Home, ""}},
+		{"indoor-bo3", Away, []string{Away, Home, ""}},
+		{"youth", Home, []string{Home, Away, ""}},
+		{"beach", Home, []string{Home, Away, ""}},
+	}
+	for _, tt := range tests {
+		f := format(t, tt.format)
+		s := score(1, 0, 0, 0, 0)
+		if err := f.Serve(&s, tt.first); err != nil {
+			t.Fatal(err)
+		}
+		for i, want := range tt.want {
+			if s.Serving != want || s.FirstServe != want {
+				t.Errorf("%s set %d: serving %q first serve %q, want %q", tt.format, i+1, s.Serving, s.FirstServe, want)
+			}
+			if i == len(tt.want)-1 {
+				break
+			}
+			// Alternate set winners so the match reaches the deciding set
+			winner := Home
+			if i%2 == 1 {
+				winner = Away
+			}
+			for s.HomePoints+s.AwayPoints < f.SetTarget(s.CurrentSet) {
+				f.Point(&s, winner)
+			}
+			if _, err := f.EndSet(&s); err != nil {
+				t.Fatalf("%s set %d: %v", tt.format, i+1, err)
+			}
+		}
+		if !f.IsTieBreak(s.CurrentSet) {
+			t.Errorf("%s: ended in set %d, want the deciding set", tt.format, s.CurrentSet)
+		}
+	}
+}
+
+func TestServe(t *testing.T) {
+	f := format(t, "indoor")
+	s := score(1, 0, 0, 0, 0)
+	if err := f.Serve(&s, "nobody"); err == nil {
+		t.Error("Serve accepted an unknown team")
+	}
+	play(t, f, &s, "a")
+	if s.Serving != Away {
+		t.Errorf("serving %q after away won the rally, want away", s.Serving)
+	}
+	if err := f.Serve(&s, Home); err == nil {
+		t.Error("Serve accepted after the first rally")
+	}
+}
+
+func TestSideSwitch(t *testing.T) {
+	tests := []struct {
+		name    string
+		format  string
+		set     int
+		rallies string
+		want    []int
+	}{
+		{"indoor regular set never switches", "indoor", 1, strings.Repeat("ha", 12), nil},
+		{"indoor tie-break at 8", "indoor", 5, "hhhhhhhh", []int{8}},
+		{"indoor tie-break away reaches 8 first", "indoor", 5, "hahahaha" + "aaaa", []int{12}},
+		{"indoor tie-break only once", "indoor", 5, "hhhhhhhh" + "aaaaaaaa" + "hh", []int{8}},
+		{"indoor-bo3 tie-break at 8", "indoor-bo3", 3, "aaaaaaaa", []int{8}},
+		{"indoor-bo3 regular set", "indoor-bo3", 2, "aaaaaaaaaaaaaaa", nil},
+		{"youth tie-break at 8", "youth", 3, "hahahahahahahah", []int{15}},
+		{"beach every 7", "beach", 1, strings.Repeat("ha", 10) + "h", []int{7, 14, 21}},
+		{"beach every 7 in set 2", "beach", 2, strings.Repeat("a", 14), []int{7, 14}},
+		{"beach tie-break every 5", "beach", 3, strings.Repeat("ha", 7), []int{5, 10}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			f := format(t, tt.format)
+			s := score(tt.set, 0, 0, 0, 0)
+			if got := play(t, f, &s, tt.rallies); !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("side switches at rallies %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestSideSwitchAfterCorrection(t *testing.T) {
+	tests := []struct {
+		name    string
+		format  string
+		set     int
+		before  string // rallies before the correction
+		initial []int  // switches among the rallies before
+		remove  string // team whose point is removed
+		after   string // rallies after the correction
+		want    []int  // switches among the rallies after
+	}{
+		{"tie-break 8:5 to 7:5 to 8:5", "indoor", 5, "hhhhhhhhaaaaa", []int{8}, Home, "h", nil},
+		{"tie-break correction before the switch", "indoor", 5, "hhhhhhh", nil, Home, "hh", []int{2}},
+		{"beach 7 to 6 to 7", "beach", 1, "hhhhhhh", []int{7}, Home, "h", nil},
+		{"beach next switch at 14", "beach", 1, "hhhhhhh", []int{7}, Home, "hhhhhhhh", []int{8}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			f := format(t, tt.format)
+			s := score(tt.set, 0, 0, 0, 0)
+			if got := play(t, f, &s, tt.before); !reflect.DeepEqual(got, tt.initial) {
+				t.Fatalf("side switches before correction %v, want %v", got, tt.initial)
+			}
+			if err := f.RemovePoint(&s, tt.remove); err != nil {
+				t.Fatal(err)
+			}
+			if s.SwitchSides {
+				t.Error("switchSides still set after the correction")
+			}
+			if got := play(t, f, &s, tt.after); !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("side switches after correction %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestSideSwitchResetsEachSet(t *testing.T) {
+	f := format(t, "beach")
+	s := score(1, 0, 0, 0, 0)
+	play(t, f, &s, strings.Repeat("h", 21))
+	if _, err := f.EndSet(&s); err != nil {
+		t.Fatal(err)
+	}
+	if s.SidesSwitchedAt != 0 {
+		t.Errorf("sidesSwitchedAt %d in a new set, want 0", s.SidesSwitchedAt)
+	}
+	if got := play(t, f, &s, strings.Repeat("a", 7)); !reflect.DeepEqual(got, []int{7}) {
+		t.Errorf("side switches in set 2 %v, want [7]", got)
+	}
+}
+
+func TestAnnotate(t *testing.T) {
+	tests := []struct {
+		name      string
+		format    string
+		score     models.MatchScore
+		setBall   string
+		matchBall string
+	}{
+		{"no set ball", "indoor", score(1, 0, 0, 23, 20), "", ""},
+		{"home set ball", "indoor", score(1, 0, 0, 24, 20), Home, ""},
+		{"away set ball", "indoor", score(2, 1, 0, 22, 24), Away, ""},
+		{"deuce", "indoor", score(1, 0, 0, 24, 24), "", ""},
+		{"advantage", "indoor", score(1, 0, 0, 25, 24), Home, ""},
+		{"set won", "indoor", score(1, 0, 0, 25, 23), "", ""},
+		{"home match ball", "indoor", score(3, 2, 0, 24, 10), Home, Home},
+		{"away set ball, home leads sets", "indoor", score(3, 2, 0, 10, 24), Away, ""},
+		{"tie-break match ball", "indoor", score(5, 2, 2, 14, 13), Home, Home},
+		{"tie-break deuce", "indoor", score(5, 2, 2, 14, 14), "", ""},
+		{"best of three match ball", "indoor-bo3", score(2, 0, 1, 20, 24), Away, Away},
+		{"youth set ball at 14", "youth", score(1, 0, 0, 14, 10), Home, ""},
+		{"youth match ball", "youth", score(2, 1, 0, 14, 12), Home, Home},
+		{"beach set ball at 20", "beach", score(1, 0, 0, 20, 18), Home, ""},
+		{"beach match ball", "beach", score(2, 1, 0, 20, 19), Home, Home},
+		{"beach tie-break match ball", "beach", score(3, 1, 1, 12, 14), Away, Away},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s := tt.score
+			s.SetBall, s.MatchBall = "stale", "stale"
+			format(t, tt.format).Annotate(&s)
+			if s.SetBall != tt.setBall || s.MatchBall != tt.matchBall {
+				t.Errorf("set ball %q match ball %q, want %q %q", s.SetBall, s.MatchBall, tt.setBall, tt.matchBall)
+			}
+		})
+	}
+}
+
+func TestTimeout(t *testing.T) {
+	tests := []struct {
+		format string
+		team   string
+		taken  int // timeouts taken before
+		ok     bool
+	}{
+		{"indoor", Home, 0, true},
+		{"indoor", Home, 1, true},
+		{"indoor", Home, 2, false},
+		{"indoor", Away, 2, false},
+		{"indoor-bo3", Away, 1, true},
+		{"youth", Home, 2, false},
+		{"beach", Home, 0, true},
+		{"beach", Away, 1, false},
+	}
+	for _, tt := range tests {
+		f := format(t, tt.format)
+		s := score(1, 0, 0, 5, 5)
+		s.HomeTimeouts, s.AwayTimeouts = tt.taken, tt.taken
+		events, err := f.Timeout(&s, tt.team)
+		if (err == nil) != tt.ok {
+			t.Errorf("%s %s after %d timeouts: error %v, want ok %v", tt.format, tt.team, tt.taken, err, tt.ok)
+			continue
+		}
+		if tt.ok && !reflect.DeepEqual(events, []string{protocol.MatchTimeout}) {
+			t.Errorf("%s: events %v, want timeout", tt.format, events)
+		}
+	}
+}
+
+func TestTimeoutsResetEachSet(t *testing.T) {
+	f := format(t, "indoor")
+	s := score(1, 0, 0, 0, 0)
+	for i := 0; i < f.TimeoutsPerSet; i++ {
+		if _, err := f.Timeout(&s, Away); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if _, err := f.Timeout(&s, Away); err == nil {
+		t.Fatal("timeout over the limit accepted")
+	}
+	play(t, f, &s, strings.Repeat("a", 25))
+	if _, err := f.Timeout(&s, Home); err == nil {
+		t.Error("timeout accepted after the set was won")
+	}
+	if _, err := f.EndSet(&s); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := f.Timeout(&s, Away); err != nil {
+		t.Errorf("timeout in the next set: %v", err)
+	}
+}
+
+func TestPointRejected(t *testing.T) {
+	finished := score(3, 3, 0, 0, 0)
+	finished.Finished, finished.Winner = true, Home
+	tests := []struct {
+		name    string
+		format  string
+		score   models.MatchScore
+		team    string
+		wantErr string
+	}{
+		{"set won", "indoor", score(1, 0, 0, 25, 23), Away, "end the set first"},
+		{"set won after deuce", "indoor", score(1, 0, 0, 27, 29), Home, "end the set first"},
+		{"tie-break won", "indoor", score(5, 2, 2, 15, 10), Home, "end the set first"},
+		{"youth set won", "youth", score(1, 0, 0, 15, 9), Home, "end the set first"},
+		{"beach set won", "beach", score(1, 0, 0, 21, 15), Away, "end the set first"},
+		{"match over", "indoor", finished, Home, "match is over"},
+		{"unknown team", "indoor", score(1, 0, 0, 0, 0), "nobody", "home or away"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s := tt.score
+			_, err := format(t, tt.format).Point(&s, tt.team)
+			if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+				t.Fatalf("error %v, want %q", err, tt.wantErr)
+			}
+			if !reflect.DeepEqual(s, tt.score) {
+				t.Errorf("rejected point changed the score: %+v", s)
+			}
+		})
+	}
+}
+
+func TestRemovePoint(t *testing.T) {
+	f := format(t, "indoor")
+	s := score(1, 0, 0, 24, 0)
+	s.Serving = Home
+	if err := f.RemovePoint(&s, Away); err == nil {
+		t.Error("removed a point from a team without points")
+	}
+	if err := f.RemovePoint(&s, Home); err != nil {
+		t.Fatal(err)
+	}
+	if s.HomePoints != 23 || s.Serving != Home {
+		t.Errorf("score %d serving %q, want 23 and serving kept", s.HomePoints, s.Serving)
+	}
+	f.Annotate(&s)
+	if s.SetBall != "" {
+		t.Errorf("set ball %q at 23:0", s.SetBall)
+	}
+}
@@ -0,0 +1,33 @@
+/**
+ * SAMS - Broadcasts the official ticker data polled by the SAMS service
+ * on the sams topic, so browsers and overlays share one poller
+ */
+
+package main
+
+import (
+	"encoding/json"
+
+	"github.com/volleybratans/moblin-relay/models"
+	"github.com/volleybratans/moblin-relay/protocol"
+)
+
+// broadcastSams sends changed ticker data to subscribers
+func (r *Relay) broadcastSams(match models.SamsMatch) {
+	data, _ := json.Marshal(match)
+	msg, _ := json.Marshal(Message{Type: protocol.TypeSamsUpdate, Data: data})
+	r.Broadcast(protocol.TopicSams, msg)
+}
+
+// sendSams sends the cached ticker data so late joiners don't wait for the next change
+func (c *Client) sendSams() {
+	if c.Relay.sams == nil || !c.IsSubscribed(protocol.TopicSams) {
+		return
+	}
+	match := c.Relay.sams.Status().Match
+	if match == nil {
+		return
+	}
+	data, _ := json.Marshal(match)
+	c.sendJSON(Message{Type: protocol.TypeSamsUpdate, Data: data})
+}
@@ -0,0 +1,479 @@
+package services
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/volleybratans/moblin-relay/models"
+	"github.com/volleybratans/moblin-relay/protocol"
+)
+
+// Alert severities
+const (
+	SeverityInfo     = "info"
+	SeverityWarning  = "warning"
+	SeverityCritical = "critical"
+)
+
+// Alert states
+const (
+	AlertFiring   = "firing"
+	AlertResolved = "resolved"
+)
+
+// Alert rule metrics
+const (
+	MetricBattery      = "battery"
+	MetricBitrate      = "bitrate"
+	MetricViewers      = "viewers"
+	MetricThermal      = "thermal"
+	MetricDisconnected = "disconnected"
+	MetricLinkRTT      = "link_rtt"    // relay <-> Moblin ping round trip in ms
+	MetricLinkJitter   = "link_jitter" // ms
+)
+
+const (
+	// alertDedupWindow reopens a resolved alert instead of raising a new
+	// one if the rule fires again this soon (e.g. battery flapping 19/20%)
+	alertDedupWindow = 2 * time.Minute
+
+	// resolved alerts are forgotten after alertHistory
+	alertHistory = time.Hour
+)
+
+func intPtr(v int) *int { return &v }
+
+// DefaultAlertRules are written to alert_rules.json on first start
+var DefaultAlertRules = []models.AlertRule{
+	{ID: "battery_low", Metric: MetricBattery, Below: intPtr(20), Severity: SeverityWarning},
+	{ID: "battery_critical", Metric: MetricBattery, Below: intPtr(10), Severity: SeverityCritical},
+	{ID: "thermal_serious", Metric: MetricThermal, States: []string{"serious"}, Severity: SeverityWarning},
+	{ID: "thermal_critical", Metric: MetricThermal, States: []string{"critical"}, Severity: SeverityCritical},
+	{ID: "bitrate_low", Metric: MetricBitrate, Below: intPtr(1500), For: 10, LiveOnly: true, Severity: SeverityWarning},
+	{ID: "moblin_disconnected", Metric: MetricDisconnected, For: 15, Severity: SeverityCritical},
+	{ID: "no_viewers", Metric: MetricViewers, Below: intPtr(1), For: 30, LiveOnly: true, Severity: SeverityInfo},
+	{ID: "link_rtt_high", Metric: MetricLinkRTT, Above: intPtr(500), For: 20, Severity: SeverityWarning},
+	{ID: "link_jitter_high", Metric: MetricLinkJitter, Above: intPtr(250), For: 20, Severity: SeverityWarning},
+}
+
+// deviceTelemetry is what the alert rules are evaluated against. Metrics
+// only count once Moblin has reported them, so a missing battery level
+// is not mistaken for 0%.
+type deviceTelemetry struct {
+	values         map[string]int
+	thermal        string
+	live           bool
+	connected      bool
+	disconnectedAt time.Time
+}
+
+// AlertService evaluates alert rules over Moblin telemetry
+type AlertService struct {
+	file      string
+	rules     []models.AlertRule
+	devices   map[string]*deviceTelemetry
+	pending   map[string]time.Time // rule/device -> condition true since
+	alerts    map[string]*models.Alert
+	resolved  map[string]time.Time
+	nextID    int
+	listeners []func(models.Alert)
+	mu        sync.Mutex
+}
+
+// NewAlertService creates an alert service with rules from <dataDir>/alert_rules.json
+func NewAlertService(dataDir string) *AlertService {
+	s := &AlertService{
+		file:     filepath.Join(dataDir, "alert_rules.json"),
+		rules:    DefaultAlertRules,
+		devices:  make(map[string]*deviceTelemetry),
+		pending:  make(map[string]time.Time),
+		alerts:   make(map[string]*models.Alert),
+		resolved: make(map[string]time.Time),
+	}
+	s.load()
+	go s.evaluateLoop()
+	return s
+}
+
+func (s *AlertService) load() {
+	data, err := os.ReadFile(s.file)
+	if err != nil {
+		s.save()
+		return
+	}
+	var rules []models.AlertRule
+	if err := json.Unmarshal(data, &rules); err != nil || ValidateAlertRules(rules) != nil {
+		return
+	}
+	s.rules = rules
+}
+
+func (s *AlertService) save() error {
+	data, err := json.MarshalIndent(s.rules, "", "  ")
+	if err != nil {
+		return err
+	}
+	return os.WriteFile(s.file, data, 0644)
+}
+
+// OnAlert registers a callback for fired, resolved and acknowledged alerts
+func (s *AlertService) OnAlert(fn func(alert models.Alert)) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.listeners = append(s.listeners, fn)
+}
+
+func (s *AlertService) notify(alerts []models.Alert) {
+	s.mu.Lock()
+	listeners := s.listeners
+	s.mu.Unlock()
+	for _, alert := range alerts {
+		for _, fn := range listeners {
+			fn(alert)
+		}
+	}
+}
+
+// Rules returns the configured alert rules
+func (s *AlertService) Rules() []models.AlertRule {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return append([]models.AlertRule(nil), s.rules...)
+}
+
+// SetRules validates, applies and saves new alert rules
+func (s *AlertService) SetRules(rules []models.AlertRule) error {
+	if err := ValidateAlertRules(rules); err != nil {
+		return err
+	}
+	s.mu.Lock()
+	s.rules = rules
+	s.pending = make(map[string]time.Time)
+	err := s.save()
+
+	// Alerts of removed or disabled rules would never resolve otherwise
+	active := make(map[string]bool)
+	for _, rule := range rules {
+		active[rule.ID] = !rule.Disabled
+	}
+	now := time.Now()
+	var changed []models.Alert
+	for key, alert := range s.alerts {
+		if alert.State == AlertFiring && !active[alert.Rule] {
+			alert.State = AlertResolved
+			alert.ResolvedAt = now.UTC().Format(time.RFC3339)
+			s.resolved[key] = now
+			changed = append(changed, *alert)
+		}
+	}
+	s.mu.Unlock()
+
+	s.notify(changed)
+	return err
+}
+
+// ValidateAlertRules checks that rules are complete and IDs are unique
+func ValidateAlertRules(rules []models.AlertRule) error {
+	seen := make(map[string]bool)
+	for _, rule := range rules {
+		if rule.ID == "" {
+			return fmt.Errorf("rule without id")
+		}
+		if seen[rule.ID] {
+			return fmt.Errorf("duplicate rule id %q", rule.ID)
+		}
+		seen[rule.ID] = true
+		switch rule.Severity {
+		case SeverityInfo, SeverityWarning, SeverityCritical:
+		default:
+			return fmt.Errorf("rule %q: invalid severity %q", rule.ID, rule.Severity)
+		}
+		if rule.For < 0 {
+			return fmt.Errorf("rule %q: for must not be negative", rule.ID)
+		}
+		switch rule.Metric {
+		case MetricBattery, MetricBitrate, MetricViewers, MetricLinkRTT, MetricLinkJitter:
+			if rule.Below == nil && rule.Above == nil {
+				return fmt.Errorf("rule %q: below or above is required", rule.ID)
+			}
+		case MetricThermal:
+			if len(rule.States) == 0 {
+				return fmt.Errorf("rule %q: states are required", rule.ID)
+			}
+		case MetricDisconnected:
+		default:
+			return fmt.Errorf("rule %q: unknown metric %q", rule.ID, rule.Metric)
+		}
+	}
+	return nil
+}
+
+// Alerts returns firing and recently resolved alerts, newest first
+func (s *AlertService) Alerts() []models.Alert {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	alerts := make([]models.Alert, 0, len(s.alerts))
+	for _, alert := range s.alerts {
+		alerts = append(alerts, *alert)
+	}
+	sort.Slice(alerts, func(i, j int) bool {
+		return alerts[i].FiredAt > alerts[j].FiredAt
+	})
+	return alerts
+}
+
+// Active returns all firing alerts
+func (s *AlertService) Active() []models.Alert {
+	var active []models.Alert
+	for _, alert := range s.Alerts() {
+		if alert.State == AlertFiring {
+			active = append(active, alert)
+		}
+	}
+	return active
+}
+
+// Acknowledge marks an alert as handled
+func (s *AlertService) Acknowledge(id string) (models.Alert, error) {
+	s.mu.Lock()
+	var found *models.Alert
+	for _, alert := range s.alerts {
+		if alert.ID == id {
+			found = alert
+			break
+		}
+	}
+	if found == nil {
+		s.mu.Unlock()
+		return models.Alert{}, fmt.Errorf("unknown alert %q", id)
+	}
+	found.Acknowledged = true
+	alert := *found
+	s.mu.Unlock()
+
+	s.notify([]models.Alert{alert})
+	return alert, nil
+}
+
+// SetConnected records a Moblin device connecting or disconnecting.
+// Rules are evaluated by the next tick of evaluateLoop.
+func (s *AlertService) SetConnected(device string, connected bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	d := s.device(device)
+	d.connected = connected
+	if !connected {
+		d.disconnectedAt = time.Now()
+		d.live = false
+		// The next connection is measured from scratch
+		delete(d.values, MetricLinkRTT)
+		delete(d.values, MetricLinkJitter)
+	}
+}
+
+// ObserveLink updates the round trip and jitter of the relay's connection
+// to a Moblin device and evaluates the rules
+func (s *AlertService) ObserveLink(device string, rttMs, jitterMs int) {
+	s.mu.Lock()
+	d := s.device(device)
+	d.values[MetricLinkRTT] = rttMs
+	d.values[MetricLinkJitter] = jitterMs
+	changed := s.evaluate(device, d, time.Now())
+	s.mu.Unlock()
+
+	s.notify(changed)
+}
+
+// Observe updates a device's telemetry from a Moblin event and evaluates the rules
+func (s *AlertService) Observe(device string, ev protocol.Event) {
+	s.mu.Lock()
+	d := s.device(device)
+	switch e := ev.(type) {
+	case *protocol.StreamInfo:
+		setMetric(d.values, MetricBattery, e.Battery)
+		setMetric(d.values, MetricBitrate, e.Bitrate)
+		setMetric(d.values, MetricViewers, e.Viewers)
+		if e.ThermalState != nil {
+			d.thermal = *e.ThermalState
+		}
+	case *protocol.ThermalUpdate:
+		d.thermal = e.ThermalState
+	case *protocol.StreamStarted:
+		d.live = true
+	case *protocol.StreamEnded:
+		d.live = false
+	default:
+		s.mu.Unlock()
+		return
+	}
+	changed := s.evaluate(device, d, time.Now())
+	s.mu.Unlock()
+
+	s.notify(changed)
+}
+
+// device returns the telemetry of a device, creating it if needed.
+// Caller must hold s.mu.
+func (s *AlertService) device(name string) *deviceTelemetry {
+	d, ok := s.devices[name]
+	if !ok {
+		d = &deviceTelemetry{values: make(map[string]int), connected: true}
+		s.devices[name] = d
+	}
+	return d
+}
+
+func setMetric(values map[string]int, metric string, v *int) {
+	if v != nil {
+		values[metric] = *v
+	}
+}
+
+// evaluateLoop re-evaluates all devices every second so that "for"
+// durations and disconnects fire without new telemetry
+func (s *AlertService) evaluateLoop() {
+	for range time.NewTicker(time.Second).C {
+		now := time.Now()
+		var changed []models.Alert
+		s.mu.Lock()
+		for name, d := range s.devices {
+			changed = append(changed, s.evaluate(name, d, now)...)
+		}
+		for key, at := range s.resolved {
+			if now.Sub(at) > alertHistory {
+				delete(s.alerts, key)
+				delete(s.resolved, key)
+			}
+		}
+		s.mu.Unlock()
+		s.notify(changed)
+	}
+}
+
+// evaluate checks all rules for a device and returns alerts that changed.
+// Caller must hold s.mu.
+func (s *AlertService) evaluate(device string, d *deviceTelemetry, now time.Time) []models.Alert {
+	var changed []models.Alert
+	for _, rule := range s.rules {
+		if rule.Disabled {
+			continue
+		}
+		key := rule.ID + "/" + device
+		if rule.Metric != MetricDisconnected && !d.connected {
+			// Telemetry is stale while offline; keep alerts as they are
+			delete(s.pending, key)
+			continue
+		}
+		value, holds := ruleHolds(rule, d)
+		if !holds {
+			delete(s.pending, key)
+			if alert, ok := s.alerts[key]; ok && alert.State == AlertFiring {
+				alert.State = AlertResolved
+				alert.ResolvedAt = now.UTC().Format(time.RFC3339)
+				s.resolved[key] = now
+				changed = append(changed, *alert)
+			}
+			continue
+		}
+
+		since, ok := s.pending[key]
+		if !ok {
+			since = now
+			if rule.Metric == MetricDisconnected {
+				since = d.disconnectedAt
+			}
+			s.pending[key] = since
+		}
+		if now.Sub(since) < time.Duration(rule.For)*time.Second {
+			continue
+		}
+		if alert := s.fire(key, rule, device, value, now); alert != nil {
+			changed = append(changed, *alert)
+		}
+	}
+	return changed
+}
+
+// fire raises an alert unless it is already firing. Caller must hold s.mu.
+func (s *AlertService) fire(key string, rule models.AlertRule, device, value string, now time.Time) *models.Alert {
+	alert, ok := s.alerts[key]
+	if ok && alert.State == AlertFiring {
+		return nil
+	}
+	if ok && now.Sub(s.resolved[key]) < alertDedupWindow {
+		// Same problem again: keep the ID and acknowledgement
+		alert.Count++
+	} else {
+		s.nextID++
+		alert = &models.Alert{
+			ID:     "alert-" + strconv.Itoa(s.nextID),
+			Rule:   rule.ID,
+			Device: device,
+			Count:  1,
+		}
+		s.alerts[key] = alert
+	}
+	delete(s.resolved, key)
+	alert.Severity = rule.Severity
+	alert.State = AlertFiring
+	alert.Value = value
+	alert.Message = alertMessage(rule, device, value)
+	alert.FiredAt = now.UTC().Format(time.RFC3339)
+	alert.ResolvedAt = ""
+	return alert
+}
+
+// ruleHolds reports whether a rule's condition is met and the value that triggered it
+func ruleHolds(rule models.AlertRule, d *deviceTelemetry) (string, bool) {
+	if rule.Metric == MetricDisconnected {
+		return "", !d.connected
+	}
+	if rule.LiveOnly && !d.live {
+		return "", false
+	}
+	if rule.Metric == MetricThermal {
+		for _, state := range rule.States {
+			if d.thermal == state {
+				return d.thermal, true
+			}
+		}
+		return "", false
+	}
+	v, ok := d.values[rule.Metric]
+	if !ok {
+		return "", false
+	}
+	if (rule.Below != nil && v < *rule.Below) || (rule.Above != nil && v > *rule.Above) {
+		return strconv.Itoa(v), true
+	}
+	return "", false
+}
+
+func alertMessage(rule models.AlertRule, device, value string) string {
+	if rule.Message != "" {
+		return rule.Message
+	}
+	switch rule.Metric {
+	case MetricBattery:
+		return fmt.Sprintf("%s: battery at %s%%", device, value)
+	case MetricBitrate:
+		return fmt.Sprintf("%s: bitrate %s kbps", device, value)
+	case MetricViewers:
+		return fmt.Sprintf("%s: %s viewers", device, value)
+	case MetricThermal:
+		return fmt.Sprintf("%s: thermal state %s", device, value)
+	case MetricDisconnected:
+		return fmt.Sprintf("%s: Moblin disconnected for more than %ds", device, rule.For)
+	case MetricLinkRTT:
+		return fmt.Sprintf("%s: control link round trip %s ms", device, value)
+	case MetricLinkJitter:
+		return fmt.Sprintf("%s: control link jitter %s ms", device, value)
+	}
+	return fmt.Sprintf("%s: %s", device, rule.ID)
+}
@@ -0,0 +1,94 @@
+package services
+
+import (
+	"encoding/json"
+	"log"
+	"net/http"
+
+	"github.com/volleybratans/moblin-relay/models"
+)
+
+// Audit actions
+const (
+	AuditCommand        = "command"
+	AuditMacroRun       = "macro_run"
+	AuditMacroCancel    = "macro_cancel"
+	AuditScoutUpdate    = "scout_update"
+	AuditScoutArchive   = "scout_archive"
+	AuditMatchdayUpdate = "matchday_update"
+	AuditScoreUpdate    = "score_update"
+	AuditLogin          = "login"
+	AuditLoginFailed    = "login_failed"
+	AuditLogout         = "logout"
+	AuditClientKick     = "client_kick"
+	AuditControl        = "control"
+)
+
+// sessionPrefixLength is how much of a session ID is shown. Full IDs are
+// login secrets and must not end up in logs or API responses.
+const sessionPrefixLength = 8
+
+// AuditSink persists audit entries
+type AuditSink interface {
+	Append(entry models.AuditEntry) error
+}
+
+// Auditor attributes control actions to login sessions and writes them to
+// the audit log. A nil Auditor records nothing.
+type Auditor struct {
+	sink     AuditSink
+	sessions *SessionStore
+}
+
+// NewAuditor creates an auditor that looks up sessions in sessions
+func NewAuditor(sink AuditSink, sessions *SessionStore) *Auditor {
+	return &Auditor{sink: sink, sessions: sessions}
+}
+
+// Record writes an action. IP and user agent come from the session if it is
+// known, otherwise from the given connection values.
+func (a *Auditor) Record(sessionID, ip, userAgent, action, device string, data interface{}) {
+	if a == nil || a.sink == nil {
+		return
+	}
+	entry := models.AuditEntry{
+		Action:    action,
+		SessionID: SessionPrefix(sessionID),
+		IP:        ip,
+		UserAgent: userAgent,
+		Device:    device,
+	}
+	if a.sessions != nil && sessionID != "" {
+		if session := a.sessions.Get(sessionID); session != nil {
+			entry.IP = session.IP
+			entry.UserAgent = session.UserAgent
+		}
+	}
+	switch v := data.(type) {
+	case nil:
+	case json.RawMessage:
+		entry.Data = v
+	default:
+		entry.Data, _ = json.Marshal(v)
+	}
+	if err := a.sink.Append(entry); err != nil {
+		log.Printf("[AUDIT] Failed to record %s: %v", action, err)
+	}
+}
+
+// SessionPrefix is the form of a session ID stored in the audit log and
+// shown in the client list
+func SessionPrefix(sessionID string) string {
+	if len(sessionID) > sessionPrefixLength {
+		return sessionID[:sessionPrefixLength]
+	}
+	return sessionID
+}
+
+// RecordRequest writes an action performed by the session behind an HTTP request
+func (a *Auditor) RecordRequest(r *http.Request, action, device string, data interface{}) {
+	if a == nil {
+		return
+	}
+	a.Record(GetSessionID(r), GetClientIP(r), r.Header.Get("User-Agent"), action, device, data)
+}
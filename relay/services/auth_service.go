@@ -3,6 +3,7 @@ package services
 import (
 	"crypto/rand"
 	"crypto/sha256"
+	"crypto/subtle"
 	"encoding/hex"
 	"encoding/json"
 	"io/ioutil"
@@ -28,9 +29,10 @@ type Session struct {
 
 // SessionStore manages persistent session storage
 type SessionStore struct {
-	sessions map[string]*Session
-	file     string
-	mu       sync.RWMutex
+	sessions  map[string]*Session
+	file      string
+	listeners []func(sessionID string)
+	mu        sync.RWMutex
 }
 
 // NewSessionStore creates a session store
@@ -101,9 +103,35 @@ func (s *SessionStore) Touch(sessionID string) {
 
 func (s *SessionStore) Delete(sessionID string) {
 	s.mu.Lock()
-	defer s.mu.Unlock()
 	delete(s.sessions, sessionID)
 	s.save()
+	s.mu.Unlock()
+	s.notifyRemoved([]string{sessionID})
+}
+
+// Flush writes all sessions to disk
+func (s *SessionStore) Flush() {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	s.save()
+}
+
+// OnRemove registers a callback for sessions that are deleted or expire
+func (s *SessionStore) OnRemove(fn func(sessionID string)) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.listeners = append(s.listeners, fn)
+}
+
+func (s *SessionStore) notifyRemoved(sessionIDs []string) {
+	s.mu.RLock()
+	listeners := s.listeners
+	s.mu.RUnlock()
+	for _, id := range sessionIDs {
+		for _, fn := range listeners {
+			fn(id)
+		}
+	}
 }
 
 func (s *SessionStore) cleanupLoop() {
@@ -114,18 +142,19 @@ func (s *SessionStore) cleanupLoop() {
 
 func (s *SessionStore) cleanup() {
 	s.mu.Lock()
-	defer s.mu.Unlock()
 	now := time.Now()
-	cleaned := false
+	var expired []string
 	for id, session := range s.sessions {
 		if now.After(session.ExpiresAt) {
 			delete(s.sessions, id)
-			cleaned = true
+			expired = append(expired, id)
 		}
 	}
-	if cleaned {
+	if len(expired) > 0 {
 		s.save()
 	}
+	s.mu.Unlock()
+	s.notifyRemoved(expired)
 }
 
 // RateLimiter implements token bucket rate limiting
@@ -163,6 +192,20 @@ func (rl *RateLimiter) Allow(key string, limit int, window time.Duration) bool {
 	return true
 }
 
+// Count returns the number of recorded requests for key within window
+func (rl *RateLimiter) Count(key string, window time.Duration) int {
+	rl.mu.Lock()
+	defer rl.mu.Unlock()
+	cutoff := time.Now().Add(-window)
+	count := 0
+	for _, t := range rl.requests[key] {
+		if t.After(cutoff) {
+			count++
+		}
+	}
+	return count
+}
+
 func (rl *RateLimiter) cleanup() {
 	rl.mu.Lock()
 	defer rl.mu.Unlock()
@@ -205,24 +248,53 @@ func IsBot(userAgent string) bool {
 
 type AuthService struct {
 	PIN          string
+	OverlayToken string
 	SessionStore *SessionStore
 	RateLimiter  *RateLimiter
 }
 
-func NewAuthService(dataDir, pin string) *AuthService {
+func NewAuthService(dataDir, pin, overlayToken string) *AuthService {
 	if pin == "" {
 		pin = os.Getenv("AUTH_PIN")
 	}
 	if pin == "" {
 		pin = "274683"
 	}
+	if overlayToken == "" {
+		overlayToken = os.Getenv("OVERLAY_TOKEN")
+	}
+	if overlayToken == "" {
+		overlayToken = loadOrCreateToken(dataDir + "/overlay_token")
+	}
 	return &AuthService{
 		PIN:          pin,
+		OverlayToken: overlayToken,
 		SessionStore: NewSessionStore(dataDir),
 		RateLimiter:  NewRateLimiter(),
 	}
 }
 
+// ValidOverlayToken checks a read-only overlay token in constant time
+func (as *AuthService) ValidOverlayToken(token string) bool {
+	if token == "" || as.OverlayToken == "" {
+		return false
+	}
+	return subtle.ConstantTimeCompare([]byte(token), []byte(as.OverlayToken)) == 1
+}
+
+// loadOrCreateToken keeps the overlay token stable across restarts so
+// overlay URLs configured in OBS keep working
+func loadOrCreateToken(file string) string {
+	if data, err := ioutil.ReadFile(file); err == nil && len(data) > 0 {
+		return strings.TrimSpace(string(data))
+	}
+	bytes := make([]byte, 16)
+	rand.Read(bytes)
+	token := hex.EncodeToString(bytes)
+	ioutil.WriteFile(file, []byte(token), 0600)
+	return token
+}
+
 func hashString(s string) string {
 	h := sha256.Sum256([]byte(s))
 	return hex.EncodeToString(h[:8])
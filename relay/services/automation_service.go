@@ -0,0 +1,254 @@
+package services
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+
+	"github.com/volleybratans/moblin-relay/events"
+	"github.com/volleybratans/moblin-relay/models"
+	"github.com/volleybratans/moblin-relay/protocol"
+)
+
+const (
+	// maxAutomationLog bounds the in-memory log of fired rules
+	maxAutomationLog = 200
+
+	// allDevices is the pending key of rules without a device
+	allDevices = "all"
+)
+
+// AutomationTriggers are the events automation rules can react to
+var AutomationTriggers = append([]string{
+	protocol.TypeStreamStarted,
+	protocol.TypeStreamEnded,
+	"matchday_update",
+	"score_update",
+	"scout_update",
+}, protocol.MatchEvents...)
+
+// DefaultAutomationConfig is written to automation.json on first start
+var DefaultAutomationConfig = models.AutomationConfig{
+	Enabled: false,
+	Rules: []models.AutomationRule{
+		{ID: "set_end_scoreboard", On: protocol.MatchSetEnd, Scene: "scoreboard", For: 60, Then: "main"},
+		{ID: "timeout_brb", On: protocol.MatchTimeout, Scene: "BRB", For: 30, Then: "main"},
+		{ID: "timeout_end_main", On: protocol.MatchTimeoutEnd, Scene: "main"},
+	},
+}
+
+// CommandSender sends relay-originated commands to Moblin
+type CommandSender interface {
+	SendCommand(device string, command protocol.Command, onReply func(replyType, message string))
+}
+
+// AutomationService switches Moblin scenes according to declarative rules
+type AutomationService struct {
+	file    string
+	config  models.AutomationConfig
+	sender  CommandSender
+	pending map[string]*time.Timer // target device -> scheduled "then" switch
+	log     []*models.AutomationLogEntry
+	mu      sync.Mutex
+}
+
+// NewAutomationService creates an automation service with rules from <dataDir>/automation.json
+func NewAutomationService(dataDir string, sender CommandSender) *AutomationService {
+	s := &AutomationService{
+		file:    filepath.Join(dataDir, "automation.json"),
+		config:  DefaultAutomationConfig,
+		sender:  sender,
+		pending: make(map[string]*time.Timer),
+	}
+	s.load()
+	return s
+}
+
+func (s *AutomationService) load() {
+	data, err := os.ReadFile(s.file)
+	if err != nil {
+		s.save()
+		return
+	}
+	var config models.AutomationConfig
+	if err := json.Unmarshal(data, &config); err != nil || ValidateAutomationRules(config.Rules) != nil {
+		log.Printf("[AUTOMATION] Ignoring invalid %s", s.file)
+		return
+	}
+	s.config = config
+}
+
+func (s *AutomationService) save() error {
+	data, err := json.MarshalIndent(s.config, "", "  ")
+	if err != nil {
+		return err
+	}
+	return os.WriteFile(s.file, data, 0644)
+}
+
+// Config returns the current rules and master switch
+func (s *AutomationService) Config() models.AutomationConfig {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	config := s.config
+	config.Rules = append([]models.AutomationRule(nil), s.config.Rules...)
+	return config
+}
+
+// SetConfig validates, applies and saves a new configuration
+func (s *AutomationService) SetConfig(config models.AutomationConfig) error {
+	if err := ValidateAutomationRules(config.Rules); err != nil {
+		return err
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.config = config
+	if !config.Enabled {
+		s.cancelPending("")
+	}
+	return s.save()
+}
+
+// SetEnabled turns all rules on or off
+func (s *AutomationService) SetEnabled(enabled bool) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.config.Enabled = enabled
+	if !enabled {
+		s.cancelPending("")
+	}
+	log.Printf("[AUTOMATION] Enabled: %v", enabled)
+	return s.save()
+}
+
+// Log returns the fired rules, newest first
+func (s *AutomationService) Log() []models.AutomationLogEntry {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	entries := make([]models.AutomationLogEntry, 0, len(s.log))
+	for i := len(s.log) - 1; i >= 0; i-- {
+		entries = append(entries, *s.log[i])
+	}
+	return entries
+}
+
+// ValidateAutomationRules checks triggers, scenes and IDs of rules
+func ValidateAutomationRules(rules []models.AutomationRule) error {
+	seen := make(map[string]bool)
+	for _, rule := range rules {
+		if rule.ID == "" {
+			return fmt.Errorf("rule without id")
+		}
+		if seen[rule.ID] {
+			return fmt.Errorf("duplicate rule id %q", rule.ID)
+		}
+		seen[rule.ID] = true
+		if !isAutomationTrigger(rule.On) {
+			return fmt.Errorf("rule %q: unknown event %q", rule.ID, rule.On)
+		}
+		if !protocol.IsKnownScene(rule.Scene) {
+			return fmt.Errorf("rule %q: unknown scene %q", rule.ID, rule.Scene)
+		}
+		if rule.For < 0 {
+			return fmt.Errorf("rule %q: for must not be negative", rule.ID)
+		}
+		if rule.Then != "" {
+			if rule.For == 0 {
+				return fmt.Errorf("rule %q: then requires for", rule.ID)
+			}
+			if !protocol.IsKnownScene(rule.Then) {
+				return fmt.Errorf("rule %q: unknown scene %q", rule.ID, rule.Then)
+			}
+		}
+	}
+	return nil
+}
+
+func isAutomationTrigger(name string) bool {
+	for _, t := range AutomationTriggers {
+		if t == name {
+			return true
+		}
+	}
+	return false
+}
+
+// HandleEvent fires all enabled rules for an event. Subscribed to the relay event bus.
+func (s *AutomationService) HandleEvent(ev events.Event) {
+	s.mu.Lock()
+	if !s.config.Enabled {
+		s.mu.Unlock()
+		return
+	}
+	var fired []models.AutomationRule
+	for _, rule := range s.config.Rules {
+		if !rule.Disabled && rule.On == ev.Type {
+			fired = append(fired, rule)
+		}
+	}
+	for _, rule := range fired {
+		// A newer switch wins over a pending "then"
+		s.cancelPending(rule.Device)
+		if rule.Then != "" {
+			rule := rule
+			s.pending[target(rule.Device)] = time.AfterFunc(time.Duration(rule.For)*time.Second, func() {
+				s.mu.Lock()
+				delete(s.pending, target(rule.Device))
+				s.mu.Unlock()
+				s.switchScene(rule, ev.Type, rule.Then)
+			})
+		}
+	}
+	s.mu.Unlock()
+
+	for _, rule := range fired {
+		s.switchScene(rule, ev.Type, rule.Scene)
+	}
+}
+
+// cancelPending stops scheduled "then" switches for a device, or all of
+// them for "" / "all". Caller must hold s.mu.
+func (s *AutomationService) cancelPending(device string) {
+	for key, timer := range s.pending {
+		if target(device) == allDevices || key == target(device) || key == allDevices {
+			timer.Stop()
+			delete(s.pending, key)
+		}
+	}
+}
+
+func target(device string) string {
+	if device == "" {
+		return allDevices
+	}
+	return device
+}
+
+// switchScene sends set_scene and records the outcome in the log
+func (s *AutomationService) switchScene(rule models.AutomationRule, event, scene string) {
+	entry := &models.AutomationLogEntry{
+		Time:   time.Now().UTC().Format(time.RFC3339),
+		Rule:   rule.ID,
+		Event:  event,
+		Device: rule.Device,
+		Scene:  scene,
+		Result: "sent",
+	}
+	s.mu.Lock()
+	s.log = append(s.log, entry)
+	if len(s.log) > maxAutomationLog {
+		s.log = s.log[len(s.log)-maxAutomationLog:]
+	}
+	s.mu.Unlock()
+
+	log.Printf("[AUTOMATION] Rule %s (%s): scene %s", rule.ID, event, scene)
+	s.sender.SendCommand(rule.Device, protocol.SetScene{Name: scene}, func(replyType, message string) {
+		s.mu.Lock()
+		entry.Result = replyType
+		s.mu.Unlock()
+	})
+}
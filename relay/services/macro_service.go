@@ -0,0 +1,321 @@
+package services
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/volleybratans/moblin-relay/models"
+	"github.com/volleybratans/moblin-relay/protocol"
+)
+
+// Macro run states
+const (
+	MacroRunning   = "running"
+	MacroCompleted = "completed"
+	MacroFailed    = "failed"
+	MacroCancelled = "cancelled"
+)
+
+// DefaultMacros are written to macros.json on first start
+var DefaultMacros = []models.Macro{
+	{
+		Name: "pre_match",
+		Steps: []json.RawMessage{
+			json.RawMessage(`{"type": "set_bitrate", "kbps": 6000}`),
+			json.RawMessage(`{"type": "set_scene", "name": "court_overview"}`),
+			json.RawMessage(`{"type": "toggle_recording"}`),
+			json.RawMessage(`{"type": "go_live"}`),
+			json.RawMessage(`{"type": "wait", "seconds": 300}`),
+			json.RawMessage(`{"type": "set_scene", "name": "main"}`),
+		},
+	},
+}
+
+// macroRun is a macro being executed
+type macroRun struct {
+	progress models.MacroProgress
+	cancel   chan struct{}
+}
+
+// MacroService stores macros and runs them step by step. Each command
+// waits for Moblin's reply before the next step; a failed or timed out
+// command stops the macro.
+type MacroService struct {
+	file      string
+	macros    []models.Macro
+	sender    CommandSender
+	runs      map[string]*macroRun
+	nextID    int
+	listeners []func(models.MacroProgress)
+	mu        sync.Mutex
+}
+
+// NewMacroService creates a macro service with macros from <dataDir>/macros.json.
+// Macros can only run once a CommandSender is set.
+func NewMacroService(dataDir string) *MacroService {
+	s := &MacroService{
+		file:   filepath.Join(dataDir, "macros.json"),
+		macros: DefaultMacros,
+		runs:   make(map[string]*macroRun),
+	}
+	s.load()
+	return s
+}
+
+func (s *MacroService) load() {
+	data, err := os.ReadFile(s.file)
+	if err != nil {
+		s.save()
+		return
+	}
+	var macros []models.Macro
+	if err := json.Unmarshal(data, &macros); err != nil || ValidateMacros(macros) != nil {
+		log.Printf("[MACROS] Ignoring invalid %s", s.file)
+		return
+	}
+	s.macros = macros
+}
+
+func (s *MacroService) save() error {
+	data, err := json.MarshalIndent(s.macros, "", "  ")
+	if err != nil {
+		return err
+	}
+	return os.WriteFile(s.file, data, 0644)
+}
+
+// SetSender sets where macro commands are sent
+func (s *MacroService) SetSender(sender CommandSender) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.sender = sender
+}
+
+// OnProgress registers a callback for macro progress
+func (s *MacroService) OnProgress(fn func(progress models.MacroProgress)) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.listeners = append(s.listeners, fn)
+}
+
+func (s *MacroService) notify(progress models.MacroProgress) {
+	s.mu.Lock()
+	listeners := s.listeners
+	s.mu.Unlock()
+	for _, fn := range listeners {
+		fn(progress)
+	}
+}
+
+// Macros returns all stored macros
+func (s *MacroService) Macros() []models.Macro {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return append([]models.Macro(nil), s.macros...)
+}
+
+// SetMacros validates, applies and saves all macros
+func (s *MacroService) SetMacros(macros []models.Macro) error {
+	if err := ValidateMacros(macros); err != nil {
+		return err
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.macros = macros
+	return s.save()
+}
+
+// ValidateMacros checks names and that every step is a valid command or wait
+func ValidateMacros(macros []models.Macro) error {
+	seen := make(map[string]bool)
+	for _, macro := range macros {
+		if macro.Name == "" {
+			return fmt.Errorf("macro without name")
+		}
+		if seen[macro.Name] {
+			return fmt.Errorf("duplicate macro %q", macro.Name)
+		}
+		seen[macro.Name] = true
+		if len(macro.Steps) == 0 {
+			return fmt.Errorf("macro %q has no steps", macro.Name)
+		}
+		for i, step := range macro.Steps {
+			if _, _, err := protocol.ParseMacroStep(step); err != nil {
+				return fmt.Errorf("macro %q step %d: %v", macro.Name, i+1, stepError(err))
+			}
+		}
+	}
+	return nil
+}
+
+// stepError drops the error code of protocol errors for readable messages
+func stepError(err error) string {
+	if perr, ok := err.(*protocol.Error); ok {
+		return perr.Message
+	}
+	return err.Error()
+}
+
+// Runs returns the macros currently running
+func (s *MacroService) Runs() []models.MacroProgress {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	runs := make([]models.MacroProgress, 0, len(s.runs))
+	for _, run := range s.runs {
+		runs = append(runs, run.progress)
+	}
+	sort.Slice(runs, func(i, j int) bool {
+		return runs[i].Started < runs[j].Started
+	})
+	return runs
+}
+
+// Run starts a macro in the background and returns its run ID. device
+// overrides the macro's device if set.
+func (s *MacroService) Run(name, device string) (string, error) {
+	s.mu.Lock()
+	var macro *models.Macro
+	for i := range s.macros {
+		if s.macros[i].Name == name {
+			macro = &s.macros[i]
+			break
+		}
+	}
+	if macro == nil {
+		s.mu.Unlock()
+		return "", fmt.Errorf("unknown macro %q", name)
+	}
+	if s.sender == nil {
+		s.mu.Unlock()
+		return "", fmt.Errorf("macros are not available")
+	}
+	if device == "" {
+		device = macro.Device
+	}
+	s.nextID++
+	run := &macroRun{
+		progress: models.MacroProgress{
+			RunID:   "macro-" + strconv.Itoa(s.nextID),
+			Macro:   macro.Name,
+			Device:  device,
+			State:   MacroRunning,
+			Steps:   len(macro.Steps),
+			Started: time.Now().UTC().Format(time.RFC3339),
+		},
+		cancel: make(chan struct{}),
+	}
+	s.runs[run.progress.RunID] = run
+	steps := append([]json.RawMessage(nil), macro.Steps...)
+	sender := s.sender
+	s.mu.Unlock()
+
+	log.Printf("[MACROS] Running %s as %s", macro.Name, run.progress.RunID)
+	go s.execute(run, steps, sender)
+	return run.progress.RunID, nil
+}
+
+// Cancel stops a running macro before its next step
+func (s *MacroService) Cancel(runID string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	run, ok := s.runs[runID]
+	if !ok {
+		return fmt.Errorf("unknown macro run %q", runID)
+	}
+	select {
+	case <-run.cancel:
+	default:
+		close(run.cancel)
+	}
+	return nil
+}
+
+func (s *MacroService) execute(run *macroRun, steps []json.RawMessage, sender CommandSender) {
+	for i, step := range steps {
+		wait, command, err := protocol.ParseMacroStep(step)
+		if err != nil {
+			s.finish(run, MacroFailed, stepError(err))
+			return
+		}
+
+		if wait != nil {
+			s.update(run, i+1, protocol.TypeWait, "", fmt.Sprintf("Waiting %ds", wait.Seconds))
+			select {
+			case <-time.After(time.Duration(wait.Seconds) * time.Second):
+			case <-run.cancel:
+				s.finish(run, MacroCancelled, "")
+				return
+			}
+			continue
+		}
+
+		s.update(run, i+1, command.CommandType(), "", "")
+		replies := make(chan [2]string, 4)
+		sender.SendCommand(run.progress.Device, command, func(replyType, message string) {
+			select {
+			case replies <- [2]string{replyType, message}:
+			default:
+			}
+		})
+
+		// Queued commands keep their order on the device, so the macro
+		// does not have to wait for Moblin to reconnect
+		var reply [2]string
+		select {
+		case reply = <-replies:
+		case <-run.cancel:
+			s.finish(run, MacroCancelled, "")
+			return
+		}
+		s.update(run, i+1, command.CommandType(), reply[0], reply[1])
+		switch reply[0] {
+		case "command_ack", "command_queued", "command_delivered":
+		default:
+			s.finish(run, MacroFailed, fmt.Sprintf("Step %d (%s): %s", i+1, command.CommandType(), reply[0]))
+			return
+		}
+
+		select {
+		case <-run.cancel:
+			s.finish(run, MacroCancelled, "")
+			return
+		default:
+		}
+	}
+	s.finish(run, MacroCompleted, "")
+}
+
+// update reports progress on the current step
+func (s *MacroService) update(run *macroRun, step int, command, result, message string) {
+	s.mu.Lock()
+	run.progress.Step = step
+	run.progress.Command = command
+	run.progress.Result = result
+	run.progress.Message = message
+	progress := run.progress
+	s.mu.Unlock()
+	s.notify(progress)
+}
+
+// finish ends a run and reports its final state
+func (s *MacroService) finish(run *macroRun, state, message string) {
+	s.mu.Lock()
+	run.progress.State = state
+	run.progress.Message = message
+	progress := run.progress
+	delete(s.runs, progress.RunID)
+	s.mu.Unlock()
+	if message != "" {
+		log.Printf("[MACROS] %s (%s) %s: %s", progress.RunID, progress.Macro, state, message)
+	} else {
+		log.Printf("[MACROS] %s (%s) %s", progress.RunID, progress.Macro, state)
+	}
+	s.notify(progress)
+}
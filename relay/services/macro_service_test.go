@@ -0,0 +1,185 @@
+package services
+
+import (
+	"encoding/json"
+	"reflect"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/volleybratans/moblin-relay/models"
+	"github.com/volleybratans/moblin-relay/protocol"
+)
+
+// fakeSender answers macro commands with a fixed reply per command type;
+// commands without a reply are left unanswered
+type fakeSender struct {
+	replies map[string]string
+	sent    []string
+	mu      sync.Mutex
+}
+
+func (f *fakeSender) SendCommand(device string, command protocol.Command, onReply func(replyType, message string)) {
+	f.mu.Lock()
+	f.sent = append(f.sent, command.CommandType())
+	reply, ok := f.replies[command.CommandType()]
+	f.mu.Unlock()
+	if ok {
+		onReply(reply, "")
+	}
+}
+
+func (f *fakeSender) sentCommands() []string {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return append([]string{}, f.sent...)
+}
+
+// startMacro runs a macro of steps and returns its progress updates
+func startMacro(t *testing.T, sender CommandSender, steps ...string) (*MacroService, string, <-chan models.MacroProgress) {
+	t.Helper()
+	s := NewMacroService(t.TempDir())
+	macro := models.Macro{Name: "test"}
+	for _, step := range steps {
+		macro.Steps = append(macro.Steps, json.RawMessage(step))
+	}
+	if err := s.SetMacros([]models.Macro{macro}); err != nil {
+		t.Fatal(err)
+	}
+	s.SetSender(sender)
+	progress := make(chan models.MacroProgress, 32)
+	s.OnProgress(func(p models.MacroProgress) { progress <- p })
+	runID, err := s.Run("test", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	return s, runID, progress
+}
+
+// waitFor returns the first progress update accepted by match
+func waitFor(t *testing.T, progress <-chan models.MacroProgress, match func(models.MacroProgress) bool) models.MacroProgress {
+	t.Helper()
+	timeout := time.After(5 * time.Second)
+	for {
+		select {
+		case p := <-progress:
+			if match(p) {
+				return p
+			}
+		case <-timeout:
+			t.Fatal("macro did not make progress")
+		}
+	}
+}
+
+func finished(p models.MacroProgress) bool { return p.State != MacroRunning }
+
+const (
+	stepBitrate = `{"type": "set_bitrate", "kbps": 6000}`
+	stepScene   = `{"type": "set_scene", "name": "court_overview"}`
+	stepLive    = `{"type": "go_live"}`
+)
+
+func TestMacroExecute(t *testing.T) {
+	tests := []struct {
+		name    string
+		replies map[string]string
+		state   string
+		message string
+		sent    []string
+	}{
+		{
+			name:    "all acknowledged",
+			replies: map[string]string{"set_bitrate": "command_ack", "set_scene": "command_ack", "go_live": "command_ack"},
+			state:   MacroCompleted,
+			sent:    []string{"set_bitrate", "set_scene", "go_live"},
+		},
+		{
+			name:    "queued and delivered count as progress",
+			replies: map[string]string{"set_bitrate": "command_queued", "set_scene": "command_queued", "go_live": "command_delivered"},
+			state:   MacroCompleted,
+			sent:    []string{"set_bitrate", "set_scene", "go_live"},
+		},
+		{
+			name:    "failed step stops the macro",
+			replies: map[string]string{"set_bitrate": "command_ack", "set_scene": "command_failed", "go_live": "command_ack"},
+			state:   MacroFailed,
+			message: "Step 2 (set_scene): command_failed",
+			sent:    []string{"set_bitrate", "set_scene"},
+		},
+		{
+			name:    "timeout stops the macro",
+			replies: map[string]string{"set_bitrate": "command_timeout"},
+			state:   MacroFailed,
+			message: "Step 1 (set_bitrate): command_timeout",
+			sent:    []string{"set_bitrate"},
+		},
+		{
+			name:    "superseded in the queue",
+			replies: map[string]string{"set_bitrate": "command_queued", "set_scene": "command_expired"},
+			state:   MacroFailed,
+			message: "Step 2 (set_scene): command_expired",
+			sent:    []string{"set_bitrate", "set_scene"},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			sender := &fakeSender{replies: tt.replies}
+			s, _, progress := startMacro(t, sender, stepBitrate, stepScene, stepLive)
+			final := waitFor(t, progress, finished)
+			if final.State != tt.state || final.Message != tt.message {
+				t.Errorf("finished %s (%q), want %s (%q)", final.State, final.Message, tt.state, tt.message)
+			}
+			if got := sender.sentCommands(); !reflect.DeepEqual(got, tt.sent) {
+				t.Errorf("sent %v, want %v", got, tt.sent)
+			}
+			if runs := s.Runs(); len(runs) != 0 {
+				t.Errorf("%d runs left after finishing", len(runs))
+			}
+		})
+	}
+}
+
+func TestMacroCancel(t *testing.T) {
+	tests := []struct {
+		name    string
+		replies map[string]string
+		steps   []string
+		at      func(models.MacroProgress) bool // when to cancel
+		sent    []string
+	}{
+		{
+			name:    "during a wait",
+			replies: map[string]string{"set_bitrate": "command_ack", "go_live": "command_ack"},
+			steps:   []string{stepBitrate, `{"type": "wait", "seconds": 3600}`, stepLive},
+			at:      func(p models.MacroProgress) bool { return p.Command == protocol.TypeWait },
+			sent:    []string{"set_bitrate"},
+		},
+		{
+			name:    "while waiting for a reply",
+			replies: map[string]string{"go_live": "command_ack"},
+			steps:   []string{stepBitrate, stepLive},
+			at:      func(p models.MacroProgress) bool { return p.Command == protocol.TypeSetBitrate },
+			sent:    []string{"set_bitrate"},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			sender := &fakeSender{replies: tt.replies}
+			s, runID, progress := startMacro(t, sender, tt.steps...)
+			waitFor(t, progress, tt.at)
+			if err := s.Cancel(runID); err != nil {
+				t.Fatal(err)
+			}
+			if final := waitFor(t, progress, finished); final.State != MacroCancelled {
+				t.Errorf("finished %s, want %s", final.State, MacroCancelled)
+			}
+			if got := sender.sentCommands(); !reflect.DeepEqual(got, tt.sent) {
+				t.Errorf("sent %v, want %v", got, tt.sent)
+			}
+			if err := s.Cancel(runID); err == nil {
+				t.Error("cancelled a finished run")
+			}
+		})
+	}
+}
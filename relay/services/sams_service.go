@@ -0,0 +1,381 @@
+package services
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"reflect"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/volleybratans/moblin-relay/models"
+)
+
+const (
+	// DefaultSamsURL is the SAMS ticker backend
+	DefaultSamsURL = "https://backend.sams-ticker.de"
+
+	// DefaultSamsInterval is how often the ticker is polled while a match is set
+	DefaultSamsInterval = 5 * time.Second
+
+	// samsFeedPath is the DVV ticker feed with all matches of the day
+	samsFeedPath = "/live/indoor/tickers/dvv"
+
+	// samsMaxBackoff bounds the delay between polls after errors
+	samsMaxBackoff = 5 * time.Minute
+
+	// samsTimeline is the number of recent ticker events kept
+	samsTimeline = 10
+)
+
+// SAMS match states
+const (
+	SamsUpcoming = "upcoming"
+	SamsLive     = "live"
+	SamsFinished = "finished"
+)
+
+// SamsService polls the SAMS ticker for the match of the matchday state,
+// so browsers and overlays don't each poll backend.sams-ticker.de. The
+// normalized match is cached and listeners are notified when it changes.
+// Failed polls back off exponentially; finished matches are not polled
+// again until the match changes.
+type SamsService struct {
+	baseURL   string
+	interval  time.Duration
+	client    *http.Client
+	matchID   func() string
+	status    models.SamsStatus
+	listeners []func(models.SamsMatch)
+	mu        sync.Mutex
+}
+
+// NewSamsService creates a poller for the ticker at baseURL (DefaultSamsURL
+// if empty). matchID returns the SAMS match UUID to follow, empty for none.
+func NewSamsService(baseURL string, interval time.Duration, matchID func() string) *SamsService {
+	if baseURL == "" {
+		baseURL = DefaultSamsURL
+	}
+	if interval <= 0 {
+		interval = DefaultSamsInterval
+	}
+	return &SamsService{
+		baseURL:  strings.TrimSuffix(baseURL, "/"),
+		interval: interval,
+		client:   &http.Client{Timeout: 10 * time.Second},
+		matchID:  matchID,
+	}
+}
+
+// OnUpdate registers a callback for changed match data
+func (s *SamsService) OnUpdate(fn func(match models.SamsMatch)) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.listeners = append(s.listeners, fn)
+}
+
+// Status returns the cached match and the state of the poller
+func (s *SamsService) Status() models.SamsStatus {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	status := s.status
+	if status.Match != nil {
+		match := *status.Match
+		status.Match = &match
+	}
+	return status
+}
+
+// Run polls the ticker until the process exits
+func (s *SamsService) Run() {
+	for {
+		time.Sleep(s.poll())
+	}
+}
+
+// poll fetches the current match once and returns the delay until the next poll
+func (s *SamsService) poll() time.Duration {
+	id := strings.TrimSpace(s.matchID())
+	s.mu.Lock()
+	if id != s.status.MatchID {
+		// A new match starts with an empty cache
+		s.status = models.SamsStatus{MatchID: id}
+		if id != "" {
+			log.Printf("[SAMS] Following match %s", id)
+		}
+	}
+	finished := s.status.Match != nil && s.status.Match.Status == SamsFinished
+	s.mu.Unlock()
+	if id == "" || finished {
+		return s.interval
+	}
+
+	match, err := s.fetch(id)
+	now := time.Now().UTC()
+
+	s.mu.Lock()
+	delay := s.interval
+	changed := false
+	s.status.LastPoll = now.Format(time.RFC3339)
+	if err != nil {
+		s.status.Failures++
+		s.status.LastError = err.Error()
+		delay = s.backoff(s.status.Failures)
+		log.Printf("[SAMS] Poll for %s failed (%d in a row, retrying in %s): %v", id, s.status.Failures, delay, err)
+	} else {
+		if s.status.Failures > 0 {
+			log.Printf("[SAMS] Poll for %s recovered after %d failures", id, s.status.Failures)
+		}
+		s.status.Failures = 0
+		s.status.LastError = ""
+		if s.status.Match == nil || !reflect.DeepEqual(*s.status.Match, *match) {
+			changed = true
+			s.status.Match = match
+			s.status.LastUpdate = now.Format(time.RFC3339)
+		}
+	}
+	s.status.NextPoll = now.Add(delay).Format(time.RFC3339)
+	listeners := s.listeners
+	s.mu.Unlock()
+
+	if changed {
+		for _, fn := range listeners {
+			fn(*match)
+		}
+	}
+	return delay
+}
+
+// backoff doubles the poll interval for every consecutive failure
+func (s *SamsService) backoff(failures int) time.Duration {
+	delay := s.interval
+	for i := 0; i < failures && delay < samsMaxBackoff; i++ {
+		delay *= 2
+	}
+	return min(delay, samsMaxBackoff)
+}
+
+// fetch loads the ticker feed and normalizes the match
+func (s *SamsService) fetch(matchID string) (*models.SamsMatch, error) {
+	resp, err := s.client.Get(s.baseURL + samsFeedPath)
+	if err != nil {
+		return nil, fmt.Errorf("fetch failed: %v", err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("status code %d", resp.StatusCode)
+	}
+	var feed samsFeed
+	if err := json.NewDecoder(resp.Body).Decode(&feed); err != nil {
+		return nil, fmt.Errorf("invalid ticker data: %v", err)
+	}
+	return feed.match(matchID)
+}
+
+// samsFeed is the part of the SAMS ticker response the relay uses
+type samsFeed struct {
+	MatchDays []struct {
+		Date    samsText         `json:"date"`
+		Matches []samsMatchEntry `json:"matches"`
+	} `json:"matchDays"`
+	MatchStates    map[string]samsState       `json:"matchStates"`
+	MatchStats     map[string]json.RawMessage `json:"matchStats"`
+	MatchSeries    []samsSeries               `json:"matchSeries"`
+	EventHistories map[string][]samsEvent     `json:"eventHistories"`
+}
+
+type samsMatchEntry struct {
+	ID               string   `json:"id"`
+	TeamDescription1 string   `json:"teamDescription1"`
+	TeamDescription2 string   `json:"teamDescription2"`
+	Team1Short       string   `json:"team1Short"`
+	Team2Short       string   `json:"team2Short"`
+	StartTime        samsText `json:"startTime"`
+	MatchSeries      string   `json:"matchSeries"`
+}
+
+type samsState struct {
+	MatchUUID     string       `json:"matchUuid"`
+	MatchEnded    bool         `json:"matchEnded"`
+	SetPoints     samsPoints   `json:"setPoints"`
+	MatchSets     []samsPoints `json:"matchSets"`
+	CurrentPoints *samsPoints  `json:"currentPoints"`
+	Serving       string       `json:"serving"`
+}
+
+type samsPoints struct {
+	Team1 int `json:"team1"`
+	Team2 int `json:"team2"`
+}
+
+type samsSeries struct {
+	ID    string `json:"id"`
+	Name  string `json:"name"`
+	Teams []struct {
+		Name         string `json:"name"`
+		ShortName    string `json:"shortName"`
+		LogoImage200 string `json:"logoImage200"`
+		LogoImage100 string `json:"logoImage100"`
+	} `json:"teams"`
+}
+
+type samsEvent struct {
+	Type      string   `json:"type"`
+	Team      string   `json:"team"`
+	Timestamp samsText `json:"timestamp"`
+	PlayerIn  samsText `json:"playerIn"`
+	PlayerOut samsText `json:"playerOut"`
+}
+
+// samsText accepts a JSON string or number, as the feed mixes both
+type samsText string
+
+func (t *samsText) UnmarshalJSON(data []byte) error {
+	if string(data) == "null" {
+		*t = ""
+		return nil
+	}
+	var s string
+	if err := json.Unmarshal(data, &s); err == nil {
+		*t = samsText(s)
+		return nil
+	}
+	var n json.Number
+	if err := json.Unmarshal(data, &n); err != nil {
+		return err
+	}
+	*t = samsText(n.String())
+	return nil
+}
+
+// timestamp converts millisecond timestamps to RFC 3339 and keeps other text
+func (t samsText) timestamp() string {
+	if ms, err := strconv.ParseInt(string(t), 10, 64); err == nil && ms > 1e11 {
+		return time.UnixMilli(ms).UTC().Format(time.RFC3339)
+	}
+	return string(t)
+}
+
+func (p samsPoints) score() models.SetScore {
+	return models.SetScore{Home: p.Team1, Away: p.Team2}
+}
+
+// samsTeam maps team1/team2 to home/away
+func samsTeam(team string) string {
+	switch team {
+	case "team1":
+		return "home"
+	case "team2":
+		return "away"
+	}
+	return ""
+}
+
+// match normalizes a match of the feed
+func (f samsFeed) match(matchID string) (*models.SamsMatch, error) {
+	var entry *samsMatchEntry
+	var date samsText
+	for _, day := range f.MatchDays {
+		for i := range day.Matches {
+			if day.Matches[i].ID == matchID {
+				entry = &day.Matches[i]
+				date = day.Date
+			}
+		}
+	}
+	if entry == nil {
+		return nil, fmt.Errorf("match %s not found in ticker", matchID)
+	}
+
+	state := f.MatchStates[matchID]
+	match := &models.SamsMatch{
+		ID:         matchID,
+		Teams:      models.SamsTeams{Home: entry.TeamDescription1, Away: entry.TeamDescription2},
+		Sets:       state.SetPoints.score(),
+		SetHistory: []models.SetScore{},
+		Serving:    samsTeam(state.Serving),
+		Status:     SamsUpcoming,
+		Date:       date.timestamp(),
+		StartTime:  entry.StartTime.timestamp(),
+		Timeline:   []models.SamsEvent{},
+		HasStats:   f.MatchStats[matchID] != nil,
+	}
+	if state.MatchUUID != "" {
+		match.Status = SamsLive
+		if state.MatchEnded {
+			match.Status = SamsFinished
+		}
+	}
+
+	for _, series := range f.MatchSeries {
+		if series.ID != entry.MatchSeries {
+			continue
+		}
+		match.Series = series.Name
+		for _, team := range series.Teams {
+			logo := team.LogoImage200
+			if logo == "" {
+				logo = team.LogoImage100
+			}
+			if team.Name == entry.TeamDescription1 || (team.ShortName != "" && team.ShortName == entry.Team1Short) {
+				match.Logos.Home = logo
+			}
+			if team.Name == entry.TeamDescription2 || (team.ShortName != "" && team.ShortName == entry.Team2Short) {
+				match.Logos.Away = logo
+			}
+		}
+	}
+
+	for _, set := range state.MatchSets {
+		match.SetHistory = append(match.SetHistory, set.score())
+	}
+	if state.CurrentPoints != nil {
+		match.CurrentPoints = state.CurrentPoints.score()
+	} else if len(match.SetHistory) > 0 {
+		match.CurrentPoints = match.SetHistory[len(match.SetHistory)-1]
+	}
+
+	events := f.EventHistories[matchID]
+	for _, event := range events {
+		switch event.Type {
+		case "MATCH_BALL":
+			match.IsMatchball = true
+		case "SET_BALL":
+			match.IsSatzball = true
+		}
+	}
+	for _, event := range events[max(0, len(events)-samsTimeline):] {
+		match.Timeline = append(match.Timeline, models.SamsEvent{
+			Type:        event.Type,
+			Team:        samsTeam(event.Team),
+			Time:        event.Timestamp.timestamp(),
+			Description: event.description(),
+		})
+	}
+	return match, nil
+}
+
+// description describes an event for the ticker timeline, as the web app shows it
+func (e samsEvent) description() string {
+	team := "Gast"
+	if e.Team == "team1" {
+		team = "Heim"
+	}
+	switch e.Type {
+	case "POINT":
+		return "Punkt für " + team
+	case "START_TIMEOUT":
+		return "Auszeit " + team
+	case "SUBSTITUTION":
+		return fmt.Sprintf("Wechsel: %s für %s", e.PlayerIn, e.PlayerOut)
+	case "SET_BALL":
+		return "Satzball!"
+	case "MATCH_BALL":
+		return "Matchball!"
+	}
+	return e.Type
+}
@@ -0,0 +1,190 @@
+package services
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/volleybratans/moblin-relay/models"
+)
+
+const samsTestMatch = "4f0c2a6e-0000-0000-0000-000000000001"
+
+// samsTestFeed is a trimmed ticker response with one live match
+const samsTestFeed = `{
+  "matchDays": [{"date": 1792108800000, "matches": [
+    {"id": "other", "teamDescription1": "A", "teamDescription2": "B"},
+    {"id": "4f0c2a6e-0000-0000-0000-000000000001", "teamDescription1": "VolleyBratans", "teamDescription2": "TV Gast",
+     "team1Short": "VB", "team2Short": "TVG", "startTime": 1792173600000, "matchSeries": "series-1"}
+  ]}],
+  "matchStates": {"4f0c2a6e-0000-0000-0000-000000000001": {
+    "matchUuid": "4f0c2a6e-0000-0000-0000-000000000001", "matchEnded": false,
+    "setPoints": {"team1": 1, "team2": 0}, "matchSets": [{"team1": 25, "team2": 20}],
+    "currentPoints": {"team1": POINTS, "team2": 9}, "serving": "team1"}},
+  "matchSeries": [{"id": "series-1", "name": "Regionalliga", "teams": [
+    {"name": "VolleyBratans", "logoImage200": "https://example.org/vb.png"},
+    {"name": "Other", "shortName": "TVG", "logoImage100": "https://example.org/tvg.png"}
+  ]}],
+  "eventHistories": {"4f0c2a6e-0000-0000-0000-000000000001": [
+    {"type": "POINT", "team": "team1", "timestamp": 1792176062000},
+    {"type": "SET_BALL", "team": "team1", "timestamp": "1792176063000"}
+  ]}
+}`
+
+// fakeSams serves a ticker feed that tests can change
+type fakeSams struct {
+	server   *httptest.Server
+	feed     string
+	status   int
+	requests int
+	mu       sync.Mutex
+}
+
+func newFakeSams(t *testing.T) *fakeSams {
+	t.Helper()
+	f := &fakeSams{feed: strings.Replace(samsTestFeed, "POINTS", "12", 1), status: http.StatusOK}
+	f.server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		f.mu.Lock()
+		defer f.mu.Unlock()
+		f.requests++
+		if r.URL.Path != samsFeedPath {
+			http.NotFound(w, r)
+			return
+		}
+		w.WriteHeader(f.status)
+		w.Write([]byte(f.feed))
+	}))
+	t.Cleanup(f.server.Close)
+	return f
+}
+
+func (f *fakeSams) set(feed string, status int) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.feed = feed
+	f.status = status
+}
+
+func (f *fakeSams) requestCount() int {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return f.requests
+}
+
+func newTestSams(f *fakeSams, matchID string) *SamsService {
+	return NewSamsService(f.server.URL+"/", time.Second, func() string { return matchID })
+}
+
+func TestSamsServiceParses(t *testing.T) {
+	f := newFakeSams(t)
+	s := newTestSams(f, samsTestMatch)
+	s.poll()
+
+	status := s.Status()
+	if status.LastError != "" || status.Match == nil {
+		t.Fatalf("poll failed: %+v", status)
+	}
+	want := models.SamsMatch{
+		ID:            samsTestMatch,
+		Teams:         models.SamsTeams{Home: "VolleyBratans", Away: "TV Gast"},
+		Logos:         models.SamsTeams{Home: "https://example.org/vb.png", Away: "https://example.org/tvg.png"},
+		Sets:          models.SetScore{Home: 1, Away: 0},
+		SetHistory:    []models.SetScore{{Home: 25, Away: 20}},
+		CurrentPoints: models.SetScore{Home: 12, Away: 9},
+		Serving:       "home",
+		Status:        SamsLive,
+		Date:          "2026-10-16T00:00:00Z",
+		StartTime:     "2026-10-16T18:00:00Z",
+		Series:        "Regionalliga",
+		IsSatzball:    true,
+		Timeline: []models.SamsEvent{
+			{Type: "POINT", Team: "home", Time: "2026-10-16T18:41:02Z", Description: "Punkt für Heim"},
+			{Type: "SET_BALL", Team: "home", Time: "2026-10-16T18:41:03Z", Description: "Satzball!"},
+		},
+	}
+	if !reflect.DeepEqual(*status.Match, want) {
+		t.Errorf("match\n%+v\nwant\n%+v", *status.Match, want)
+	}
+}
+
+func TestSamsServiceNotifiesChanges(t *testing.T) {
+	f := newFakeSams(t)
+	s := newTestSams(f, samsTestMatch)
+	var updates []int
+	s.OnUpdate(func(match models.SamsMatch) {
+		updates = append(updates, match.CurrentPoints.Home)
+	})
+
+	s.poll()
+	s.poll()
+	f.set(strings.Replace(samsTestFeed, "POINTS", "13", 1), http.StatusOK)
+	s.poll()
+	if want := []int{12, 13}; !reflect.DeepEqual(updates, want) {
+		t.Errorf("updates with home points %v, want %v", updates, want)
+	}
+}
+
+func TestSamsServiceBackoff(t *testing.T) {
+	f := newFakeSams(t)
+	f.set("", http.StatusServiceUnavailable)
+	s := newTestSams(f, samsTestMatch)
+
+	for i, want := range []time.Duration{2 * time.Second, 4 * time.Second, 8 * time.Second} {
+		if got := s.poll(); got != want {
+			t.Errorf("delay after %d failures %s, want %s", i+1, got, want)
+		}
+	}
+	if status := s.Status(); status.Failures != 3 || status.LastError != "status code 503" {
+		t.Errorf("status after failures %+v", status)
+	}
+	if got := s.backoff(20); got != samsMaxBackoff {
+		t.Errorf("backoff after 20 failures %s, want %s", got, samsMaxBackoff)
+	}
+
+	// Invalid data counts as a failure, recovery resets the delay
+	f.set("{", http.StatusOK)
+	if got := s.poll(); got != 16*time.Second {
+		t.Errorf("delay after invalid data %s, want 16s", got)
+	}
+	f.set(strings.Replace(samsTestFeed, "POINTS", "12", 1), http.StatusOK)
+	if got := s.poll(); got != time.Second {
+		t.Errorf("delay after recovery %s, want 1s", got)
+	}
+	if status := s.Status(); status.Failures != 0 || status.LastError != "" || status.Match == nil {
+		t.Errorf("status after recovery %+v", status)
+	}
+}
+
+func TestSamsServiceSkipsPolls(t *testing.T) {
+	f := newFakeSams(t)
+
+	// Without a match nothing is polled
+	newTestSams(f, "").poll()
+	if n := f.requestCount(); n != 0 {
+		t.Errorf("%d requests without a match", n)
+	}
+
+	// An unknown match is an error
+	s := newTestSams(f, "missing")
+	s.poll()
+	if status := s.Status(); !strings.Contains(status.LastError, "not found") {
+		t.Errorf("status for an unknown match %+v", status)
+	}
+
+	// A finished match is not polled again
+	f.set(strings.Replace(strings.Replace(samsTestFeed, "POINTS", "12", 1), `"matchEnded": false`, `"matchEnded": true`, 1), http.StatusOK)
+	s = newTestSams(f, samsTestMatch)
+	s.poll()
+	if status := s.Status(); status.Match == nil || status.Match.Status != SamsFinished {
+		t.Fatalf("status of a finished match %+v", status)
+	}
+	before := f.requestCount()
+	s.poll()
+	if n := f.requestCount(); n != before {
+		t.Errorf("finished match polled again")
+	}
+}
@@ -0,0 +1,98 @@
+/**
+ * Shutdown - Draining WebSocket clients on SIGTERM
+ * Redeploys stop the container; every client gets a reconnect hint and a
+ * 1012 close frame after its pending messages are written.
+ */
+
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"log"
+	"time"
+
+	"github.com/gorilla/websocket"
+)
+
+const (
+	// DefaultShutdownTimeout bounds how long draining clients may take
+	DefaultShutdownTimeout = 10 * time.Second
+
+	// reconnectHint tells clients when the restarted relay should be back
+	reconnectHint = 3 * time.Second
+)
+
+// Shutdown rejects new connections, tells every client the relay is
+// restarting and closes them once their queues are written. Clients that
+// haven't finished when ctx expires are closed forcefully.
+func (r *Relay) Shutdown(ctx context.Context) error {
+	r.mu.Lock()
+	r.closing = true
+	clients := make([]*Client, 0, len(r.clients))
+	for _, client := range r.clients {
+		clients = append(clients, client)
+	}
+	r.mu.Unlock()
+
+	log.Printf("[RELAY] Draining %d clients", len(clients))
+	notice, _ := json.Marshal(Message{
+		Type:       "server_restarting",
+		Message:    "Server restarting",
+		RetryAfter: int(reconnectHint / time.Millisecond),
+	})
+	for _, client := range clients {
+		// Native Moblin has no such message and reconnects on its own
+		if client.native == nil {
+			client.enqueueControl(notice)
+		}
+		client.drain(websocket.CloseServiceRestart, "server restarting")
+	}
+
+	ticker := time.NewTicker(50 * time.Millisecond)
+	defer ticker.Stop()
+	for {
+		r.mu.RLock()
+		remaining := len(r.clients)
+		r.mu.RUnlock()
+		if remaining == 0 {
+			return nil
+		}
+		select {
+		case <-ticker.C:
+		case <-ctx.Done():
+			log.Printf("[RELAY] %d clients did not drain in time", remaining)
+			for _, client := range clients {
+				client.Conn.Close()
+			}
+			return ctx.Err()
+		}
+	}
+}
+
+// isClosing reports whether the relay is shutting down
+func (r *Relay) isClosing() bool {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	return r.closing
+}
+
+// drain closes the client's queues; writePump writes what is left and
+// then sends a close frame with code and reason
+func (c *Client) drain(code int, reason string) {
+	c.mu.Lock()
+	c.closeCode = code
+	c.closeReason = reason
+	c.mu.Unlock()
+	c.closeQueues()
+}
+
+// closeMessage returns the close frame writePump sends once Send is closed
+func (c *Client) closeMessage() []byte {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.closeCode == 0 {
+		return []byte{}
+	}
+	return websocket.FormatCloseMessage(c.closeCode, c.closeReason)
+}
@@ -0,0 +1,148 @@
+/**
+ * Audit Store - Append-only log of control actions
+ * One JSON object per line in <data>/audit.jsonl. Entries are never
+ * rewritten or removed by the relay.
+ */
+
+package stores
+
+import (
+	"bufio"
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/volleybratans/moblin-relay/models"
+)
+
+const (
+	// DefaultAuditLimit is the number of entries returned without a limit
+	DefaultAuditLimit = 200
+
+	// MaxAuditLimit caps the number of entries returned by a query
+	MaxAuditLimit = 5000
+)
+
+// AuditStore appends audit entries to a JSONL file
+type AuditStore struct {
+	path string
+	file *os.File
+	mu   sync.Mutex
+}
+
+// NewAuditStore opens <dataDir>/audit.jsonl for appending
+func NewAuditStore(dataDir string) (*AuditStore, error) {
+	if err := os.MkdirAll(dataDir, 0755); err != nil {
+		return nil, err
+	}
+	path := filepath.Join(dataDir, "audit.jsonl")
+	file, err := os.OpenFile(path, os.O_CREATE|os.O_APPEND|os.O_WRONLY, 0644)
+	if err != nil {
+		return nil, err
+	}
+	return &AuditStore{path: path, file: file}, nil
+}
+
+// Append writes an entry, stamping it with the current time if unset
+func (s *AuditStore) Append(entry models.AuditEntry) error {
+	if entry.Time == "" {
+		entry.Time = time.Now().UTC().Format(time.RFC3339)
+	}
+	data, err := json.Marshal(entry)
+	if err != nil {
+		return err
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.file == nil {
+		return os.ErrClosed
+	}
+	_, err = s.file.Write(append(data, '\n'))
+	return err
+}
+
+// Close closes the log file; later appends fail
+func (s *AuditStore) Close() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.file == nil {
+		return nil
+	}
+	err := s.file.Close()
+	s.file = nil
+	return err
+}
+
+// Query returns the newest entries matching q, newest first
+func (s *AuditStore) Query(q models.AuditQuery) ([]models.AuditEntry, error) {
+	limit := q.Limit
+	if limit <= 0 {
+		limit = DefaultAuditLimit
+	}
+	if limit > MaxAuditLimit {
+		limit = MaxAuditLimit
+	}
+
+	file, err := os.Open(s.path)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+
+	// Keep the last `limit` matches in a ring while scanning oldest to newest
+	ring := make([]models.AuditEntry, 0, limit)
+	next := 0
+	scanner := bufio.NewScanner(file)
+	scanner.Buffer(make([]byte, 64*1024), 1024*1024)
+	for scanner.Scan() {
+		var entry models.AuditEntry
+		if err := json.Unmarshal(scanner.Bytes(), &entry); err != nil || !matchAudit(entry, q) {
+			continue
+		}
+		if len(ring) < limit {
+			ring = append(ring, entry)
+		} else {
+			ring[next] = entry
+		}
+		next = (next + 1) % limit
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+
+	entries := make([]models.AuditEntry, 0, len(ring))
+	for i := 0; i < len(ring); i++ {
+		entries = append(entries, ring[(next-1-i+2*len(ring))%len(ring)])
+	}
+	return entries, nil
+}
+
+func matchAudit(entry models.AuditEntry, q models.AuditQuery) bool {
+	if q.Action != "" && entry.Action != q.Action {
+		return false
+	}
+	// Entries keep a session prefix, so both the prefix and a full ID match
+	if q.SessionID != "" && (entry.SessionID == "" || !strings.HasPrefix(q.SessionID, entry.SessionID)) {
+		return false
+	}
+	if q.Device != "" && entry.Device != q.Device {
+		return false
+	}
+	if !q.From.IsZero() || !q.To.IsZero() {
+		t, err := time.Parse(time.RFC3339, entry.Time)
+		if err != nil {
+			return false
+		}
+		if !q.From.IsZero() && t.Before(q.From) {
+			return false
+		}
+		if !q.To.IsZero() && t.After(q.To) {
+			return false
+		}
+	}
+	return true
+}
@@ -2,8 +2,10 @@ package stores
 
 import (
 	"encoding/json"
+	"errors"
 	"fmt"
 	"io/ioutil"
+	"log"
 	"net/http"
 	"os"
 	"path/filepath"
@@ -12,6 +14,7 @@ import (
 	"sync"
 	"time"
 	"github.com/volleybratans/moblin-relay/models"
+	"github.com/volleybratans/moblin-relay/rules"
 )
 
 // MatchdayStore manages persistent storage of matchday state
@@ -19,9 +22,17 @@ type MatchdayStore struct {
 	dataDir     string
 	currentFile string
 	state       *models.MatchdayState
+	undo        []models.MatchScore // previous scores, newest last
 	mu          sync.RWMutex
 }
 
+// maxScoreUndo bounds the score changes that can be undone
+const maxScoreUndo = 200
+
+// ErrScoreNotSaved is returned when a score change could not be written to
+// disk; the change is rolled back
+var ErrScoreNotSaved = errors.New("score could not be saved")
+
 // NewMatchdayStore creates a new matchday store
 func NewMatchdayStore(dataDir string) (*MatchdayStore, error) {
 	if err := os.MkdirAll(dataDir, 0755); err != nil {
@@ -40,6 +51,7 @@ func NewMatchdayStore(dataDir string) (*MatchdayStore, error) {
 			HomeTeam:    "Heim",
 			AwayTeam:    "Gast",
 			Date:        time.Now().Format("2006-01-02"),
+			Score:       newScore(),
 		}
 	}
 
@@ -60,6 +72,9 @@ func (s *MatchdayStore) load() error {
 		return err
 	}
 
+	if state.Score.CurrentSet == 0 {
+		state.Score = newScore()
+	}
 	s.state = &state
 	return nil
 }
@@ -85,13 +100,149 @@ func (s *MatchdayStore) UpdateState(newState models.MatchdayState) error {
 	s.mu.Lock()
 	defer s.mu.Unlock()
 
+	// An omitted format keeps the current one
+	if newState.Format == "" {
+		newState.Format = s.state.Format
+	}
+	format, err := rules.Lookup(newState.Format)
+	if err != nil {
+		return err
+	}
+
 	newState.Version = s.state.Version + 1
 	newState.LastUpdated = time.Now().UTC().Format(time.RFC3339)
+	// The score only changes through the score methods; set and match
+	// balls follow the format
+	newState.Score = s.state.Score
+	format.Annotate(&newState.Score)
 	s.state = &newState
 
 	return s.save()
 }
 
+// Flush writes the current state to disk
+func (s *MatchdayStore) Flush() error {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	if s.state == nil {
+		return nil
+	}
+	return s.save()
+}
+
+func newScore() models.MatchScore {
+	return models.MatchScore{CurrentSet: 1, SetHistory: []models.SetScore{}}
+}
+
+// AddPoint adds delta (1 or -1) points to a team. Points are checked
+// against the rules of the match format; the returned match events
+// (side_switch) are for automation.
+func (s *MatchdayStore) AddPoint(team string, delta int) (models.MatchdayState, []string, error) {
+	if delta != 1 && delta != -1 {
+		return models.MatchdayState{}, nil, fmt.Errorf("delta must be 1 or -1")
+	}
+	return s.updateScore(func(format rules.Format, score *models.MatchScore) ([]string, error) {
+		if delta < 0 {
+			return nil, format.RemovePoint(score, team)
+		}
+		return format.Point(score, team)
+	})
+}
+
+// EndSet closes the current set once a team has won it under the rules
+// and starts the next one. It returns set_end, and match_end when the set
+// decides the match.
+func (s *MatchdayStore) EndSet() (models.MatchdayState, []string, error) {
+	return s.updateScore(func(format rules.Format, score *models.MatchScore) ([]string, error) {
+		return format.EndSet(score)
+	})
+}
+
+// Serve sets the team serving the first rally of the current set
+func (s *MatchdayStore) Serve(team string) (models.MatchdayState, error) {
+	state, _, err := s.updateScore(func(format rules.Format, score *models.MatchScore) ([]string, error) {
+		return nil, format.Serve(score, team)
+	})
+	return state, err
+}
+
+// Timeout records a timeout for a team, limited per set by the format
+func (s *MatchdayStore) Timeout(team string) (models.MatchdayState, []string, error) {
+	return s.updateScore(func(format rules.Format, score *models.MatchScore) ([]string, error) {
+		return format.Timeout(score, team)
+	})
+}
+
+// ResetScore starts the match over at 0:0 in set 1
+func (s *MatchdayStore) ResetScore() (models.MatchdayState, error) {
+	state, _, err := s.updateScore(func(format rules.Format, score *models.MatchScore) ([]string, error) {
+		*score = newScore()
+		return nil, nil
+	})
+	return state, err
+}
+
+// UndoScore reverts the last score change
+func (s *MatchdayStore) UndoScore() (models.MatchdayState, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if len(s.undo) == 0 {
+		return *s.state, fmt.Errorf("nothing to undo")
+	}
+	previous, undo := s.state.Score, s.undo
+	s.state.Score = s.undo[len(s.undo)-1]
+	s.undo = s.undo[:len(s.undo)-1]
+	return s.commitScore(previous, undo)
+}
+
+// updateScore applies change to a copy of the score under the rules of
+// the match format and commits it, returning the match events of the
+// change. The previous score can be restored with UndoScore.
+func (s *MatchdayStore) updateScore(change func(format rules.Format, score *models.MatchScore) ([]string, error)) (models.MatchdayState, []string, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	format, err := rules.Lookup(s.state.Format)
+	if err != nil {
+		return *s.state, nil, err
+	}
+	previous := s.state.Score
+	score := previous
+	score.SetHistory = append([]models.SetScore{}, previous.SetHistory...)
+	events, err := change(format, &score)
+	if err != nil {
+		return *s.state, nil, err
+	}
+	undo := s.undo
+	s.undo = append(s.undo, previous)
+	if len(s.undo) > maxScoreUndo {
+		s.undo = s.undo[len(s.undo)-maxScoreUndo:]
+	}
+	s.state.Score = score
+	state, err := s.commitScore(previous, undo)
+	if err != nil {
+		return state, nil, err
+	}
+	return state, events, nil
+}
+
+// commitScore bumps the version and saves. If the save fails, the previous
+// score and undo history are restored, so clients never see a score that
+// is not on disk. Caller must hold s.mu.
+func (s *MatchdayStore) commitScore(previous models.MatchScore, undo []models.MatchScore) (models.MatchdayState, error) {
+	version, updated := s.state.Version, s.state.LastUpdated
+	s.state.Version++
+	s.state.LastUpdated = time.Now().UTC().Format(time.RFC3339)
+	if err := s.save(); err != nil {
+		log.Printf("[MATCHDAY] Failed to save score: %v", err)
+		s.state.Score, s.state.Version, s.state.LastUpdated = previous, version, updated
+		s.undo = undo
+		return *s.state, fmt.Errorf("%w: %v", ErrScoreNotSaved, err)
+	}
+	return *s.state, nil
+}
+
 // ParseDVV fetches a DVV ticker URL and attempts to extract match info
 // TODO: Move this to a separate service package as per Moneyball patterns
 func (s *MatchdayStore) ParseDVV(urlStr string) (models.MatchdayState, error) {
@@ -0,0 +1,199 @@
+package stores
+
+import (
+	"reflect"
+	"testing"
+
+	"github.com/volleybratans/moblin-relay/models"
+	"github.com/volleybratans/moblin-relay/protocol"
+)
+
+func newTestMatchdayStore(t *testing.T) *MatchdayStore {
+	t.Helper()
+	store, err := NewMatchdayStore(t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+	return store
+}
+
+func points(t *testing.T, store *MatchdayStore, team string, n int) models.MatchdayState {
+	t.Helper()
+	var state models.MatchdayState
+	for i := 0; i < n; i++ {
+		var err error
+		if state, _, err = store.AddPoint(team, 1); err != nil {
+			t.Fatalf("point %d for %s: %v", i+1, team, err)
+		}
+	}
+	return state
+}
+
+func TestMatchdayStoreAddPoint(t *testing.T) {
+	tests := []struct {
+		name    string
+		team    string
+		delta   int
+		wantErr bool
+		want    [2]int
+	}{
+		{"home point", "home", 1, false, [2]int{1, 0}},
+		{"away point", "away", 1, false, [2]int{0, 1}},
+		{"remove below zero", "home", -1, true, [2]int{0, 0}},
+		{"invalid delta", "home", 2, true, [2]int{0, 0}},
+		{"unknown team", "gast", 1, true, [2]int{0, 0}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			store := newTestMatchdayStore(t)
+			version := store.GetState().Version
+			_, _, err := store.AddPoint(tt.team, tt.delta)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("error %v, want error %v", err, tt.wantErr)
+			}
+			state := store.GetState()
+			if got := [2]int{state.Score.HomePoints, state.Score.AwayPoints}; got != tt.want {
+				t.Errorf("points %v, want %v", got, tt.want)
+			}
+			wantVersion := version + 1
+			if tt.wantErr {
+				wantVersion = version
+			}
+			if state.Version != wantVersion {
+				t.Errorf("version %d, want %d", state.Version, wantVersion)
+			}
+		})
+	}
+}
+
+func TestMatchdayStoreUndo(t *testing.T) {
+	store := newTestMatchdayStore(t)
+	if _, err := store.UndoScore(); err == nil {
+		t.Error("undo without changes accepted")
+	}
+
+	points(t, store, "home", 2)
+	before := store.GetState().Score
+	points(t, store, "away", 1)
+	state, err := store.UndoScore()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(state.Score, before) {
+		t.Errorf("score after undo %+v, want %+v", state.Score, before)
+	}
+
+	// Undo reverts a set end including the side, serve and timeout state
+	points(t, store, "home", 22)
+	if _, _, err := store.Timeout("away"); err != nil {
+		t.Fatal(err)
+	}
+	points(t, store, "home", 1)
+	beforeSet := store.GetState().Score
+	if _, _, err := store.EndSet(); err != nil {
+		t.Fatal(err)
+	}
+	state, err = store.UndoScore()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(state.Score, beforeSet) {
+		t.Errorf("score after undoing the set end %+v, want %+v", state.Score, beforeSet)
+	}
+
+	// Undo reverts a reset
+	if _, err := store.ResetScore(); err != nil {
+		t.Fatal(err)
+	}
+	if state, _ = store.UndoScore(); !reflect.DeepEqual(state.Score, beforeSet) {
+		t.Errorf("score after undoing the reset %+v, want %+v", state.Score, beforeSet)
+	}
+}
+
+func TestMatchdayStoreEndSet(t *testing.T) {
+	store := newTestMatchdayStore(t)
+	points(t, store, "home", 24)
+	points(t, store, "away", 24)
+	if _, _, err := store.EndSet(); err == nil {
+		t.Fatal("set ended at 24:24")
+	}
+	points(t, store, "home", 2)
+	if _, _, err := store.AddPoint("away", 1); err == nil {
+		t.Fatal("point accepted after the set was won")
+	}
+
+	state, events, err := store.EndSet()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(events, []string{protocol.MatchSetEnd}) {
+		t.Errorf("events %v, want set_end", events)
+	}
+	score := state.Score
+	if score.HomeSets != 1 || score.CurrentSet != 2 || !reflect.DeepEqual(score.SetHistory, []models.SetScore{{Home: 26, Away: 24}}) {
+		t.Errorf("score after set end %+v", score)
+	}
+
+	// Two more sets decide the match
+	for set := 2; set <= 3; set++ {
+		points(t, store, "home", 25)
+		state, events, err = store.EndSet()
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	if !reflect.DeepEqual(events, []string{protocol.MatchSetEnd, protocol.MatchEnd}) {
+		t.Errorf("events %v, want set_end and match_end", events)
+	}
+	if !state.Score.Finished || state.Score.Winner != "home" {
+		t.Errorf("match not finished: %+v", state.Score)
+	}
+	if _, _, err := store.AddPoint("home", 1); err == nil {
+		t.Error("point accepted after the match")
+	}
+}
+
+func TestMatchdayStoreFormat(t *testing.T) {
+	store := newTestMatchdayStore(t)
+	if err := store.UpdateState(models.MatchdayState{HomeTeam: "A", AwayTeam: "B", Format: "beach"}); err != nil {
+		t.Fatal(err)
+	}
+	state := points(t, store, "away", 20)
+	if state.Score.SetBall != "away" {
+		t.Errorf("set ball %q at 0:20 in beach, want away", state.Score.SetBall)
+	}
+
+	// Saving without a format keeps it, an unknown one is rejected
+	if err := store.UpdateState(models.MatchdayState{HomeTeam: "A", AwayTeam: "B"}); err != nil {
+		t.Fatal(err)
+	}
+	if got := store.GetState().Format; got != "beach" {
+		t.Errorf("format %q after saving without one, want beach", got)
+	}
+	if err := store.UpdateState(models.MatchdayState{Format: "squash"}); err == nil {
+		t.Error("unknown format accepted")
+	}
+	if got := store.GetState().Score.AwayPoints; got != 20 {
+		t.Errorf("saving the matchday changed the score to %d", got)
+	}
+}
+
+func TestMatchdayStorePersistsScore(t *testing.T) {
+	dir := t.TempDir()
+	store, err := NewMatchdayStore(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	points(t, store, "home", 3)
+
+	reloaded, err := NewMatchdayStore(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := reloaded.GetState().Score.HomePoints; got != 3 {
+		t.Errorf("reloaded home points %d, want 3", got)
+	}
+	if _, err := reloaded.UndoScore(); err == nil {
+		t.Error("undo history survived a restart")
+	}
+}
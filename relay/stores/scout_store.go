@@ -111,6 +111,13 @@ func (s *ScoutStore) UpdateState(newState models.ScoutState) error {
 	return s.save()
 }
 
+// Flush writes the current state to disk
+func (s *ScoutStore) Flush() error {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return s.save()
+}
+
 func (s *ScoutStore) ArchiveMatch() error {
 	s.mu.Lock()
 	defer s.mu.Unlock()
@@ -0,0 +1,189 @@
+/**
+ * Stream State Store - Last known Moblin device state
+ * Kept in memory, rebuilt from Moblin status messages
+ */
+
+package stores
+
+import (
+	"encoding/json"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/volleybratans/moblin-relay/models"
+)
+
+// StreamStore caches the latest state reported by each Moblin device
+type StreamStore struct {
+	devices     map[string]*models.StreamState
+	lastUpdated string
+	mu          sync.RWMutex
+}
+
+// streamEvent covers all Moblin -> browser messages that carry state.
+// Pointer fields distinguish "not sent" from zero values.
+type streamEvent struct {
+	Type         string                      `json:"type"`
+	Scene        *string                     `json:"scene"`
+	Bitrate      *int                        `json:"bitrate"`
+	FPS          *int                        `json:"fps"`
+	Battery      *int                        `json:"battery"`
+	Viewers      *int                        `json:"viewers"`
+	ThermalState *string                     `json:"thermal_state"`
+	UploadStats  map[string]models.LinkStats `json:"upload_stats"`
+	Recording    *bool                       `json:"recording"`
+	Muted        *bool                       `json:"muted"`
+	Enabled      *bool                       `json:"enabled"`
+}
+
+// NewStreamStore creates an empty stream state store
+func NewStreamStore() *StreamStore {
+	return &StreamStore{
+		devices:     make(map[string]*models.StreamState),
+		lastUpdated: time.Now().UTC().Format(time.RFC3339),
+	}
+}
+
+// device returns the state entry for a device, creating it if needed.
+// Caller must hold s.mu.
+func (s *StreamStore) device(name string) *models.StreamState {
+	state, ok := s.devices[name]
+	if !ok {
+		state = &models.StreamState{Device: name}
+		s.devices[name] = state
+	}
+	return state
+}
+
+func (s *StreamStore) touch(state *models.StreamState) {
+	s.lastUpdated = time.Now().UTC().Format(time.RFC3339)
+	state.LastUpdated = s.lastUpdated
+}
+
+// SetConnected records a Moblin device connecting or disconnecting
+func (s *StreamStore) SetConnected(device string, connected bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	state := s.device(device)
+	state.Connected = connected
+	if !connected {
+		state.Live = false
+	}
+	s.touch(state)
+}
+
+// Apply updates a device's state from a raw Moblin message.
+// Returns false if the message does not carry state.
+func (s *StreamStore) Apply(device string, raw []byte) bool {
+	var ev streamEvent
+	if err := json.Unmarshal(raw, &ev); err != nil {
+		return false
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	state := s.device(device)
+
+	switch ev.Type {
+	case "status", "stream_info":
+		setInt(&state.Bitrate, ev.Bitrate)
+		setInt(&state.FPS, ev.FPS)
+		setInt(&state.Battery, ev.Battery)
+		setInt(&state.Viewers, ev.Viewers)
+		setString(&state.ThermalState, ev.ThermalState)
+		if ev.UploadStats != nil {
+			state.UploadStats = ev.UploadStats
+		}
+	case "scene_changed":
+		setString(&state.Scene, ev.Scene)
+	case "stream_started":
+		state.Live = true
+	case "stream_ended":
+		state.Live = false
+	case "thermal_update":
+		setString(&state.ThermalState, ev.ThermalState)
+	case "upload_stats":
+		if ev.UploadStats != nil {
+			state.UploadStats = ev.UploadStats
+		}
+	case "recording_state":
+		setBool(&state.Recording, ev.Recording)
+	case "mic_state":
+		setBool(&state.MicMuted, ev.Muted)
+	case "torch_state":
+		setBool(&state.TorchEnabled, ev.Enabled)
+	default:
+		return false
+	}
+
+	s.touch(state)
+	return true
+}
+
+// GetDevice returns the last known state of a single device
+func (s *StreamStore) GetDevice(name string) (models.StreamState, bool) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	state, ok := s.devices[name]
+	if !ok {
+		return models.StreamState{}, false
+	}
+	return copyState(state), true
+}
+
+// DeviceNames returns the names of all known devices
+func (s *StreamStore) DeviceNames() []string {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	names := make([]string, 0, len(s.devices))
+	for name := range s.devices {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// GetSnapshot returns a copy of the state of all known devices
+func (s *StreamStore) GetSnapshot() models.StreamSnapshot {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	snapshot := models.StreamSnapshot{
+		LastUpdated: s.lastUpdated,
+		Devices:     make(map[string]models.StreamState, len(s.devices)),
+	}
+	for name, state := range s.devices {
+		snapshot.Devices[name] = copyState(state)
+	}
+	return snapshot
+}
+
+// copyState returns a deep copy of a device state
+func copyState(state *models.StreamState) models.StreamState {
+	copied := *state
+	if state.UploadStats != nil {
+		copied.UploadStats = make(map[string]models.LinkStats, len(state.UploadStats))
+		for link, stats := range state.UploadStats {
+			copied.UploadStats[link] = stats
+		}
+	}
+	return copied
+}
+
+func setInt(dst *int, v *int) {
+	if v != nil {
+		*dst = *v
+	}
+}
+
+func setString(dst *string, v *string) {
+	if v != nil {
+		*dst = *v
+	}
+}
+
+func setBool(dst *bool, v *bool) {
+	if v != nil {
+		*dst = *v
+	}
+}
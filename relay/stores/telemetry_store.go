@@ -0,0 +1,428 @@
+/**
+ * Telemetry Store - Stream telemetry history per Moblin device
+ * One JSONL file per stream session in <data>/telemetry. Files older than a
+ * day are compacted to one sample per minute, files past the retention
+ * period are deleted.
+ */
+
+package stores
+
+import (
+	"bufio"
+	"encoding/json"
+	"log"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/volleybratans/moblin-relay/models"
+)
+
+const (
+	// DefaultTelemetryRetention is how long session files are kept
+	DefaultTelemetryRetention = 30 * 24 * time.Hour
+
+	// telemetryInterval is the minimum spacing of raw samples per device
+	telemetryInterval = time.Second
+
+	// Closed sessions older than compactAfter are downsampled to compactResolution
+	compactAfter      = 24 * time.Hour
+	compactResolution = time.Minute
+	compactSuffix     = ".1m.jsonl"
+
+	// Session files are named with millisecond precision; the parse layout
+	// also accepts the whole-second names of older files
+	sessionNameFormat = "20060102T150405.000Z"
+	sessionTimeFormat = "20060102T150405Z"
+)
+
+// Metrics of a sample that Record can be told were reported
+const (
+	TelemetryBitrate = "bitrate"
+	TelemetryFPS     = "fps"
+	TelemetryBattery = "battery"
+	TelemetryViewers = "viewers"
+)
+
+// thermalSeverity orders Moblin thermal states for downsampling
+var thermalSeverity = map[string]int{"nominal": 0, "fair": 1, "serious": 2, "critical": 3}
+
+// telemetrySession is an open session file of a single device
+type telemetrySession struct {
+	file       *os.File
+	name       string
+	lastSample time.Time
+}
+
+// TelemetryStore records stream telemetry as a time series
+type TelemetryStore struct {
+	dir       string
+	retention time.Duration
+	sessions  map[string]*telemetrySession
+	reported  map[string]map[string]bool // device -> metrics Moblin has reported
+	mu        sync.Mutex
+}
+
+// NewTelemetryStore creates a telemetry store in <dataDir>/telemetry
+func NewTelemetryStore(dataDir string, retention time.Duration) (*TelemetryStore, error) {
+	dir := filepath.Join(dataDir, "telemetry")
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return nil, err
+	}
+	if retention <= 0 {
+		retention = DefaultTelemetryRetention
+	}
+	store := &TelemetryStore{
+		dir:       dir,
+		retention: retention,
+		sessions:  make(map[string]*telemetrySession),
+		reported:  make(map[string]map[string]bool),
+	}
+	go store.maintenanceLoop()
+	return store, nil
+}
+
+// StartSession closes the device's current session; the next sample opens a new one
+func (s *TelemetryStore) StartSession(device string) {
+	s.EndSession(device)
+}
+
+// EndSession closes the device's current session file
+func (s *TelemetryStore) EndSession(device string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if session, ok := s.sessions[device]; ok {
+		session.file.Close()
+		delete(s.sessions, device)
+	}
+}
+
+// Record appends the device's current state to its session, at most once
+// per telemetryInterval. Metrics are only recorded once they have been
+// reported, so a device without a battery level does not record 0%.
+func (s *TelemetryStore) Record(device string, state models.StreamState, reported ...string) error {
+	now := time.Now().UTC()
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	seen, ok := s.reported[device]
+	if !ok {
+		seen = make(map[string]bool)
+		s.reported[device] = seen
+	}
+	for _, metric := range reported {
+		seen[metric] = true
+	}
+
+	session, ok := s.sessions[device]
+	if ok && now.Sub(session.lastSample) < telemetryInterval {
+		return nil
+	}
+	if !ok {
+		var err error
+		if session, err = s.openSession(device, now); err != nil {
+			return err
+		}
+		s.sessions[device] = session
+	}
+
+	data, err := json.Marshal(models.TelemetrySample{
+		Time:         now.Format(time.RFC3339),
+		Bitrate:      reportedValue(seen, TelemetryBitrate, state.Bitrate),
+		FPS:          reportedValue(seen, TelemetryFPS, state.FPS),
+		Battery:      reportedValue(seen, TelemetryBattery, state.Battery),
+		Viewers:      reportedValue(seen, TelemetryViewers, state.Viewers),
+		ThermalState: state.ThermalState,
+		UploadStats:  state.UploadStats,
+	})
+	if err != nil {
+		return err
+	}
+	session.lastSample = now
+	_, err = session.file.Write(append(data, '\n'))
+	return err
+}
+
+// openSession creates a new session file. A session started within the
+// same millisecond as the previous one gets the next free name.
+// Caller must hold s.mu.
+func (s *TelemetryStore) openSession(device string, started time.Time) (*telemetrySession, error) {
+	for {
+		name := device + "_" + started.Format(sessionNameFormat) + ".jsonl"
+		file, err := os.OpenFile(filepath.Join(s.dir, name), os.O_CREATE|os.O_EXCL|os.O_WRONLY, 0644)
+		if os.IsExist(err) {
+			started = started.Add(time.Millisecond)
+			continue
+		}
+		if err != nil {
+			return nil, err
+		}
+		return &telemetrySession{file: file, name: name}, nil
+	}
+}
+
+func reportedValue(reported map[string]bool, metric string, v int) *int {
+	if !reported[metric] {
+		return nil
+	}
+	return &v
+}
+
+// Close closes all open session files
+func (s *TelemetryStore) Close() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for device, session := range s.sessions {
+		session.file.Close()
+		delete(s.sessions, device)
+	}
+}
+
+// Query returns the sessions of device ("" for all devices) overlapping
+// [from, to], downsampled to resolution (0 keeps raw samples)
+func (s *TelemetryStore) Query(device string, from, to time.Time, resolution time.Duration) ([]models.TelemetrySession, error) {
+	entries, err := os.ReadDir(s.dir)
+	if err != nil {
+		return nil, err
+	}
+
+	sessions := []models.TelemetrySession{}
+	for _, entry := range entries {
+		name, started, ok := parseSessionName(entry.Name())
+		if !ok || (device != "" && name != device) || started.After(to) {
+			continue
+		}
+		if info, err := entry.Info(); err != nil || info.ModTime().Before(from) {
+			continue
+		}
+
+		samples, err := readSamples(filepath.Join(s.dir, entry.Name()))
+		if err != nil {
+			log.Printf("[TELEMETRY] Failed to read %s: %v", entry.Name(), err)
+			continue
+		}
+		samples = filterSamples(samples, from, to)
+		if len(samples) == 0 {
+			continue
+		}
+		if resolution > 0 {
+			samples = downsample(samples, resolution)
+		}
+		sessions = append(sessions, models.TelemetrySession{
+			Device:  name,
+			Started: samples[0].Time,
+			Ended:   samples[len(samples)-1].Time,
+			Samples: samples,
+		})
+	}
+
+	// Stable, so sessions started within the same second keep file name order
+	sort.SliceStable(sessions, func(i, j int) bool {
+		return sessions[i].Started < sessions[j].Started
+	})
+	return sessions, nil
+}
+
+// maintenanceLoop applies compaction and retention hourly
+func (s *TelemetryStore) maintenanceLoop() {
+	s.maintain()
+	for range time.NewTicker(time.Hour).C {
+		s.maintain()
+	}
+}
+
+func (s *TelemetryStore) maintain() {
+	entries, err := os.ReadDir(s.dir)
+	if err != nil {
+		return
+	}
+	for _, entry := range entries {
+		if _, _, ok := parseSessionName(entry.Name()); !ok {
+			continue
+		}
+		info, err := entry.Info()
+		if err != nil || s.isOpen(entry.Name()) {
+			continue
+		}
+		path := filepath.Join(s.dir, entry.Name())
+		age := time.Since(info.ModTime())
+		switch {
+		case age > s.retention:
+			os.Remove(path)
+		case age > compactAfter && !strings.HasSuffix(entry.Name(), compactSuffix):
+			if err := compact(path); err != nil {
+				log.Printf("[TELEMETRY] Failed to compact %s: %v", entry.Name(), err)
+			}
+		}
+	}
+}
+
+// isOpen reports whether a session file is still being written
+func (s *TelemetryStore) isOpen(name string) bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for _, session := range s.sessions {
+		if session.name == name {
+			return true
+		}
+	}
+	return false
+}
+
+// compact rewrites a session file at compactResolution
+func compact(path string) error {
+	samples, err := readSamples(path)
+	if err != nil {
+		return err
+	}
+	var out []byte
+	for _, sample := range downsample(samples, compactResolution) {
+		data, _ := json.Marshal(sample)
+		out = append(append(out, data...), '\n')
+	}
+	target := strings.TrimSuffix(path, ".jsonl") + compactSuffix
+	if err := os.WriteFile(target, out, 0644); err != nil {
+		return err
+	}
+	return os.Remove(path)
+}
+
+// parseSessionName splits "<device>_<start>[.1m].jsonl"
+func parseSessionName(filename string) (string, time.Time, bool) {
+	base := strings.TrimSuffix(filename, compactSuffix)
+	if base == filename {
+		base = strings.TrimSuffix(filename, ".jsonl")
+		if base == filename {
+			return "", time.Time{}, false
+		}
+	}
+	i := strings.LastIndex(base, "_")
+	if i <= 0 {
+		return "", time.Time{}, false
+	}
+	started, err := time.Parse(sessionTimeFormat, base[i+1:])
+	if err != nil {
+		return "", time.Time{}, false
+	}
+	return base[:i], started, true
+}
+
+// readSamples reads a session file, skipping malformed lines
+func readSamples(path string) ([]models.TelemetrySample, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+
+	var samples []models.TelemetrySample
+	scanner := bufio.NewScanner(file)
+	for scanner.Scan() {
+		var sample models.TelemetrySample
+		if err := json.Unmarshal(scanner.Bytes(), &sample); err != nil {
+			continue
+		}
+		samples = append(samples, sample)
+	}
+	return samples, scanner.Err()
+}
+
+func filterSamples(samples []models.TelemetrySample, from, to time.Time) []models.TelemetrySample {
+	var filtered []models.TelemetrySample
+	for _, sample := range samples {
+		t, err := time.Parse(time.RFC3339, sample.Time)
+		if err != nil || t.Before(from) || t.After(to) {
+			continue
+		}
+		filtered = append(filtered, sample)
+	}
+	return filtered
+}
+
+// downsample averages samples into buckets of resolution; thermal state
+// keeps the worst value so short spikes stay visible
+func downsample(samples []models.TelemetrySample, resolution time.Duration) []models.TelemetrySample {
+	var result []models.TelemetrySample
+	var bucket []models.TelemetrySample
+	var bucketStart time.Time
+
+	flush := func() {
+		if len(bucket) > 0 {
+			sample := average(bucket)
+			sample.Time = bucketStart.Format(time.RFC3339)
+			result = append(result, sample)
+		}
+		bucket = bucket[:0]
+	}
+
+	for _, sample := range samples {
+		t, err := time.Parse(time.RFC3339, sample.Time)
+		if err != nil {
+			continue
+		}
+		start := t.Truncate(resolution)
+		if !start.Equal(bucketStart) {
+			flush()
+			bucketStart = start
+		}
+		bucket = append(bucket, sample)
+	}
+	flush()
+	return result
+}
+
+// average averages each metric over the samples that reported it
+func average(samples []models.TelemetrySample) models.TelemetrySample {
+	var result models.TelemetrySample
+	var bitrate, fps, battery, viewers metricSum
+	links := make(map[string][3]int) // kbps sum, rtt sum, count
+	for _, sample := range samples {
+		bitrate.add(sample.Bitrate)
+		fps.add(sample.FPS)
+		battery.add(sample.Battery)
+		viewers.add(sample.Viewers)
+		if thermalSeverity[sample.ThermalState] >= thermalSeverity[result.ThermalState] && sample.ThermalState != "" {
+			result.ThermalState = sample.ThermalState
+		}
+		for link, stats := range sample.UploadStats {
+			sum := links[link]
+			links[link] = [3]int{sum[0] + stats.Kbps, sum[1] + stats.RTT, sum[2] + 1}
+		}
+	}
+	result.Bitrate = bitrate.average()
+	result.FPS = fps.average()
+	result.Battery = battery.average()
+	result.Viewers = viewers.average()
+	if len(links) > 0 {
+		result.UploadStats = make(map[string]models.LinkStats, len(links))
+		for link, sum := range links {
+			result.UploadStats[link] = models.LinkStats{Kbps: sum[0] / sum[2], RTT: sum[1] / sum[2]}
+		}
+	}
+	return result
+}
+
+// metricSum sums the reported values of a metric
+type metricSum struct {
+	sum, count int
+}
+
+func (m *metricSum) add(v *int) {
+	if v != nil {
+		m.sum += *v
+		m.count++
+	}
+}
+
+// average returns nil if no sample reported the metric
+func (m metricSum) average() *int {
+	if m.count == 0 {
+		return nil
+	}
+	avg := m.sum / m.count
+	return &avg
+}
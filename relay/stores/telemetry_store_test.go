@@ -0,0 +1,136 @@
+package stores
+
+import (
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/volleybratans/moblin-relay/models"
+)
+
+func newTestTelemetryStore(t *testing.T) *TelemetryStore {
+	t.Helper()
+	store, err := NewTelemetryStore(t.TempDir(), 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(store.Close)
+	return store
+}
+
+func intPtr(v int) *int { return &v }
+
+func TestTelemetryAverage(t *testing.T) {
+	tests := []struct {
+		name    string
+		samples []models.TelemetrySample
+		want    models.TelemetrySample
+	}{
+		{
+			name: "all reported",
+			samples: []models.TelemetrySample{
+				{Bitrate: intPtr(6000), FPS: intPtr(30), Battery: intPtr(80), Viewers: intPtr(10)},
+				{Bitrate: intPtr(4000), FPS: intPtr(30), Battery: intPtr(78), Viewers: intPtr(20)},
+			},
+			want: models.TelemetrySample{Bitrate: intPtr(5000), FPS: intPtr(30), Battery: intPtr(79), Viewers: intPtr(15)},
+		},
+		{
+			name: "partly reported",
+			samples: []models.TelemetrySample{
+				{Bitrate: intPtr(6000), Battery: intPtr(80)},
+				{Bitrate: intPtr(4000)},
+				{Bitrate: intPtr(5000), Battery: intPtr(70)},
+			},
+			want: models.TelemetrySample{Bitrate: intPtr(5000), Battery: intPtr(75)},
+		},
+		{
+			name:    "never reported",
+			samples: []models.TelemetrySample{{ThermalState: "fair"}, {ThermalState: "serious"}, {}},
+			want:    models.TelemetrySample{ThermalState: "serious"},
+		},
+		{
+			name: "links",
+			samples: []models.TelemetrySample{
+				{UploadStats: map[string]models.LinkStats{"lte": {Kbps: 4000, RTT: 40}}},
+				{UploadStats: map[string]models.LinkStats{"lte": {Kbps: 2000, RTT: 60}, "wifi": {Kbps: 1000, RTT: 10}}},
+			},
+			want: models.TelemetrySample{UploadStats: map[string]models.LinkStats{"lte": {Kbps: 3000, RTT: 50}, "wifi": {Kbps: 1000, RTT: 10}}},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := average(tt.samples); !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("average = %+v, want %+v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestTelemetryRecordReported(t *testing.T) {
+	store := newTestTelemetryStore(t)
+	state := models.StreamState{Bitrate: 6000, FPS: 30}
+	if err := store.Record("main", state, TelemetryBitrate, TelemetryFPS); err != nil {
+		t.Fatal(err)
+	}
+	store.EndSession("main")
+
+	// Metrics reported earlier stay recorded in later sessions
+	state.Battery = 55
+	if err := store.Record("main", state, TelemetryBattery); err != nil {
+		t.Fatal(err)
+	}
+	store.EndSession("main")
+
+	sessions, err := store.Query("main", time.Now().Add(-time.Minute), time.Now().Add(time.Minute), 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(sessions) != 2 {
+		t.Fatalf("%d sessions, want 2", len(sessions))
+	}
+	want := []models.TelemetrySample{
+		{Bitrate: intPtr(6000), FPS: intPtr(30)},
+		{Bitrate: intPtr(6000), FPS: intPtr(30), Battery: intPtr(55)},
+	}
+	for i, session := range sessions {
+		got := session.Samples[0]
+		got.Time = ""
+		if !reflect.DeepEqual(got, want[i]) {
+			t.Errorf("session %d sample %+v, want %+v", i+1, got, want[i])
+		}
+	}
+}
+
+func TestTelemetrySessionNames(t *testing.T) {
+	store := newTestTelemetryStore(t)
+	names := map[string]bool{}
+	for i := 0; i < 3; i++ {
+		if err := store.Record("main", models.StreamState{}); err != nil {
+			t.Fatal(err)
+		}
+		names[store.sessions["main"].name] = true
+		store.StartSession("main")
+	}
+	if len(names) != 3 {
+		t.Errorf("sessions started back to back share files: %v", names)
+	}
+
+	tests := []struct {
+		filename string
+		device   string
+		started  time.Time
+		ok       bool
+	}{
+		{"main_20260119T130830.412Z.jsonl", "main", time.Date(2026, 1, 19, 13, 8, 30, 412e6, time.UTC), true},
+		{"main_20260119T130830Z.jsonl", "main", time.Date(2026, 1, 19, 13, 8, 30, 0, time.UTC), true},
+		{"cam_2_20260119T130830.412Z.1m.jsonl", "cam_2", time.Date(2026, 1, 19, 13, 8, 30, 412e6, time.UTC), true},
+		{"main_20260119.jsonl", "", time.Time{}, false},
+		{"main_20260119T130830Z.json", "", time.Time{}, false},
+	}
+	for _, tt := range tests {
+		device, started, ok := parseSessionName(tt.filename)
+		if device != tt.device || !started.Equal(tt.started) || ok != tt.ok {
+			t.Errorf("parseSessionName(%s) = %s, %s, %v", tt.filename, device, started, ok)
+		}
+	}
+}
@@ -0,0 +1,54 @@
+/**
+ * Telemetry - Records Moblin stream_info as history per stream session
+ */
+
+package main
+
+import (
+	"log"
+
+	"github.com/volleybratans/moblin-relay/protocol"
+	"github.com/volleybratans/moblin-relay/stores"
+)
+
+// recordTelemetry updates the device's telemetry session after an event
+// has been applied to the stream store
+func (r *Relay) recordTelemetry(device string, ev protocol.Event) {
+	if r.telemetry == nil {
+		return
+	}
+	switch ev.(type) {
+	case *protocol.StreamStarted:
+		r.telemetry.StartSession(device)
+	case *protocol.StreamEnded:
+		r.telemetry.EndSession(device)
+	case *protocol.StreamInfo, *protocol.ThermalUpdate, *protocol.UploadStats:
+		state, ok := r.streams.GetDevice(device)
+		if !ok {
+			return
+		}
+		if err := r.telemetry.Record(device, state, reportedMetrics(ev)...); err != nil {
+			log.Printf("[TELEMETRY] Failed to record %s: %v", device, err)
+		}
+	}
+}
+
+// reportedMetrics lists the telemetry metrics an event carries
+func reportedMetrics(ev protocol.Event) []string {
+	info, ok := ev.(*protocol.StreamInfo)
+	if !ok {
+		return nil
+	}
+	var metrics []string
+	for metric, v := range map[string]*int{
+		stores.TelemetryBitrate: info.Bitrate,
+		stores.TelemetryFPS:     info.FPS,
+		stores.TelemetryBattery: info.Battery,
+		stores.TelemetryViewers: info.Viewers,
+	} {
+		if v != nil {
+			metrics = append(metrics, metric)
+		}
+	}
+	return metrics
+}
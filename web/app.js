@@ -273,6 +273,31 @@ class AnalyticsEngine {
 
 const analytics = new AnalyticsEngine();
 
+// ============================================
+// Relay Authentication - HMAC challenge/response
+// ============================================
+const toHex = (buffer) => Array.from(new Uint8Array(buffer))
+    .map(b => b.toString(16).padStart(2, '0'))
+    .join('');
+
+/**
+ * Answer the relay's challenge without sending the password:
+ * hex(HMAC-SHA256(key = hex(SHA256(salt + password)), challenge))
+ */
+async function computeAuthResponse(password, salt, challenge) {
+    const encoder = new TextEncoder();
+    const keyHash = await crypto.subtle.digest('SHA-256', encoder.encode(salt + password));
+    const key = await crypto.subtle.importKey(
+        'raw',
+        encoder.encode(toHex(keyHash)),
+        { name: 'HMAC', hash: 'SHA-256' },
+        false,
+        ['sign']
+    );
+    const signature = await crypto.subtle.sign('HMAC', key, encoder.encode(challenge));
+    return toHex(signature);
+}
+
 // ============================================
 // DeviceConnection - Single Device State
 // ============================================
@@ -318,15 +343,6 @@ class DeviceConnection {
 
             this.ws.onopen = () => {
                 eventLogger.connection(this.profile.name, 'Connected successfully');
-
-                if (this.profile.password) {
-                    this.ws.send(JSON.stringify({
-                        type: 'auth',
-                        password: this.profile.password
-                    }));
-                    eventLogger.connection(this.profile.name, 'Authentication sent');
-                }
-
                 this.updateState({ isConnected: true, isConnecting: false });
             };
 
@@ -336,11 +352,20 @@ class DeviceConnection {
 
             this.ws.onclose = () => {
                 eventLogger.connection(this.profile.name, 'Connection closed');
+                this.handlePresence(null);
+                this.handleControlStatus(null);
+                this.handleLinkQuality(null);
                 this.updateState({
                     isConnected: false,
                     isConnecting: false,
                     isLive: false
                 });
+                if (this.reconnectAfter) {
+                    const delay = this.reconnectAfter;
+                    this.reconnectAfter = null;
+                    eventLogger.connection(this.profile.name, `Reconnecting in ${delay / 1000}s`);
+                    setTimeout(() => this.connect(), delay);
+                }
             };
 
             this.ws.onerror = (error) => {
@@ -355,6 +380,7 @@ class DeviceConnection {
     }
 
     disconnect() {
+        this.reconnectAfter = null;
         if (this.ws) {
             this.ws.close();
             this.ws = null;
@@ -379,8 +405,206 @@ class DeviceConnection {
         return true;
     }
 
+    async authenticate(challenge, salt) {
+        if (!this.profile.password) {
+            eventLogger.error(this.profile.name, 'Relay requires a password');
+            return;
+        }
+        const response = await computeAuthResponse(this.profile.password, salt, challenge);
+        if (this.ws && this.ws.readyState === WebSocket.OPEN) {
+            this.ws.send(JSON.stringify({ type: 'auth', response }));
+            eventLogger.connection(this.profile.name, 'Authentication sent');
+        }
+    }
+
+    handleAlert(alert) {
+        if (!alert) return;
+        const label = `[${alert.severity.toUpperCase()}] ${alert.message}`;
+        if (alert.state === 'resolved') {
+            eventLogger.system(`Resolved: ${label}`, { id: alert.id });
+        } else if (alert.acknowledged) {
+            eventLogger.system(`Acknowledged: ${label}`, { id: alert.id });
+        } else {
+            eventLogger.error(alert.device, label, { id: alert.id, count: alert.count });
+        }
+    }
+
+    handlePresence(presence) {
+        const el = document.getElementById('presenceText');
+        if (!el) return;
+        if (!presence) {
+            el.textContent = '';
+            return;
+        }
+        const plural = (n, word) => `${n} ${word}${n === 1 ? '' : 's'}`;
+        el.textContent = [
+            plural(presence.browsers, 'operator'),
+            plural(presence.moblins, 'camera'),
+            plural(presence.overlays, 'overlay')
+        ].join(', ') + ' online';
+    }
+
+    handleLinkQuality(data) {
+        const el = document.getElementById('linkText');
+        if (!el) return;
+        if (!data) {
+            el.textContent = '';
+            return;
+        }
+        const link = data.data;
+        el.textContent = `${data.device} link ${Math.round(link.rtt_ms)} ms ±${Math.round(link.jitter_ms)}`;
+    }
+
+    acknowledgeAlert(id) {
+        return this.sendCommand('ack_alert', { id });
+    }
+
+    handleControlStatus(status) {
+        this.control = status;
+        const text = document.getElementById('controlText');
+        const btn = document.getElementById('controlBtn');
+        if (!text || !btn) return;
+        btn.hidden = !status;
+        text.classList.toggle('control-mine', !!status?.mine);
+        if (!status) {
+            text.textContent = '';
+            return;
+        }
+        if (!status.locked) {
+            text.textContent = '';
+            btn.textContent = 'Take control';
+        } else if (status.mine) {
+            text.textContent = 'You are director';
+            btn.textContent = 'Release control';
+        } else {
+            text.textContent = `Director: ${status.name || status.holder} (view only)`;
+            btn.textContent = 'Take over';
+        }
+        if (status.reason === 'stolen' && !status.mine) {
+            eventLogger.system(`${status.name || status.holder} took director control`);
+        } else if (status.reason === 'idle' || status.reason === 'disconnected') {
+            eventLogger.system(`Director control released (${status.reason})`);
+        }
+    }
+
+    handleSnapshot(snapshot) {
+        const state = this.snapshotDevice(snapshot?.devices || {});
+        if (!state) return;
+        eventLogger.connection(this.profile.name, `Restored last state of ${state.device}`);
+        // Stats take the stream_info path so analytics get the samples too
+        this.handleMessage({
+            type: 'stream_info',
+            bitrate: state.bitrate,
+            fps: state.fps,
+            battery: state.battery,
+            viewers: state.viewers,
+            thermal_state: state.thermal_state,
+            upload_stats: state.upload_stats
+        });
+        const stateUpdate = {
+            isLive: state.live,
+            isRecording: state.recording,
+            isMicMuted: state.mic_muted,
+            isTorchOn: state.torch_enabled
+        };
+        if (state.scene) stateUpdate.currentScene = state.scene;
+        this.updateState(stateUpdate);
+    }
+
+    // The device named in the profile URL, else the last updated connected one
+    snapshotDevice(devices) {
+        let wanted = null;
+        try {
+            wanted = new URL(this.profile.url, location.href).searchParams.get('device');
+        } catch (e) {
+            // Keep the fallback
+        }
+        if (wanted && devices[wanted]) return devices[wanted];
+        return Object.values(devices)
+            .filter(state => state.connected)
+            .sort((a, b) => (b.lastUpdated || '').localeCompare(a.lastUpdated || ''))[0] || null;
+    }
+
+    handleControlOffer(data) {
+        if (data.status === 'declined') {
+            eventLogger.system(data.message);
+            return;
+        }
+        const offer = data.data;
+        const from = offer.name || offer.from;
+        if (confirm(`${from} offers you director control. Accept?`)) {
+            this.sendCommand('accept_control', { name: this.operatorName() });
+        } else {
+            this.sendCommand('decline_control');
+        }
+    }
+
+    operatorName() {
+        let name = localStorage.getItem('operator_name');
+        if (name === null) {
+            name = (prompt('Your name (shown to other operators)') || '').trim().slice(0, 40);
+            localStorage.setItem('operator_name', name);
+        }
+        return name;
+    }
+
+    toggleControl() {
+        const status = this.control;
+        if (!status) return;
+        if (status.mine) {
+            this.sendCommand('release_control');
+        } else if (!status.locked) {
+            this.sendCommand('claim_control', { name: this.operatorName() });
+        } else if (confirm(`${status.name || status.holder} is director. Take control anyway?`)) {
+            this.sendCommand('claim_control', { name: this.operatorName(), steal: true });
+        }
+    }
+
     handleMessage(data) {
         switch (data.type) {
+            case 'hello':
+                if (data.challenge) {
+                    this.authenticate(data.challenge, data.salt);
+                }
+                break;
+
+            case 'auth_failed':
+                eventLogger.error(this.profile.name, `Authentication failed: ${data.message}`);
+                break;
+
+            case 'alert':
+                this.handleAlert(data.data);
+                break;
+
+            case 'presence':
+                this.handlePresence(data.data);
+                break;
+
+            case 'score_update':
+                window.matchState?.applyServerScore(data.data, data.version);
+                break;
+
+            case 'link_quality':
+                this.handleLinkQuality(data);
+                break;
+
+            case 'control_status':
+                this.handleControlStatus(data.data);
+                break;
+
+            case 'control_offer':
+                this.handleControlOffer(data);
+                break;
+
+            case 'server_restarting':
+                eventLogger.system(`${this.profile.name}: relay restarting`);
+                this.reconnectAfter = data.retry_after || 3000;
+                break;
+
+            case 'state_snapshot':
+                this.handleSnapshot(data.data);
+                break;
+
             case 'status':
             case 'stream_info':
                 const stateUpdate = {};
@@ -1285,6 +1509,12 @@ class MoblinMultiRemote {
         // Connect button (single device mode)
         this.ui.elements.connectBtn?.addEventListener('click', () => this.toggleConnection());
 
+        // Director control button (single device mode)
+        document.getElementById('controlBtn')?.addEventListener('click', () => {
+            if (!this.currentProfile) return;
+            this.registry.getDevice(this.currentProfile.id)?.toggleControl();
+        });
+
         // Scene buttons
         this.ui.elements.sceneGrid?.addEventListener('click', (e) => {
             if (e.target.classList.contains('scene-btn')) {
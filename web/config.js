@@ -46,6 +46,9 @@ window.VolleyBratans.API = {
     SCOUT_VERSION: '/api/scout/version',
     MATCHDAY: '/api/matchday',
     MATCHDAY_PARSE: '/api/matchday/parse',
+    MATCHDAY_POINT: '/api/matchday/point',
+    MATCHDAY_SET: '/api/matchday/set',
+    MATCH_EVENT: '/api/automation/event',
     AUTH_SESSION: '/api/auth/session',
     AUTH_LOGIN: '/api/auth/login',
     AUTH_LOGOUT: '/api/auth/logout'
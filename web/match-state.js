@@ -19,6 +19,9 @@ class MatchState {
         /** @type {MatchStateData} */
         this.data = this.load();
 
+        /** @type {number} Version of the last score received from the relay */
+        this.serverVersion = 0;
+
         // Listen for storage events from other tabs
         window.addEventListener('storage', (e) => {
             if (e.key === this.STORAGE_KEY) {
@@ -27,6 +30,8 @@ class MatchState {
             }
         });
 
+        this.fetchServerScore();
+
         console.log('[MatchState] Initialized with data:', this.data);
     }
 
@@ -138,14 +143,8 @@ class MatchState {
             this.data.awayPoints++;
         }
         this.save();
-
-        // Emit specific score event
-        document.dispatchEvent(new CustomEvent('matchstate:score', {
-            detail: {
-                homePoints: this.data.homePoints,
-                awayPoints: this.data.awayPoints
-            }
-        }));
+        this.emitScore();
+        this.syncScore(window.VB?.API?.MATCHDAY_POINT, { team });
     }
 
     /**
@@ -153,13 +152,19 @@ class MatchState {
      * @param {'home' | 'away'} team
      */
     subPoint(team) {
-        if (team === 'home' && this.data.homePoints > 0) {
+        const points = team === 'home' ? this.data.homePoints : this.data.awayPoints;
+        if (points === 0) return;
+        if (team === 'home') {
             this.data.homePoints--;
-        } else if (team === 'away' && this.data.awayPoints > 0) {
+        } else {
             this.data.awayPoints--;
         }
         this.save();
+        this.emitScore();
+        this.syncScore(window.VB?.API?.MATCHDAY_POINT, { team, delta: -1 });
+    }
 
+    emitScore() {
         document.dispatchEvent(new CustomEvent('matchstate:score', {
             detail: {
                 homePoints: this.data.homePoints,
@@ -168,6 +173,79 @@ class MatchState {
         }));
     }
 
+    // ========================================
+    // Relay Sync
+    // ========================================
+
+    /**
+     * Send a score change to the relay, which holds the authoritative score.
+     * The local change stays if there is no relay (standalone use).
+     * @param {string} path - Score endpoint
+     * @param {Object} body
+     */
+    async syncScore(path, body = {}) {
+        if (!path || !window.VB) return;
+        try {
+            const res = await fetch(`${window.VB.getApiBase()}${path}`, {
+                method: 'POST',
+                headers: { 'Content-Type': 'application/json' },
+                body: JSON.stringify(body)
+            });
+            if (res.ok) {
+                const state = await res.json();
+                this.applyServerScore(state.score, state.version);
+            } else {
+                // Rejected by the rules (e.g. set not won yet): go back to the relay's score
+                const err = await res.json().catch(() => ({}));
+                console.warn('[MatchState] Score change rejected:', err.error || res.status);
+                this.fetchServerScore();
+            }
+        } catch (e) {
+            console.warn('[MatchState] Score sync failed', e);
+        }
+    }
+
+    /**
+     * Load the relay's score
+     */
+    async fetchServerScore() {
+        if (!window.VB) return;
+        try {
+            const res = await fetch(`${window.VB.getApiBase()}${window.VB.API.MATCHDAY}`);
+            if (res.ok) {
+                const state = await res.json();
+                this.applyServerScore(state.score, state.version);
+            }
+        } catch (e) {
+            console.warn('[MatchState] Relay score not available', e);
+        }
+    }
+
+    /**
+     * Apply a score from the relay (REST response or score_update)
+     * @param {Object} score
+     * @param {number} version - Matchday version; older updates are ignored
+     */
+    applyServerScore(score, version) {
+        if (!score || version < this.serverVersion) return;
+        this.serverVersion = version;
+        Object.assign(this.data, {
+            homePoints: score.homePoints,
+            awayPoints: score.awayPoints,
+            homeSets: score.homeSets,
+            awaySets: score.awaySets,
+            currentSet: score.currentSet,
+            setHistory: score.setHistory || [],
+            serving: score.serving || '',
+            setBall: score.setBall || '',
+            matchBall: score.matchBall || '',
+            finished: !!score.finished,
+            winner: score.winner || ''
+        });
+        this.save();
+        this.emitScore();
+    }
+
     /**
      * End the current set and determine winner
      */
@@ -191,6 +269,7 @@ class MatchState {
         this.data.currentSet++;
 
         this.save();
+        this.syncScore(window.VB?.API?.MATCHDAY_SET);
     }
 
     /**
@@ -206,6 +285,7 @@ class MatchState {
         };
         this.data = { ...this.getDefaults(), ...teams };
         this.save();
+        this.syncScore(window.VB?.API?.MATCHDAY_SET, { action: 'reset' });
     }
 
     /**
@@ -214,6 +294,7 @@ class MatchState {
     fullReset() {
         this.data = this.getDefaults();
         this.save();
+        this.syncScore(window.VB?.API?.MATCHDAY_SET, { action: 'reset' });
     }
 
     // ========================================
@@ -277,6 +358,11 @@ class MatchState {
  * @property {number} awaySets
  * @property {number} currentSet
  * @property {Array<{home: number, away: number}>} setHistory
+ * @property {string} serving - 'home' or 'away', from the relay
+ * @property {string} setBall - Team one point from winning the set, from the relay
+ * @property {string} matchBall - Team one point from winning the match, from the relay
+ * @property {boolean} finished - Match decided, from the relay
+ * @property {string} winner - Winning team once finished
  * @property {number} version - Timestamp for sync
  */
 
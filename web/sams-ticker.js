@@ -1,7 +1,10 @@
 /**
  * SAMS Ticker Service - Real-time DVV/SBVV Match Data
- * Fetches live match data from the official SAMS backend API
- * 
+ * Live match data comes from the relay, which polls the SAMS backend for the
+ * matchday's match and broadcasts it as sams_update on the sams topic.
+ * Without a relay URL the class polls the SAMS backend API directly; that
+ * is only used for the one-off DVV import.
+ *
  * @description Service for integrating official SBVV volleyball ticker data
  * @version 1.0.0
  */
@@ -12,11 +15,15 @@ class SamsTickerService {
      * @param {Object} options - Configuration options
      * @param {number} options.pollInterval - Polling interval in ms (default: 5000)
      * @param {boolean} options.autoStart - Start polling immediately (default: false)
+     * @param {string} options.relayUrl - Relay WebSocket URL; when set, updates come
+     *   from the relay's sams_update instead of polling SAMS (pollInterval is the reconnect delay)
      */
     constructor(matchId, options = {}) {
         this.matchId = matchId;
         this.pollInterval = options.pollInterval || 5000;
         this.autoStart = options.autoStart || false;
+        this.relayUrl = options.relayUrl || null;
+        this.ws = null;
 
         this.apiUrl = 'https://backend.sams-ticker.de/live/indoor/tickers/dvv';
 
@@ -39,6 +46,11 @@ class SamsTickerService {
     start() {
         if (this.polling) return;
         this.polling = true;
+        if (this.relayUrl) {
+            this._connectRelay();
+            console.log('[SamsTickerService] Listening for sams_update from the relay');
+            return;
+        }
         this._poll();
         console.log(`[SamsTickerService] Started polling for match ${this.matchId}`);
     }
@@ -52,6 +64,10 @@ class SamsTickerService {
             clearTimeout(this.pollTimer);
             this.pollTimer = null;
         }
+        if (this.ws) {
+            this.ws.close();
+            this.ws = null;
+        }
         console.log(`[SamsTickerService] Stopped polling`);
     }
 
@@ -125,11 +141,57 @@ class SamsTickerService {
     setMatchId(newMatchId) {
         this.matchId = newMatchId;
         this.matchData = null;
-        if (this.polling) {
+        if (this.polling && !this.relayUrl) {
             this._poll(); // Immediate poll for new match
         }
     }
 
+    /**
+     * Internal: Receive sams_update from the relay, reconnecting after a delay
+     */
+    _connectRelay() {
+        const ws = new WebSocket(this.relayUrl);
+        this.ws = ws;
+
+        ws.onmessage = (event) => {
+            let message;
+            try {
+                message = JSON.parse(event.data);
+            } catch (e) {
+                return;
+            }
+            if (message.type === 'sams_update') {
+                this._applyRelayUpdate(message.data);
+            }
+        };
+
+        ws.onclose = () => {
+            if (this.ws !== ws) return;
+            this.ws = null;
+            if (!this.polling) return;
+            this._notifyError(new Error('Relay connection closed'));
+            this.pollTimer = setTimeout(() => this._connectRelay(), this.pollInterval);
+        };
+    }
+
+    /**
+     * Internal: Take over match data normalized by the relay. The relay only
+     * sends changes, so every update is passed on.
+     */
+    _applyRelayUpdate(match) {
+        // The relay follows the matchday's match; a fixed matchId filters others
+        if (!match || (this.matchId && match.id !== this.matchId)) return;
+
+        this.matchData = {
+            ...match,
+            serving: match.serving || null,
+            lastUpdate: new Date().toISOString()
+        };
+        this.rawData = null;
+        this.lastUpdate = new Date();
+        this._notifyListeners();
+    }
+
     /**
      * Internal: Polling loop
      */
@@ -216,6 +216,21 @@ class Scoreboard {
 
             this.saveData();
             this.render();
+            // In MatchState mode the relay logs set_end itself
+            this.logMatchEvent('set_end');
+        }
+    }
+
+    // Tell the relay, so automation rules can switch scenes
+    async logMatchEvent(event) {
+        try {
+            await fetch(`${window.VB.getApiBase()}${window.VB.API.MATCH_EVENT}`, {
+                method: 'POST',
+                headers: { 'Content-Type': 'application/json' },
+                body: JSON.stringify({ event })
+            });
+        } catch (e) {
+            console.warn('[Scoreboard] Match event not sent', e);
         }
     }
 